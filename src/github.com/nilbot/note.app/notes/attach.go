@@ -0,0 +1,371 @@
+package notes
+
+import (
+	"io"
+	"log"
+	"mime"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// attachmentRef matches the references of notes to their attachments:
+// attachment: followed by the hash of a blob, usually as the URL of a link
+// or an image named after the attachment, as in
+//
+//	![screenshot.png](attachment:9b71d224bd62f378...)
+var attachmentRef = regexp.MustCompile(`(?:\[([^\]\n]*)\]\()?attachment:([0-9a-f]{128})`)
+
+// Attachment is a blob referenced by a note.
+type Attachment struct {
+	Note string
+	Hash string
+	Name string
+	Size int64
+	Time time.Time
+}
+
+// attachmentRefs returns the hashes of the blobs referenced in content,
+// with the first name they are given, in order of appearance. Only the
+// public part of encrypted notes is searched.
+func attachmentRefs(content string) ([]string, map[string]string) {
+	var hashes []string
+	names := make(map[string]string)
+	for _, m := range attachmentRef.FindAllStringSubmatch(publicText(content), -1) {
+		if _, ok := names[m[2]]; !ok {
+			hashes = append(hashes, m[2])
+			names[m[2]] = m[1]
+		}
+	}
+	return hashes, names
+}
+
+// attachmentLink returns the reference to the blob with the given hash
+// added to a note attaching it as name: an image for image types, a link
+// otherwise.
+func attachmentLink(name, hash string) string {
+	name = strings.Map(func(r rune) rune {
+		if strings.ContainsRune("[]()\r\n", r) {
+			return '_'
+		}
+		return r
+	}, name)
+	link := "[" + name + "](attachment:" + hash + ")"
+	if strings.HasPrefix(mime.TypeByExtension(path.Ext(name)), "image/") {
+		return "!" + link
+	}
+	return link
+}
+
+// Attachments keeps the files attached to the notes of a NoteManager in a
+// BlobStore. Notes reference attachments in their content, and blobs no
+// note references any more are removed by Collect, which runs in the
+// background once started.
+type Attachments struct {
+	// Grace is how long blobs are kept without any reference, so a blob
+	// put while a note is being edited isn't removed before the note
+	// refers to it. It is one hour by default.
+	Grace time.Duration
+	// Interval is how often blobs are collected, besides whenever a
+	// note loses references. It is one hour by default.
+	Interval time.Duration
+
+	man   *NoteManager
+	blobs BlobStore
+	refs  *blobRefs
+	clock Clock
+	stop  chan struct{}
+	done  sync.WaitGroup
+}
+
+// blobRefs are the managers whose notes reference the blobs of a BlobStore:
+// the ones added, and the ones returned by all if set. gc is held for
+// writing while collecting, and for reading from the moment a staged blob
+// is committed until a note references it, so a blob isn't removed in
+// between.
+type blobRefs struct {
+	gc   sync.RWMutex
+	mu   sync.Mutex
+	mans []*NoteManager
+	all  func() ([]*NoteManager, error)
+}
+
+func (r *blobRefs) add(man *NoteManager) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !hasManager(r.mans, man) {
+		r.mans = append(r.mans, man)
+	}
+}
+
+func (r *blobRefs) managers() ([]*NoteManager, error) {
+	r.mu.Lock()
+	mans, all := append([]*NoteManager(nil), r.mans...), r.all
+	r.mu.Unlock()
+	if all == nil {
+		return mans, nil
+	}
+	more, err := all()
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range more {
+		if !hasManager(mans, m) {
+			mans = append(mans, m)
+		}
+	}
+	return mans, nil
+}
+
+func hasManager(mans []*NoteManager, man *NoteManager) bool {
+	for _, m := range mans {
+		if m == man {
+			return true
+		}
+	}
+	return false
+}
+
+// NewAttachments returns the attachments of the notes of man, held in
+// blobs.
+func NewAttachments(man *NoteManager, blobs BlobStore, c Clock) *Attachments {
+	return &Attachments{
+		Grace:    time.Hour,
+		Interval: time.Hour,
+		man:      man,
+		blobs:    blobs,
+		refs:     &blobRefs{mans: []*NoteManager{man}},
+		clock:    c,
+	}
+}
+
+// For returns the attachments of the notes of man held in the same blobs
+// as a, such as the attachments of another notebook. Collect keeps the
+// blobs referenced by the notes of every manager attachments were returned
+// for.
+func (a *Attachments) For(man *NoteManager) *Attachments {
+	a.refs.add(man)
+	return &Attachments{
+		Grace:    a.Grace,
+		Interval: a.Interval,
+		man:      man,
+		blobs:    a.blobs,
+		refs:     a.refs,
+		clock:    a.clock,
+	}
+}
+
+// ReferencedBy makes Collect also keep the blobs referenced by the notes of
+// the managers returned by mans, such as Library.Managers, so that blobs
+// are kept for notebooks not opened since the program started.
+func (a *Attachments) ReferencedBy(mans func() ([]*NoteManager, error)) {
+	a.refs.mu.Lock()
+	defer a.refs.mu.Unlock()
+	a.refs.all = mans
+}
+
+// Attach stores the content read from r and adds a reference to it, named
+// name, to the end of the note with the given ID. Attaching the same
+// content twice to a note adds a single reference. ErrEncrypted is returned
+// for encrypted notes, whose content can't be changed without their
+// passphrase.
+func (a *Attachments) Attach(id, name string, r io.Reader) (Attachment, error) {
+	n, ok := a.man.Find(id)
+	if !ok {
+		return Attachment{}, ErrNotFound
+	}
+	if IsEncrypted(n.Content) {
+		return Attachment{}, ErrEncrypted
+	}
+	// the content is read before taking the lock, so a slow upload doesn't
+	// hold collections back.
+	staged, err := a.blobs.Stage(r)
+	if err != nil {
+		return Attachment{}, err
+	}
+	defer staged.Discard()
+	a.refs.gc.RLock()
+	defer a.refs.gc.RUnlock()
+	b, err := staged.Commit()
+	if err != nil {
+		return Attachment{}, err
+	}
+
+	a.man.mu.Lock()
+	defer a.man.mu.Unlock()
+	old, ok := a.man.notes[id]
+	if !ok {
+		return Attachment{}, ErrNotFound
+	}
+	if IsEncrypted(old.Content) {
+		return Attachment{}, ErrEncrypted
+	}
+	att := Attachment{id, b.Hash, name, b.Size, b.Time}
+	if _, names := attachmentRefs(old.Content); hasRef(names, b.Hash) {
+		return att, nil
+	}
+	c := *old
+	if c.Content != "" && !strings.HasSuffix(c.Content, "\n") {
+		c.Content += "\n"
+	}
+	c.Content += attachmentLink(name, b.Hash)
+	if err := a.man.save(&c); err != nil {
+		return Attachment{}, err
+	}
+	return att, nil
+}
+
+// List returns the attachments referenced by the note with the given ID,
+// in order of appearance. Attachments missing from the blob store are left
+// out.
+func (a *Attachments) List(id string) ([]Attachment, error) {
+	n, ok := a.man.Find(id)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	hashes, names := attachmentRefs(n.Content)
+	atts := []Attachment{}
+	for _, h := range hashes {
+		r, b, err := a.blobs.Open(h)
+		if err == ErrNoBlob {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		r.Close()
+		atts = append(atts, Attachment{id, h, names[h], b.Size, b.Time})
+	}
+	return atts, nil
+}
+
+// Open returns a reader of the attachment of the note with the given ID
+// with the given hash. The note must reference it, otherwise ErrNoBlob is
+// returned.
+func (a *Attachments) Open(id, hash string) (BlobReader, Attachment, error) {
+	n, ok := a.man.Find(id)
+	if !ok {
+		return nil, Attachment{}, ErrNotFound
+	}
+	_, names := attachmentRefs(n.Content)
+	name, ok := names[hash]
+	if !ok {
+		return nil, Attachment{}, ErrNoBlob
+	}
+	r, b, err := a.blobs.Open(hash)
+	if err != nil {
+		return nil, Attachment{}, err
+	}
+	return r, Attachment{id, hash, name, b.Size, b.Time}, nil
+}
+
+// Collect removes the blobs no note references that are older than Grace
+// and returns their hashes, in order. Revisions of notes don't keep blobs, so
+// reverting a note may leave it with references to removed attachments.
+// Attaching waits for the collection under way.
+func (a *Attachments) Collect() ([]string, error) {
+	a.refs.gc.Lock()
+	defer a.refs.gc.Unlock()
+	bs, err := a.blobs.List()
+	if err != nil {
+		return nil, err
+	}
+	mans, err := a.refs.managers()
+	if err != nil {
+		return nil, err
+	}
+	used := make(map[string]bool)
+	for _, man := range mans {
+		man.mu.RLock()
+		for _, n := range man.notes {
+			hashes, _ := attachmentRefs(n.Content)
+			for _, h := range hashes {
+				used[h] = true
+			}
+		}
+		man.mu.RUnlock()
+	}
+
+	cutoff := a.clock.Now().Add(-a.Grace)
+	removed := []string{}
+	for _, b := range bs {
+		if used[b.Hash] || b.Time.After(cutoff) {
+			continue
+		}
+		if err := a.blobs.Remove(b.Hash); err != nil {
+			return removed, err
+		}
+		removed = append(removed, b.Hash)
+	}
+	return removed, nil
+}
+
+// Start starts collecting blobs in the background.
+func (a *Attachments) Start() {
+	a.stop = make(chan struct{})
+	sub := a.man.Subscribe(16, DropNewest)
+	a.done.Add(1)
+	go func() {
+		defer a.done.Done()
+		defer sub.Close()
+		a.run(sub.Events())
+	}()
+}
+
+// Stop stops collecting blobs and waits for the collection under way.
+func (a *Attachments) Stop() {
+	close(a.stop)
+	a.done.Wait()
+}
+
+// run collects blobs every Interval and whenever a note loses references,
+// until stopped.
+func (a *Attachments) run(changes <-chan Event) {
+	tick := a.clock.After(a.Interval)
+	for {
+		select {
+		case <-tick:
+			tick = a.clock.After(a.Interval)
+		case e, ok := <-changes:
+			if !ok {
+				// the manager was closed.
+				return
+			}
+			if !dropsRefs(e) {
+				continue
+			}
+		case <-a.stop:
+			return
+		}
+		if _, err := a.Collect(); err != nil {
+			log.Printf("collect attachments: %v", err)
+		}
+	}
+}
+
+// dropsRefs reports whether the note changed by e lost references to
+// attachments.
+func dropsRefs(e Event) bool {
+	if e.Before == nil {
+		return false
+	}
+	before, _ := attachmentRefs(e.Before.Content)
+	if e.After == nil {
+		return len(before) > 0
+	}
+	_, after := attachmentRefs(e.After.Content)
+	for _, h := range before {
+		if !hasRef(after, h) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasRef reports whether the names returned by attachmentRefs hold hash.
+func hasRef(names map[string]string, hash string) bool {
+	_, ok := names[hash]
+	return ok
+}
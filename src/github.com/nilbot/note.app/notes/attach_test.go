@@ -0,0 +1,346 @@
+package notes
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func attachOrFatal(t *testing.T, a *Attachments, id, name, content string) Attachment {
+	att, err := a.Attach(id, name, strings.NewReader(content))
+	if err != nil {
+		t.Fatalf("attach: %v", err)
+	}
+	return att
+}
+
+func readBlob(t *testing.T, r BlobReader) string {
+	defer r.Close()
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	return string(b)
+}
+
+func TestAttach(t *testing.T) {
+	man := NewNoteManager()
+	a := NewAttachments(man, NewMemoryBlobStore(), SystemClock)
+	editOrFatal(t, man, "aaaa0001", "crash on start #bug")
+
+	shot := attachOrFatal(t, a, "aaaa0001", "screen shot.png", "PNG...")
+	log := attachOrFatal(t, a, "aaaa0001", "app.log", "panic: nil map")
+	if shot.Hash != digest("PNG...") || shot.Size != 6 || shot.Name != "screen shot.png" {
+		t.Errorf("expected the attachment to be addressed by its digest, got %+v", shot)
+	}
+	attachOrFatal(t, a, "aaaa0001", "again.log", "panic: nil map")
+	n, _ := man.Find("aaaa0001")
+	expected := "crash on start #bug\n![screen shot.png](attachment:" + shot.Hash + ")\n[app.log](attachment:" + log.Hash + ")"
+	if n.Content != expected {
+		t.Errorf("expected references to the attachments, got %q", n.Content)
+	}
+
+	atts, err := a.List("aaaa0001")
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	if len(atts) != 2 || atts[0] != shot || atts[1].Name != "app.log" {
+		t.Errorf("expected both attachments, got %+v", atts)
+	}
+	r, att, err := a.Open("aaaa0001", log.Hash)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	if r.Seek(7, io.SeekStart); readBlob(t, r) != "nil map" || att.Name != "app.log" {
+		t.Errorf("expected to read the attachment from an offset, got %+v", att)
+	}
+
+	editOrFatal(t, man, "aaaa0002", "unrelated")
+	if _, _, err := a.Open("aaaa0002", log.Hash); err != ErrNoBlob {
+		t.Errorf("expected attachments of other notes not to be found, got %v", err)
+	}
+	if _, err := a.Attach("missing", "x", strings.NewReader("x")); err != ErrNotFound {
+		t.Errorf("expected a missing note, got %v", err)
+	}
+	defer fastKDF()()
+	saveEncryptedOrFatal(t, man, &Note{ID: "aaaa0003", Content: "secret"}, "pw")
+	if _, err := a.Attach("aaaa0003", "x", strings.NewReader("x")); err != ErrEncrypted {
+		t.Errorf("expected encrypted notes to be refused, got %v", err)
+	}
+}
+
+func TestCollectAttachments(t *testing.T) {
+	man := NewNoteManager()
+	blobs := NewMemoryBlobStore()
+	a := NewAttachments(man, blobs, SystemClock)
+	editOrFatal(t, man, "aaaa0001", "one")
+	editOrFatal(t, man, "aaaa0002", "two")
+	shared := attachOrFatal(t, a, "aaaa0001", "shared.txt", "shared")
+	attachOrFatal(t, a, "aaaa0002", "shared.txt", "shared")
+	own := attachOrFatal(t, a, "aaaa0002", "own.txt", "own")
+	orphan, err := blobs.Put(strings.NewReader("orphan"))
+	if err != nil {
+		t.Fatalf("put: %v", err)
+	}
+
+	if removed, _ := a.Collect(); len(removed) != 0 {
+		t.Errorf("expected blobs within the grace period to be kept, got %v", removed)
+	}
+	a.Grace = 0
+	if removed, _ := a.Collect(); !reflect.DeepEqual(removed, []string{orphan.Hash}) {
+		t.Errorf("expected the unreferenced blob to be removed, got %v", removed)
+	}
+	if err := man.Delete("aaaa0002"); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if removed, _ := a.Collect(); !reflect.DeepEqual(removed, []string{own.Hash}) {
+		t.Errorf("expected only the blob of the deleted note to be removed, got %v", removed)
+	}
+	if _, _, err := blobs.Open(shared.Hash); err != nil {
+		t.Errorf("expected the shared blob to be kept, got %v", err)
+	}
+}
+
+func TestCollectAttachmentsInBackground(t *testing.T) {
+	man := NewNoteManager()
+	blobs := NewMemoryBlobStore()
+	a := NewAttachments(man, blobs, SystemClock)
+	a.Grace = 0
+	editOrFatal(t, man, "aaaa0001", "one")
+	att := attachOrFatal(t, a, "aaaa0001", "a.txt", "a")
+	a.Start()
+	defer a.Stop()
+
+	editOrFatal(t, man, "aaaa0001", "one #edited")
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(time.Millisecond) {
+		if _, _, err := blobs.Open(att.Hash); err == ErrNoBlob {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the blob to be removed once unreferenced")
+		}
+	}
+}
+
+func TestFileBlobStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "blobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s, err := OpenFileBlobStore(dir)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	b, err := s.Put(strings.NewReader("hello"))
+	if err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	if b.Hash != digest("hello") || b.Size != 5 {
+		t.Errorf("expected a blob addressed by its digest, got %+v", b)
+	}
+	if _, err := s.Put(strings.NewReader("hello")); err != nil {
+		t.Fatalf("put again: %v", err)
+	}
+	if bs, _ := s.List(); len(bs) != 1 || bs[0].Hash != b.Hash {
+		t.Errorf("expected the content to be held once, got %+v", bs)
+	}
+	r, got, err := s.Open(b.Hash)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	if readBlob(t, r) != "hello" || got.Size != 5 {
+		t.Errorf("expected the blob back, got %+v", got)
+	}
+	if _, _, err := s.Open("../" + b.Hash[3:]); err != ErrNoBlob {
+		t.Errorf("expected invalid hashes to be refused, got %v", err)
+	}
+	if err := s.Remove(b.Hash); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	if _, _, err := s.Open(b.Hash); err != ErrNoBlob {
+		t.Errorf("expected the blob to be removed, got %v", err)
+	}
+
+	staged, err := s.Stage(strings.NewReader("hello"))
+	if err != nil {
+		t.Fatalf("stage: %v", err)
+	}
+	if staged.Hash() != b.Hash {
+		t.Errorf("expected the staged content to be hashed, got %s", staged.Hash())
+	}
+	if bs, _ := s.List(); len(bs) != 0 {
+		t.Errorf("expected staged content not to be held until committed, got %+v", bs)
+	}
+	if err := staged.Discard(); err != nil {
+		t.Fatalf("discard: %v", err)
+	}
+	if fs, _ := ioutil.ReadDir(dir); len(fs) != 1 || !fs[0].IsDir() {
+		t.Errorf("expected the staged content to be dropped, got %d files", len(fs))
+	}
+}
+
+// racyBlobs attaches content to a note whenever blobs are listed, and holds
+// the attachment back once staged until a blob is removed, as if it were
+// uploaded while blobs are being collected.
+type racyBlobs struct {
+	BlobStore
+	attach  func()
+	put     chan bool
+	removed chan bool
+}
+
+func (s *racyBlobs) List() ([]Blob, error) {
+	bs, err := s.BlobStore.List()
+	go s.attach()
+	select {
+	case <-s.put:
+	case <-time.After(100 * time.Millisecond):
+	}
+	return bs, err
+}
+
+func (s *racyBlobs) Stage(r io.Reader) (StagedBlob, error) {
+	b, err := s.BlobStore.Stage(r)
+	s.put <- true
+	select {
+	case <-s.removed:
+	case <-time.After(100 * time.Millisecond):
+	}
+	return b, err
+}
+
+func (s *racyBlobs) Remove(hash string) error {
+	err := s.BlobStore.Remove(hash)
+	select {
+	case s.removed <- true:
+	default:
+	}
+	return err
+}
+
+func TestCollectWhileAttaching(t *testing.T) {
+	man := NewNoteManager()
+	editOrFatal(t, man, "aaaa0001", "one")
+	blobs := &racyBlobs{BlobStore: NewMemoryBlobStore(), put: make(chan bool, 1), removed: make(chan bool)}
+	if _, err := blobs.BlobStore.Put(strings.NewReader("plan")); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	a := NewAttachments(man, blobs, SystemClock)
+	a.Grace = 0
+	done := make(chan Attachment)
+	blobs.attach = func() {
+		att, _ := a.Attach("aaaa0001", "plan.txt", strings.NewReader("plan"))
+		done <- att
+	}
+	if _, err := a.Collect(); err != nil {
+		t.Fatalf("collect: %v", err)
+	}
+	att := <-done
+	if _, _, err := a.Open("aaaa0001", att.Hash); err != nil {
+		t.Errorf("expected the blob attached while collecting to be kept, got %v", err)
+	}
+}
+
+func TestCollectWhileUploading(t *testing.T) {
+	man := NewNoteManager()
+	editOrFatal(t, man, "aaaa0001", "one")
+	a := NewAttachments(man, NewMemoryBlobStore(), SystemClock)
+	a.Grace = 0
+	r, w := io.Pipe()
+	done := make(chan Attachment)
+	go func() {
+		att, _ := a.Attach("aaaa0001", "plan.txt", r)
+		done <- att
+	}()
+	// the write returns once the upload is under way.
+	w.Write([]byte("pl"))
+	collected := make(chan error)
+	go func() {
+		_, err := a.Collect()
+		collected <- err
+	}()
+	select {
+	case err := <-collected:
+		if err != nil {
+			t.Fatalf("collect: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected blobs to be collected while uploading")
+	}
+	w.Write([]byte("an"))
+	w.Close()
+	att := <-done
+	if att.Hash != digest("plan") {
+		t.Fatalf("expected the upload to be attached, got %+v", att)
+	}
+	if _, _, err := a.Open("aaaa0001", att.Hash); err != nil {
+		t.Errorf("expected the blob uploaded while collecting to be kept, got %v", err)
+	}
+}
+
+func TestCollectSharedAttachments(t *testing.T) {
+	work, home := NewNoteManager(), NewNoteManager()
+	blobs := NewMemoryBlobStore()
+	a := NewAttachments(work, blobs, SystemClock)
+	a.Grace = 0
+	b := a.For(home)
+	editOrFatal(t, work, "aaaa0001", "work")
+	editOrFatal(t, home, "aaaa0001", "home")
+	att := attachOrFatal(t, b, "aaaa0001", "plan.txt", "plan")
+	if _, _, err := a.Open("aaaa0001", att.Hash); err != ErrNoBlob {
+		t.Errorf("expected attachments of other managers not to be found, got %v", err)
+	}
+	if removed, _ := a.Collect(); len(removed) != 0 {
+		t.Errorf("expected blobs referenced by other managers to be kept, got %v", removed)
+	}
+	editOrFatal(t, home, "aaaa0001", "home")
+	if removed, _ := a.Collect(); !reflect.DeepEqual(removed, []string{att.Hash}) {
+		t.Errorf("expected the unreferenced blob to be removed, got %v", removed)
+	}
+}
+
+func TestCollectLibraryAttachments(t *testing.T) {
+	defer fastKDF()()
+	dir := tempDirOrFatal(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "library.json")
+	open := func(nb string) (Store, error) {
+		return OpenFileStore(filepath.Join(dir, "notebooks", nb))
+	}
+	l, err := OpenLibrary(path, open)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	addUserOrFatal(t, l, "alice")
+	l.CreateNotebook("work", "alice")
+	l.CreateNotebook("home", "alice")
+	work, _ := l.Manager("work")
+	home, _ := l.Manager("home")
+	blobs := NewMemoryBlobStore()
+	editOrFatal(t, home, "aaaa0001", "home")
+	att := attachOrFatal(t, NewAttachments(work, blobs, SystemClock).For(home), "aaaa0001", "plan.txt", "plan")
+	l.Close()
+
+	// after a restart, only the notebook in use is opened.
+	l, err = OpenLibrary(path, open)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer l.Close()
+	work, _ = l.Manager("work")
+	a := NewAttachments(work, blobs, SystemClock)
+	a.Grace = 0
+	a.ReferencedBy(l.Managers)
+	if removed, _ := a.Collect(); len(removed) != 0 {
+		t.Errorf("expected blobs referenced by notebooks not opened to be kept, got %v", removed)
+	}
+	if _, _, err := blobs.Open(att.Hash); err != nil {
+		t.Errorf("expected the blob to be kept, got %v", err)
+	}
+}
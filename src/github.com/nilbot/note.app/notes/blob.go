@@ -0,0 +1,300 @@
+package notes
+
+import (
+	"bytes"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrNoBlob is returned when a blob store holds no blob with a hash.
+var ErrNoBlob = errors.New("blob not found")
+
+// Blob describes content held by a BlobStore. Hash is the hex encoded
+// sha512 of the content, as the digest of a note, and Time when it was
+// last put.
+type Blob struct {
+	Hash string
+	Size int64
+	Time time.Time
+}
+
+// BlobReader reads the content of a blob, anywhere in it.
+type BlobReader interface {
+	io.ReadSeeker
+	io.Closer
+}
+
+// StagedBlob is content read by a BlobStore but not stored yet.
+type StagedBlob interface {
+	// Hash returns the hash of the content.
+	Hash() string
+	// Commit stores the content, as Put. It may be called once.
+	Commit() (Blob, error)
+	// Discard drops the content unless it was committed.
+	Discard() error
+}
+
+// BlobStore holds content by its hash, so the same content is only held
+// once.
+type BlobStore interface {
+	// Put stores the content read from r. Putting content already held
+	// updates the time of its blob.
+	Put(r io.Reader) (Blob, error)
+	// Stage reads the content from r and hashes it, without storing it:
+	// it is stored once committed, so that reading it, which may take a
+	// while, and storing it may be done apart.
+	Stage(r io.Reader) (StagedBlob, error)
+	// Open returns a reader of the blob with the given hash, or ErrNoBlob.
+	Open(hash string) (BlobReader, Blob, error)
+	// Remove removes the blob with the given hash, if any.
+	Remove(hash string) error
+	// List returns every blob held, ordered by hash.
+	List() ([]Blob, error)
+}
+
+// validHash reports whether hash is a hex encoded sha512 as returned by
+// digest, so it is safe to use as a file name.
+func validHash(hash string) bool {
+	if len(hash) != sha512.Size*2 {
+		return false
+	}
+	for _, c := range hash {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+type memoryBlobStore struct {
+	mu    sync.RWMutex
+	blobs map[string]*memoryBlob
+}
+
+type memoryBlob struct {
+	data []byte
+	time time.Time
+}
+
+// NewMemoryBlobStore returns a BlobStore that keeps blobs in memory only.
+func NewMemoryBlobStore() BlobStore {
+	return &memoryBlobStore{blobs: make(map[string]*memoryBlob)}
+}
+
+func (s *memoryBlobStore) Put(r io.Reader) (Blob, error) {
+	staged, err := s.Stage(r)
+	if err != nil {
+		return Blob{}, err
+	}
+	return staged.Commit()
+}
+
+func (s *memoryBlobStore) Stage(r io.Reader) (StagedBlob, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return &stagedMemoryBlob{s, fmt.Sprintf("%x", sha512.Sum512(data)), data}, nil
+}
+
+type stagedMemoryBlob struct {
+	s    *memoryBlobStore
+	hash string
+	data []byte
+}
+
+func (b *stagedMemoryBlob) Hash() string { return b.hash }
+
+func (b *stagedMemoryBlob) Commit() (Blob, error) {
+	s := b.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	mb, ok := s.blobs[b.hash]
+	if !ok {
+		mb = &memoryBlob{data: b.data}
+		s.blobs[b.hash] = mb
+	}
+	mb.time = time.Now()
+	return Blob{b.hash, int64(len(mb.data)), mb.time}, nil
+}
+
+func (b *stagedMemoryBlob) Discard() error { return nil }
+
+type bytesBlobReader struct {
+	*bytes.Reader
+}
+
+func (bytesBlobReader) Close() error { return nil }
+
+func (s *memoryBlobStore) Open(hash string) (BlobReader, Blob, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	b, ok := s.blobs[hash]
+	if !ok {
+		return nil, Blob{}, ErrNoBlob
+	}
+	return bytesBlobReader{bytes.NewReader(b.data)}, Blob{hash, int64(len(b.data)), b.time}, nil
+}
+
+func (s *memoryBlobStore) Remove(hash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.blobs, hash)
+	return nil
+}
+
+func (s *memoryBlobStore) List() ([]Blob, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	bs := make([]Blob, 0, len(s.blobs))
+	for hash, b := range s.blobs {
+		bs = append(bs, Blob{hash, int64(len(b.data)), b.time})
+	}
+	sort.Slice(bs, func(i, j int) bool { return bs[i].Hash < bs[j].Hash })
+	return bs, nil
+}
+
+// fileBlobStore keeps every blob in a file named after its hash, in a
+// directory named after the first two digits of the hash.
+type fileBlobStore struct {
+	dir string
+}
+
+// OpenFileBlobStore opens, or creates, a file backed BlobStore in dir.
+func OpenFileBlobStore(dir string) (BlobStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &fileBlobStore{dir}, nil
+}
+
+func (s *fileBlobStore) path(hash string) string {
+	return filepath.Join(s.dir, hash[:2], hash)
+}
+
+func (s *fileBlobStore) Put(r io.Reader) (Blob, error) {
+	staged, err := s.Stage(r)
+	if err != nil {
+		return Blob{}, err
+	}
+	defer staged.Discard()
+	return staged.Commit()
+}
+
+// Stage writes the content to a temporary file while hashing it, which
+// Commit renames into place, so a blob is never seen partly written.
+func (s *fileBlobStore) Stage(r io.Reader) (StagedBlob, error) {
+	tmp, err := ioutil.TempFile(s.dir, "put-")
+	if err != nil {
+		return nil, err
+	}
+	h := sha512.New()
+	size, err := io.Copy(io.MultiWriter(tmp, h), r)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+	return &stagedFileBlob{s, tmp.Name(), fmt.Sprintf("%x", h.Sum(nil)), size}, nil
+}
+
+// stagedFileBlob is content written to the temporary file tmp.
+type stagedFileBlob struct {
+	s    *fileBlobStore
+	tmp  string
+	hash string
+	size int64
+}
+
+func (b *stagedFileBlob) Hash() string { return b.hash }
+
+func (b *stagedFileBlob) Commit() (Blob, error) {
+	path := b.s.path(b.hash)
+	now := time.Now()
+	if _, err := os.Stat(path); err == nil {
+		return Blob{b.hash, b.size, now}, os.Chtimes(path, now, now)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return Blob{}, err
+	}
+	if err := os.Chtimes(b.tmp, now, now); err != nil {
+		return Blob{}, err
+	}
+	if err := os.Rename(b.tmp, path); err != nil {
+		return Blob{}, err
+	}
+	return Blob{b.hash, b.size, now}, syncDir(filepath.Dir(path))
+}
+
+func (b *stagedFileBlob) Discard() error {
+	if err := os.Remove(b.tmp); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (s *fileBlobStore) Open(hash string) (BlobReader, Blob, error) {
+	if !validHash(hash) {
+		return nil, Blob{}, ErrNoBlob
+	}
+	f, err := os.Open(s.path(hash))
+	if os.IsNotExist(err) {
+		return nil, Blob{}, ErrNoBlob
+	}
+	if err != nil {
+		return nil, Blob{}, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, Blob{}, err
+	}
+	return f, Blob{hash, fi.Size(), fi.ModTime()}, nil
+}
+
+func (s *fileBlobStore) Remove(hash string) error {
+	if !validHash(hash) {
+		return nil
+	}
+	if err := os.Remove(s.path(hash)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (s *fileBlobStore) List() ([]Blob, error) {
+	var bs []Blob
+	dirs, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range dirs {
+		if !d.IsDir() || len(d.Name()) != 2 {
+			continue
+		}
+		fs, err := ioutil.ReadDir(filepath.Join(s.dir, d.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, fi := range fs {
+			if validHash(fi.Name()) && fi.Name()[:2] == d.Name() {
+				bs = append(bs, Blob{fi.Name(), fi.Size(), fi.ModTime()})
+			}
+		}
+	}
+	// ReadDir sorts by name, so blobs are ordered by hash already.
+	return bs, nil
+}
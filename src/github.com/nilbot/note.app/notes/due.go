@@ -0,0 +1,129 @@
+package notes
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Schedule is when a note is due and when to be reminded of it, written in
+// its content as
+//
+//	#todo due:2026-11-01 remind:09:00
+//
+// due: takes a date or a date and time, 2026-11-01T17:00. remind: takes a
+// time on the due date, a date or a date and time. Times without a date are
+// ignored when there is no due date. Either time is zero if missing.
+type Schedule struct {
+	Due    time.Time
+	Remind time.Time
+}
+
+var schedulePattern = regexp.MustCompile(`(?:^|\s)(due|remind):(\S+)`)
+
+const (
+	dateLayout     = "2006-01-02"
+	dateTimeLayout = "2006-01-02T15:04"
+	timeLayout     = "15:04"
+)
+
+// ParseSchedule returns the schedule written in content, with times in loc.
+// The first valid due: and remind: outside of fenced code blocks count.
+func ParseSchedule(content string, loc *time.Location) Schedule {
+	var s Schedule
+	if !strings.Contains(content, "due:") && !strings.Contains(content, "remind:") {
+		return s
+	}
+	var remind string
+	fenced := false
+	for _, l := range strings.Split(content, "\n") {
+		if isFence(l) {
+			fenced = !fenced
+			continue
+		}
+		if fenced {
+			continue
+		}
+		for _, m := range schedulePattern.FindAllStringSubmatch(l, -1) {
+			switch {
+			case m[1] == "due" && s.Due.IsZero():
+				s.Due = parseScheduleTime(m[2], loc)
+			case m[1] == "remind" && remind == "":
+				if !parseScheduleTime(m[2], loc).IsZero() || isTimeOfDay(m[2]) {
+					remind = m[2]
+				}
+			}
+		}
+	}
+	if isTimeOfDay(remind) {
+		if !s.Due.IsZero() {
+			t, _ := time.Parse(timeLayout, remind)
+			y, mo, d := s.Due.Date()
+			s.Remind = time.Date(y, mo, d, t.Hour(), t.Minute(), 0, 0, loc)
+		}
+	} else if remind != "" {
+		s.Remind = parseScheduleTime(remind, loc)
+	}
+	return s
+}
+
+// parseScheduleTime parses a date or a date and time, zero if it is
+// neither.
+func parseScheduleTime(v string, loc *time.Location) time.Time {
+	for _, layout := range []string{dateTimeLayout, dateLayout} {
+		if t, err := time.ParseInLocation(layout, v, loc); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func isTimeOfDay(v string) bool {
+	_, err := time.Parse(timeLayout, v)
+	return err == nil
+}
+
+// Schedule returns the schedule of the note with the given ID, with times
+// in loc.
+func (man *NoteManager) Schedule(id string, loc *time.Location) (Schedule, error) {
+	man.mu.RLock()
+	defer man.mu.RUnlock()
+	n, ok := man.notes[id]
+	if !ok {
+		return Schedule{}, ErrNotFound
+	}
+	return ParseSchedule(n.Content, loc), nil
+}
+
+// Reminder is the reminder of a note due at Due, to fire at At.
+type Reminder struct {
+	Note  string
+	Title string
+	Due   time.Time
+	At    time.Time
+}
+
+// Reminders returns the reminders of the notes that are not archived to
+// fire after the given time, in the order they fire.
+func (man *NoteManager) Reminders(after time.Time, loc *time.Location) []Reminder {
+	man.mu.RLock()
+	defer man.mu.RUnlock()
+	rs := []Reminder{}
+	for _, n := range man.notes {
+		if n.Archived {
+			continue
+		}
+		s := ParseSchedule(n.Content, loc)
+		if s.Remind.After(after) {
+			rs = append(rs, Reminder{n.ID, n.DisplayTitle(), s.Due, s.Remind})
+		}
+	}
+	sort.Slice(rs, func(i, j int) bool {
+		if !rs[i].At.Equal(rs[j].At) {
+			return rs[i].At.Before(rs[j].At)
+		}
+		return rs[i].Note < rs[j].Note
+	})
+	return rs
+}
@@ -0,0 +1,56 @@
+package notes
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseSchedule(t *testing.T) {
+	at := func(s string) time.Time {
+		v, err := time.ParseInLocation(dateTimeLayout, s, time.UTC)
+		if err != nil {
+			t.Fatalf("parse %q: %v", s, err)
+		}
+		return v
+	}
+	for _, tc := range []struct {
+		content string
+		s       Schedule
+	}{
+		{"#todo due:2026-11-01 remind:09:00", Schedule{at("2026-11-01T00:00"), at("2026-11-01T09:00")}},
+		{"due:2026-11-01T17:30\nremind:2026-10-31", Schedule{at("2026-11-01T17:30"), at("2026-10-31T00:00")}},
+		{"remind:2026-10-31T08:15 only", Schedule{time.Time{}, at("2026-10-31T08:15")}},
+		{"remind:09:00 without due", Schedule{}},
+		{"due:tomorrow due:2026-11-01 remind:25:00 remind:10:00", Schedule{at("2026-11-01T00:00"), at("2026-11-01T10:00")}},
+		{"overdue:2026-11-01", Schedule{}},
+		{"```\ndue:2026-11-01\n```", Schedule{}},
+	} {
+		if s := ParseSchedule(tc.content, time.UTC); !reflect.DeepEqual(s, tc.s) {
+			t.Errorf("parse schedule %q: expected %v, got %v", tc.content, tc.s, s)
+		}
+	}
+}
+
+func TestReminders(t *testing.T) {
+	man := NewNoteManager()
+	for _, n := range []*Note{
+		{ID: "aaaa0001", Content: "Dentist due:2026-11-02 remind:08:00"},
+		{ID: "aaaa0002", Content: "Taxes due:2026-11-01 remind:09:00"},
+		{ID: "aaaa0003", Content: "Old due:2026-11-01 remind:07:00", Archived: true},
+		{ID: "aaaa0004", Content: "Past due:2026-10-01 remind:09:00"},
+		{ID: "aaaa0005", Content: "No reminder due:2026-11-01"},
+	} {
+		if err := man.Save(n); err != nil {
+			t.Fatalf("save: %v", err)
+		}
+	}
+	after := time.Date(2026, 10, 17, 0, 0, 0, 0, time.UTC)
+	var ids []string
+	for _, r := range man.Reminders(after, time.UTC) {
+		ids = append(ids, r.Note)
+	}
+	if expected := []string{"aaaa0002", "aaaa0001"}; !reflect.DeepEqual(ids, expected) {
+		t.Errorf("expected reminders of %v, got %v", expected, ids)
+	}
+}
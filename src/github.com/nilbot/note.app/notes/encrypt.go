@@ -0,0 +1,320 @@
+package notes
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// sealedType is the PEM type of the block holding an encrypted note.
+const sealedType = "ENCRYPTED NOTE"
+
+// sealedCipher is the authenticated encryption notes are encrypted with.
+const sealedCipher = "AES-256-GCM"
+
+var (
+	// ErrWrongPassphrase is returned when an encrypted note can't be
+	// decrypted, because the passphrase is wrong or the note was tampered
+	// with.
+	ErrWrongPassphrase = errors.New("wrong passphrase")
+	// ErrEmptyPassphrase is returned when encrypting with an empty
+	// passphrase.
+	ErrEmptyPassphrase = errors.New("empty passphrase")
+	// ErrEncrypted is returned when encrypting a note that already is.
+	ErrEncrypted = errors.New("note is encrypted")
+	// ErrNotEncrypted is returned when decrypting a note that isn't.
+	ErrNotEncrypted = errors.New("note is not encrypted")
+	// ErrUnsupportedKDF is returned when decrypting a note whose key
+	// derivation parameters are out of the range accepted.
+	ErrUnsupportedKDF = errors.New("unsupported key derivation parameters")
+)
+
+// KDF are the parameters of the derivation of keys from passphrases.
+type KDF struct {
+	Name       string
+	Iterations int
+}
+
+// DefaultKDF is used to encrypt notes. Every note keeps the parameters it
+// was encrypted with, so DefaultKDF may be raised at any time: older notes
+// still decrypt and RotateKey moves them to the new parameters.
+var DefaultKDF = KDF{Name: "PBKDF2-SHA256", Iterations: 600000}
+
+// kdfRange bounds the iteration counts of encrypted notes, which may have
+// been written by anyone: a note may be decrypted only if its count is
+// within a factor kdfRange of the one of DefaultKDF, so that deriving its
+// key can't take forever.
+const kdfRange = 16
+
+// accepted reports whether notes encrypted with k may be decrypted.
+func (k KDF) accepted() bool {
+	return k.Iterations >= DefaultKDF.Iterations/kdfRange && k.Iterations <= DefaultKDF.Iterations*kdfRange
+}
+
+// key derives a key for AES-256 from passphrase and salt.
+func (k KDF) key(passphrase string, salt []byte) ([]byte, error) {
+	if k.Name != "PBKDF2-SHA256" {
+		return nil, fmt.Errorf("unknown key derivation %q", k.Name)
+	}
+	if k.Iterations < 1 {
+		return nil, fmt.Errorf("invalid iteration count %d", k.Iterations)
+	}
+	return pbkdf2.Key(sha256.New, passphrase, salt, k.Iterations, 32)
+}
+
+// sealed is the content of an encrypted note: the hashtags left public,
+// followed by a PEM block such as
+//
+//	-----BEGIN ENCRYPTED NOTE-----
+//	Cipher: AES-256-GCM
+//	Iterations: 600000
+//	KDF: PBKDF2-SHA256
+//	Nonce: 4sW8...
+//	Salt: Jx0V...
+//
+//	tS9vq0Gk...
+//	-----END ENCRYPTED NOTE-----
+//
+// The public tags aren't authenticated, so they may be renamed like any
+// other tag.
+type sealed struct {
+	public      string
+	kdf         KDF
+	salt, nonce []byte
+	data        []byte
+}
+
+// IsEncrypted reports whether content is the one of an encrypted note.
+func IsEncrypted(content string) bool {
+	_, ok := parseSealed(content)
+	return ok
+}
+
+// parseSealed parses the content of an encrypted note.
+func parseSealed(content string) (sealed, bool) {
+	var s sealed
+	i := strings.Index(content, "-----BEGIN "+sealedType+"-----")
+	if i < 0 {
+		return s, false
+	}
+	b, rest := pem.Decode([]byte(content[i:]))
+	if b == nil || b.Type != sealedType || strings.TrimSpace(string(rest)) != "" || b.Headers["Cipher"] != sealedCipher {
+		return s, false
+	}
+	var err error
+	s.public = strings.TrimSpace(content[:i])
+	s.kdf.Name = b.Headers["KDF"]
+	if s.kdf.Iterations, err = strconv.Atoi(b.Headers["Iterations"]); err != nil {
+		return s, false
+	}
+	if s.salt, err = base64.StdEncoding.DecodeString(b.Headers["Salt"]); err != nil {
+		return s, false
+	}
+	if s.nonce, err = base64.StdEncoding.DecodeString(b.Headers["Nonce"]); err != nil {
+		return s, false
+	}
+	s.data = b.Bytes
+	return s, true
+}
+
+// String returns s as the content of a note.
+func (s sealed) String() string {
+	b := pem.EncodeToMemory(&pem.Block{
+		Type: sealedType,
+		Headers: map[string]string{
+			"Cipher":     sealedCipher,
+			"KDF":        s.kdf.Name,
+			"Iterations": strconv.Itoa(s.kdf.Iterations),
+			"Salt":       base64.StdEncoding.EncodeToString(s.salt),
+			"Nonce":      base64.StdEncoding.EncodeToString(s.nonce),
+		},
+		Bytes: s.data,
+	})
+	if s.public == "" {
+		return string(b)
+	}
+	return s.public + "\n" + string(b)
+}
+
+// seal encrypts content with a key derived from passphrase with kdf, and
+// a random salt and nonce.
+func seal(content, passphrase, public string, kdf KDF) (sealed, error) {
+	s := sealed{public: public, kdf: kdf, salt: make([]byte, 16)}
+	if _, err := rand.Read(s.salt); err != nil {
+		return s, err
+	}
+	aead, err := s.aead(passphrase)
+	if err != nil {
+		return s, err
+	}
+	s.nonce = make([]byte, aead.NonceSize())
+	if _, err := rand.Read(s.nonce); err != nil {
+		return s, err
+	}
+	s.data = aead.Seal(nil, s.nonce, []byte(content), nil)
+	return s, nil
+}
+
+// open decrypts s with a key derived from passphrase.
+func (s sealed) open(passphrase string) (string, error) {
+	aead, err := s.aead(passphrase)
+	if err != nil {
+		return "", err
+	}
+	if len(s.nonce) != aead.NonceSize() {
+		return "", ErrWrongPassphrase
+	}
+	b, err := aead.Open(nil, s.nonce, s.data, nil)
+	if err != nil {
+		return "", ErrWrongPassphrase
+	}
+	return string(b), nil
+}
+
+func (s sealed) aead(passphrase string) (cipher.AEAD, error) {
+	if !s.kdf.accepted() {
+		return nil, ErrUnsupportedKDF
+	}
+	key, err := s.kdf.key(passphrase, s.salt)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// publicText returns the part of content that may be indexed: all of it,
+// or the public tags if it is encrypted.
+func publicText(content string) string {
+	if s, ok := parseSealed(content); ok {
+		return s.public
+	}
+	return content
+}
+
+// SaveEncrypted stores n like Save, with its content encrypted with a key
+// derived from passphrase. The content of n is replaced by its encrypted
+// form, which leaves only the public tags in clear, so these are the only
+// tags of the note in the tag index; hashtags in the content aren't
+// indexed. ErrInvalidTag is returned if a public tag isn't a valid one.
+// The revisions saved before, which hold the content in clear, are dropped:
+// the encrypted one is the only revision of the note. Stores keeping a log
+// may hold them on disk until the log is compacted.
+func (man *NoteManager) SaveEncrypted(n *Note, passphrase string, public []string) error {
+	if passphrase == "" {
+		return ErrEmptyPassphrase
+	}
+	if IsEncrypted(n.Content) {
+		return ErrEncrypted
+	}
+	man.mu.RLock()
+	var tags []string
+	for _, t := range public {
+		h, ok := man.hashtag(t)
+		if !ok {
+			man.mu.RUnlock()
+			return ErrInvalidTag
+		}
+		tags = append(tags, h)
+	}
+	man.mu.RUnlock()
+
+	// keys are derived outside of the lock, as it takes a while on purpose.
+	s, err := seal(n.Content, passphrase, strings.Join(tags, " "), DefaultKDF)
+	if err != nil {
+		return err
+	}
+	c := *n
+	c.Content = s.String()
+	man.mu.Lock()
+	defer man.mu.Unlock()
+	if err := man.replaceAll([]*Note{&c}); err != nil {
+		return err
+	}
+	n.Content, n.Digest, n.CreatedAt, n.UpdatedAt = c.Content, c.Digest, c.CreatedAt, c.UpdatedAt
+	return nil
+}
+
+// Decrypt returns a copy of the encrypted note with the given ID with its
+// content decrypted. Nothing is stored: the copy keeps the digest of the
+// encrypted note, and saving it stores the note unencrypted.
+func (man *NoteManager) Decrypt(id, passphrase string) (*Note, error) {
+	n, ok := man.Find(id)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	s, ok := parseSealed(n.Content)
+	if !ok {
+		return nil, ErrNotEncrypted
+	}
+	content, err := s.open(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	n.Content = content
+	return n, nil
+}
+
+// RotateKey encrypts again every note encrypted with the passphrase from,
+// with a key derived from the passphrase to and DefaultKDF, and returns
+// their sorted IDs. The new revision of every note rotated replaces all of
+// its revisions. Notes encrypted with another passphrase are left alone, as
+// are notes changed while their key is rotated.
+func (man *NoteManager) RotateKey(from, to string) ([]string, error) {
+	if to == "" {
+		return nil, ErrEmptyPassphrase
+	}
+	var ns []*Note
+	for _, n := range man.AllNotes() {
+		s, ok := parseSealed(n.Content)
+		if !ok {
+			continue
+		}
+		content, err := s.open(from)
+		if err == ErrWrongPassphrase {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("note %s: %v", n.ID, err)
+		}
+		if s, err = seal(content, to, s.public, DefaultKDF); err != nil {
+			return nil, err
+		}
+		n.Content = s.String()
+		ns = append(ns, n)
+	}
+
+	man.mu.Lock()
+	defer man.mu.Unlock()
+	ids := []string{}
+	var batch []*Note
+	for _, n := range ns {
+		if old, ok := man.notes[n.ID]; !ok || old.Digest != n.Digest {
+			continue
+		}
+		ids = append(ids, n.ID)
+		batch = append(batch, n)
+	}
+	if len(batch) == 0 {
+		return ids, nil
+	}
+	// the revisions encrypted with the old key are dropped, so that it
+	// decrypts none of them.
+	if err := man.replaceAll(batch); err != nil {
+		return nil, err
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
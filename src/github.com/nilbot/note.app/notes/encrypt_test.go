@@ -0,0 +1,214 @@
+package notes
+
+import (
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// fastKDF makes keys cheap to derive for the duration of a test.
+func fastKDF() func() {
+	k := DefaultKDF
+	DefaultKDF.Iterations = 10
+	return func() { DefaultKDF = k }
+}
+
+func saveEncryptedOrFatal(t *testing.T, man *NoteManager, n *Note, passphrase string, public ...string) {
+	if err := man.SaveEncrypted(n, passphrase, public); err != nil {
+		t.Fatalf("save encrypted: %v", err)
+	}
+}
+
+func TestSaveEncrypted(t *testing.T) {
+	defer fastKDF()()
+	man := NewNoteManager()
+	n := &Note{ID: "aaaa0001", Content: "vpn password hunter2 #secret [[Servers]]"}
+	saveEncryptedOrFatal(t, man, n, "open sesame", "ops", "incident response")
+
+	stored, _ := man.Find("aaaa0001")
+	if *stored != *n || stored.Verify() != nil {
+		t.Errorf("expected the encrypted note to be written back, got %+v", n)
+	}
+	if strings.Contains(stored.Content, "hunter2") || !IsEncrypted(stored.Content) {
+		t.Errorf("expected the content to be encrypted, got %q", stored.Content)
+	}
+	if !strings.HasPrefix(stored.Content, "#ops #incident-response\n-----BEGIN ENCRYPTED NOTE-----") {
+		t.Errorf("expected the public tags before the encrypted content, got %q", stored.Content)
+	}
+	tags := man.AllTags()
+	sort.Strings(tags)
+	if !reflect.DeepEqual(tags, []string{"incident-response", "ops"}) {
+		t.Errorf("expected only the public tags to be indexed, got %v", tags)
+	}
+	if res, _ := man.Search("hunter2"); len(res) != 0 {
+		t.Errorf("expected the content not to be searchable, got %v", res)
+	}
+	if res, _ := man.Search("ops"); len(res) != 1 {
+		t.Errorf("expected the public tags to be searchable, got %v", res)
+	}
+	if stored.DisplayTitle() != "Encrypted note" {
+		t.Errorf("expected the title not to give the content away, got %q", stored.DisplayTitle())
+	}
+
+	d, err := man.Decrypt("aaaa0001", "open sesame")
+	if err != nil {
+		t.Fatalf("decrypt: %v", err)
+	}
+	if d.Content != "vpn password hunter2 #secret [[Servers]]" || d.Digest != stored.Digest {
+		t.Errorf("expected the decrypted content, got %+v", d)
+	}
+	if _, err := man.Decrypt("aaaa0001", "open barley"); err != ErrWrongPassphrase {
+		t.Errorf("expected a wrong passphrase, got %v", err)
+	}
+	if err := man.SaveEncrypted(stored, "open sesame", nil); err != ErrEncrypted {
+		t.Errorf("expected the note to be encrypted already, got %v", err)
+	}
+	if err := man.SaveEncrypted(&Note{Content: "x"}, "", nil); err != ErrEmptyPassphrase {
+		t.Errorf("expected an empty passphrase error, got %v", err)
+	}
+	if err := man.SaveEncrypted(&Note{ID: "aaaa0002", Content: "x"}, "p", []string{"no tag!"}); err != ErrInvalidTag {
+		t.Errorf("expected an invalid tag, got %v", err)
+	}
+	editOrFatal(t, man, "aaaa0002", "plain")
+	if _, err := man.Decrypt("aaaa0002", "open sesame"); err != ErrNotEncrypted {
+		t.Errorf("expected the note not to be encrypted, got %v", err)
+	}
+}
+
+func TestEncryptedNoteHistory(t *testing.T) {
+	defer fastKDF()()
+	dir := tempDirOrFatal(t)
+	defer os.RemoveAll(dir)
+
+	man := openWALManagerOrFatal(t, dir, WALOptions{})
+	editOrFatal(t, man, "aaaa0001", "vpn password hunter1")
+	editOrFatal(t, man, "aaaa0001", "vpn password hunter2")
+	n, _ := man.Find("aaaa0001")
+	saveEncryptedOrFatal(t, man, n, "pw")
+	man.Close()
+
+	man = openWALManagerOrFatal(t, dir, WALOptions{})
+	defer man.Close()
+	revs, _ := man.Revisions("aaaa0001")
+	if len(revs) != 1 || revs[0].Rev != 1 || revs[0].Content != n.Content {
+		t.Errorf("expected the encrypted revision only, got %+v", revs)
+	}
+	editOrFatal(t, man, "aaaa0001", "plain again")
+	if _, err := man.Diff("aaaa0001", 1, 2); err != nil {
+		t.Errorf("expected revisions to follow the encrypted one, got %v", err)
+	}
+}
+
+func TestEncryptedNoteSnippet(t *testing.T) {
+	defer fastKDF()()
+	man := NewNoteManager()
+	n := &Note{ID: "aaaa0001", Content: "secret"}
+	saveEncryptedOrFatal(t, man, n, "pw", "ops")
+	editOrFatal(t, man, "aaaa0001", "  \n"+n.Content)
+	res, _ := man.Search("ops")
+	if len(res) != 1 || res[0].Snippet != "#<mark>ops</mark>" {
+		t.Errorf("expected the public tag to be highlighted, got %+v", res)
+	}
+}
+
+func TestEncryptedNoteTampered(t *testing.T) {
+	defer fastKDF()()
+	man := NewNoteManager()
+	n := &Note{ID: "aaaa0001", Content: "pay 100 to alice"}
+	saveEncryptedOrFatal(t, man, n, "pw")
+
+	s, _ := parseSealed(n.Content)
+	s.data[0] ^= 1
+	editOrFatal(t, man, "aaaa0001", s.String())
+	if _, err := man.Decrypt("aaaa0001", "pw"); err != ErrWrongPassphrase {
+		t.Errorf("expected tampering to be detected, got %v", err)
+	}
+}
+
+func TestEncryptedNoteIterations(t *testing.T) {
+	defer fastKDF()()
+	man := NewNoteManager()
+	n := &Note{ID: "aaaa0001", Content: "pay 100 to alice"}
+	saveEncryptedOrFatal(t, man, n, "pw")
+
+	s, _ := parseSealed(n.Content)
+	for _, iterations := range []int{DefaultKDF.Iterations * (kdfRange + 1), 1 << 40} {
+		s.kdf.Iterations = iterations
+		editOrFatal(t, man, "aaaa0001", s.String())
+		if _, err := man.Decrypt("aaaa0001", "pw"); err != ErrUnsupportedKDF {
+			t.Errorf("expected %d iterations to be refused, got %v", iterations, err)
+		}
+	}
+}
+
+func TestEncryptedPublicTagsRenamed(t *testing.T) {
+	defer fastKDF()()
+	man := NewNoteManager()
+	saveEncryptedOrFatal(t, man, &Note{ID: "aaaa0001", Content: "secret"}, "pw", "ops")
+	if _, err := man.RenameTag("ops", "sre", false); err != nil {
+		t.Fatalf("rename: %v", err)
+	}
+	if ids, _ := man.NotesWith("sre"); len(ids) != 1 {
+		t.Errorf("expected the public tag to be renamed, got %v", man.AllTags())
+	}
+	if d, err := man.Decrypt("aaaa0001", "pw"); err != nil || d.Content != "secret" {
+		t.Errorf("expected the note to still decrypt, got %v, %v", d, err)
+	}
+}
+
+func TestRotateKey(t *testing.T) {
+	defer fastKDF()()
+	man := NewNoteManager()
+	saveEncryptedOrFatal(t, man, &Note{ID: "aaaa0001", Content: "one"}, "old", "ops")
+	saveEncryptedOrFatal(t, man, &Note{ID: "aaaa0002", Content: "two"}, "old")
+	saveEncryptedOrFatal(t, man, &Note{ID: "aaaa0003", Content: "three"}, "other")
+	editOrFatal(t, man, "aaaa0004", "plain")
+
+	// the parameters of notes are kept when the default changes.
+	DefaultKDF.Iterations = 20
+	if s, _ := parseSealed(man.notes["aaaa0001"].Content); s.kdf.Iterations != 10 || s.kdf.Name != "PBKDF2-SHA256" {
+		t.Errorf("expected the parameters to be stored, got %+v", s.kdf)
+	}
+	if d, err := man.Decrypt("aaaa0001", "old"); err != nil || d.Content != "one" {
+		t.Errorf("expected the stored parameters to be used, got %v, %v", d, err)
+	}
+
+	ids, err := man.RotateKey("old", "new")
+	if err != nil {
+		t.Fatalf("rotate: %v", err)
+	}
+	if expected := []string{"aaaa0001", "aaaa0002"}; !reflect.DeepEqual(ids, expected) {
+		t.Errorf("expected %v to be rotated, got %v", expected, ids)
+	}
+	for id, content := range map[string]string{"aaaa0001": "one", "aaaa0002": "two"} {
+		if _, err := man.Decrypt(id, "old"); err != ErrWrongPassphrase {
+			t.Errorf("expected the old passphrase to be rejected, got %v", err)
+		}
+		if d, err := man.Decrypt(id, "new"); err != nil || d.Content != content {
+			t.Errorf("expected %s to decrypt with the new passphrase, got %v, %v", id, d, err)
+		}
+		if s, _ := parseSealed(man.notes[id].Content); s.kdf.Iterations != 20 {
+			t.Errorf("expected the new parameters, got %+v", s.kdf)
+		}
+	}
+	if n, _ := man.Find("aaaa0001"); !strings.HasPrefix(n.Content, "#ops\n") {
+		t.Errorf("expected the public tags to be kept, got %q", n.Content)
+	}
+	if d, err := man.Decrypt("aaaa0003", "other"); err != nil || d.Content != "three" {
+		t.Errorf("expected the note with another passphrase to be left alone, got %v, %v", d, err)
+	}
+	for _, id := range ids {
+		revs, _ := man.Revisions(id)
+		for _, r := range revs {
+			s, ok := parseSealed(r.Content)
+			if _, err := s.open("old"); !ok || err != ErrWrongPassphrase {
+				t.Errorf("expected no revision of %s to decrypt with the old passphrase, got %v", id, err)
+			}
+		}
+		if len(revs) != 1 {
+			t.Errorf("expected the rotated revision only, got %d", len(revs))
+		}
+	}
+}
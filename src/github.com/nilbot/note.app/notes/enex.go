@@ -0,0 +1,165 @@
+package notes
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// enexTime is the timestamp format of ENEX files.
+const enexTime = "20060102T150405Z"
+
+const enmlHeader = `<?xml version="1.0" encoding="UTF-8" standalone="no"?>
+<!DOCTYPE en-note SYSTEM "http://xml.evernote.com/pub/enml2.dtd">
+`
+
+type enexExport struct {
+	XMLName     xml.Name   `xml:"en-export"`
+	ExportDate  string     `xml:"export-date,attr,omitempty"`
+	Application string     `xml:"application,attr,omitempty"`
+	Notes       []enexNote `xml:"note"`
+}
+
+type enexNote struct {
+	Title   string `xml:"title"`
+	Content struct {
+		Text string `xml:",cdata"`
+	} `xml:"content"`
+	Created string   `xml:"created,omitempty"`
+	Updated string   `xml:"updated,omitempty"`
+	Tags    []string `xml:"tag"`
+}
+
+// exportENEX writes ns as an Evernote export. Every line of the content
+// becomes a div of ENML, so it reads as plain text in Evernote.
+func exportENEX(w io.Writer, ns []*Note, tags [][]string) error {
+	exp := enexExport{
+		ExportDate:  time.Now().UTC().Format(enexTime),
+		Application: "note.app",
+	}
+	for i, n := range ns {
+		en := enexNote{
+			Title:   n.DisplayTitle(),
+			Created: n.CreatedAt.UTC().Format(enexTime),
+			Updated: n.UpdatedAt.UTC().Format(enexTime),
+			Tags:    tags[i],
+		}
+		en.Content.Text = toENML(n.Content)
+		exp.Notes = append(exp.Notes, en)
+	}
+	if _, err := io.WriteString(w, xml.Header+
+		`<!DOCTYPE en-export SYSTEM "http://xml.evernote.com/pub/evernote-export3.dtd">`+"\n"); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(exp); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// toENML returns content as an ENML document with a div per line.
+func toENML(content string) string {
+	var b strings.Builder
+	b.WriteString(enmlHeader)
+	b.WriteString("<en-note>")
+	for _, l := range strings.Split(content, "\n") {
+		if l == "" {
+			b.WriteString("<div><br/></div>")
+			continue
+		}
+		b.WriteString("<div>")
+		xml.EscapeText(&b, []byte(l))
+		b.WriteString("</div>")
+	}
+	b.WriteString("</en-note>")
+	return b.String()
+}
+
+// importENEX reads the notes of an Evernote export. The notes get new IDs;
+// titles are kept only if they differ from the display title of the content.
+// Attachments are ignored.
+func importENEX(r io.Reader) ([]importedNote, error) {
+	var exp enexExport
+	if err := xml.NewDecoder(r).Decode(&exp); err != nil {
+		return nil, err
+	}
+	ns := make([]importedNote, 0, len(exp.Notes))
+	for i, en := range exp.Notes {
+		content, err := fromENML(en.Content.Text)
+		if err != nil {
+			return nil, fmt.Errorf("note %d: %v", i+1, err)
+		}
+		n := &Note{Content: content}
+		if title := strings.TrimSpace(en.Title); title != n.DisplayTitle() {
+			n.Title = title
+		}
+		for _, t := range []struct {
+			s  string
+			to *time.Time
+		}{{en.Created, &n.CreatedAt}, {en.Updated, &n.UpdatedAt}} {
+			if t.s == "" {
+				continue
+			}
+			v, err := time.Parse(enexTime, t.s)
+			if err != nil {
+				return nil, fmt.Errorf("note %d: %v", i+1, err)
+			}
+			*t.to = v
+		}
+		ns = append(ns, importedNote{note: n, tags: en.Tags})
+	}
+	return ns, nil
+}
+
+// fromENML returns the text of an ENML document, a line per block element.
+// ENML is parsed leniently, as the HTML it is derived from.
+func fromENML(enml string) (string, error) {
+	d := xml.NewDecoder(strings.NewReader(enml))
+	d.Strict = false
+	d.AutoClose = xml.HTMLAutoClose
+	d.Entity = xml.HTMLEntity
+	var b strings.Builder
+	newline := func() {
+		if s := b.String(); s != "" && !strings.HasSuffix(s, "\n") {
+			b.WriteString("\n")
+		}
+	}
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		switch tok := tok.(type) {
+		case xml.CharData:
+			// line breaks are markup in ENML, not in the text.
+			b.WriteString(strings.NewReplacer("\r", "", "\n", "").Replace(string(tok)))
+		case xml.StartElement:
+			switch strings.ToLower(tok.Name.Local) {
+			case "br":
+				b.WriteString("\n")
+			case "en-todo":
+				box := "- [ ] "
+				for _, a := range tok.Attr {
+					if a.Name.Local == "checked" && a.Value == "true" {
+						box = "- [x] "
+					}
+				}
+				b.WriteString(box)
+			}
+		case xml.EndElement:
+			switch strings.ToLower(tok.Name.Local) {
+			case "div", "p", "li", "h1", "h2", "h3", "h4", "h5", "h6", "pre", "blockquote", "tr":
+				newline()
+			}
+		}
+	}
+	return strings.TrimSuffix(b.String(), "\n"), nil
+}
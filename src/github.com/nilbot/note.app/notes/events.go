@@ -0,0 +1,162 @@
+package notes
+
+import (
+	"errors"
+	"sort"
+	"sync/atomic"
+)
+
+// EventKind tells how a note changed.
+type EventKind string
+
+const (
+	// Created is the kind of events for new notes.
+	Created EventKind = "created"
+	// Updated is the kind of events for notes that changed.
+	Updated EventKind = "updated"
+	// Deleted is the kind of events for deleted notes.
+	Deleted EventKind = "deleted"
+)
+
+// Event describes a change of a note. Before is nil for created notes and
+// After for deleted ones. Tags are the sorted tags of the note before or
+// after the change. Seq is the number of the change, see Changes.
+type Event struct {
+	Seq    uint64
+	Kind   EventKind
+	Before *Note
+	After  *Note
+	Tags   []string
+}
+
+// SlowPolicy says what happens to the events for a subscriber whose buffer
+// is full. Publishing events never waits for subscribers.
+type SlowPolicy int
+
+const (
+	// DropNewest drops the events that don't fit in the buffer.
+	DropNewest SlowPolicy = iota
+	// DropOldest drops the oldest event of the buffer to make room.
+	DropOldest
+	// Disconnect unsubscribes the subscriber, closing its channel.
+	Disconnect
+)
+
+// ErrSlowSubscriber is the error of a subscription disconnected for not
+// keeping up with events.
+var ErrSlowSubscriber = errors.New("subscriber too slow")
+
+// Subscription delivers the events of a NoteManager, in order, until it is
+// closed.
+type Subscription struct {
+	man          *NoteManager
+	c            chan Event
+	policy       SlowPolicy
+	dropped      int64
+	disconnected int32
+}
+
+// Subscribe returns a subscription to the changes of the notes, buffering
+// up to buffer events, at least one, and applying policy when the buffer is
+// full.
+func (man *NoteManager) Subscribe(buffer int, policy SlowPolicy) *Subscription {
+	if buffer < 1 {
+		buffer = 1
+	}
+	s := &Subscription{man: man, c: make(chan Event, buffer), policy: policy}
+	man.mu.Lock()
+	defer man.mu.Unlock()
+	man.subs[s] = true
+	return s
+}
+
+// Events returns the channel of the events, closed once the subscription
+// is.
+func (s *Subscription) Events() <-chan Event {
+	return s.c
+}
+
+// Dropped returns the number of events dropped so far.
+func (s *Subscription) Dropped() int {
+	return int(atomic.LoadInt64(&s.dropped))
+}
+
+// Err returns ErrSlowSubscriber if the subscription was disconnected by
+// the Disconnect policy, nil otherwise.
+func (s *Subscription) Err() error {
+	if atomic.LoadInt32(&s.disconnected) != 0 {
+		return ErrSlowSubscriber
+	}
+	return nil
+}
+
+// Close unsubscribes s and closes its channel. Events still buffered can be
+// received.
+func (s *Subscription) Close() {
+	s.man.mu.Lock()
+	defer s.man.mu.Unlock()
+	s.man.unsubscribe(s)
+}
+
+func (man *NoteManager) unsubscribe(s *Subscription) {
+	if man.subs[s] {
+		delete(man.subs, s)
+		close(s.c)
+	}
+}
+
+// publish sends the event for the change seq of a note from before to
+// after, either of which may be nil, to the subscribers. man.mu must be held
+// for writing.
+func (man *NoteManager) publish(seq uint64, before, after *Note) {
+	if len(man.subs) == 0 {
+		return
+	}
+	e := Event{Seq: seq, Kind: Updated}
+	tags := make(map[string]bool)
+	if before != nil {
+		c := *before
+		e.Before = &c
+		for v := range man.tagSet(c.Content) {
+			tags[v] = true
+		}
+	} else {
+		e.Kind = Created
+	}
+	if after != nil {
+		c := *after
+		e.After = &c
+		for v := range man.tagSet(c.Content) {
+			tags[v] = true
+		}
+	} else {
+		e.Kind = Deleted
+	}
+	e.Tags = make([]string, 0, len(tags))
+	for v := range tags {
+		e.Tags = append(e.Tags, v)
+	}
+	sort.Strings(e.Tags)
+
+	for s := range man.subs {
+		select {
+		case s.c <- e:
+			continue
+		default:
+		}
+		switch s.policy {
+		case DropNewest:
+			atomic.AddInt64(&s.dropped, 1)
+		case DropOldest:
+			select {
+			case <-s.c:
+				atomic.AddInt64(&s.dropped, 1)
+			default:
+			}
+			s.c <- e
+		case Disconnect:
+			atomic.StoreInt32(&s.disconnected, 1)
+			man.unsubscribe(s)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package notes
+
+import (
+	"reflect"
+	"testing"
+)
+
+func receiveOrFatal(t *testing.T, s *Subscription) Event {
+	select {
+	case e, ok := <-s.Events():
+		if !ok {
+			t.Fatalf("expected an event, the subscription is closed")
+		}
+		return e
+	default:
+		t.Fatalf("expected an event, got nothing")
+	}
+	return Event{}
+}
+
+func expectNoEvent(t *testing.T, s *Subscription) {
+	select {
+	case e, ok := <-s.Events():
+		if ok {
+			t.Errorf("expected no event, got %+v", e)
+		}
+	default:
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	man := NewNoteManager()
+	s := man.Subscribe(10, DropNewest)
+	note := &Note{ID: "aaaa0001", Content: "buy milk #todo"}
+	if err := man.Save(note); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	e := receiveOrFatal(t, s)
+	if e.Kind != Created || e.Before != nil || e.After.Content != note.Content || !reflect.DeepEqual(e.Tags, []string{"todo"}) {
+		t.Errorf("expected a created event, got %+v", e)
+	}
+
+	editOrFatal(t, man, note.ID, "buy milk #todo")
+	expectNoEvent(t, s)
+
+	editOrFatal(t, man, note.ID, "buy milk #done")
+	e = receiveOrFatal(t, s)
+	if e.Kind != Updated || e.Before.Content != "buy milk #todo" || e.After.Content != "buy milk #done" || !reflect.DeepEqual(e.Tags, []string{"done", "todo"}) {
+		t.Errorf("expected an updated event, got %+v", e)
+	}
+
+	if _, err := man.RenameTag("done", "finished", false); err != nil {
+		t.Fatalf("rename: %v", err)
+	}
+	if e = receiveOrFatal(t, s); e.Kind != Updated || e.After.Content != "buy milk #finished" {
+		t.Errorf("expected renaming to update the note, got %+v", e)
+	}
+
+	if err := man.Delete(note.ID); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if e = receiveOrFatal(t, s); e.Kind != Deleted || e.After != nil || e.Before.ID != note.ID || !reflect.DeepEqual(e.Tags, []string{"finished"}) {
+		t.Errorf("expected a deleted event, got %+v", e)
+	}
+
+	s.Close()
+	if _, ok := <-s.Events(); ok {
+		t.Errorf("expected the channel to be closed")
+	}
+	editOrFatal(t, man, note.ID, "after unsubscribing")
+	s.Close()
+}
+
+func TestSlowSubscribers(t *testing.T) {
+	man := NewNoteManager()
+	newest := man.Subscribe(2, DropNewest)
+	oldest := man.Subscribe(2, DropOldest)
+	disconnect := man.Subscribe(2, Disconnect)
+	editOrFatal(t, man, "aaaa0001", "one", "two", "three")
+
+	if e := receiveOrFatal(t, newest); e.After.Content != "one" || newest.Dropped() != 1 {
+		t.Errorf("expected the newest event to be dropped, got %+v and %d dropped", e, newest.Dropped())
+	}
+	if e := receiveOrFatal(t, oldest); e.After.Content != "two" || oldest.Dropped() != 1 {
+		t.Errorf("expected the oldest event to be dropped, got %+v and %d dropped", e, oldest.Dropped())
+	}
+	receiveOrFatal(t, disconnect)
+	receiveOrFatal(t, disconnect)
+	if _, ok := <-disconnect.Events(); ok || disconnect.Err() != ErrSlowSubscriber {
+		t.Errorf("expected the slow subscriber to be disconnected, got %v", disconnect.Err())
+	}
+	if newest.Err() != nil {
+		t.Errorf("expected dropping subscribers to stay connected, got %v", newest.Err())
+	}
+}
+
+func TestCloseEndsSubscriptions(t *testing.T) {
+	man := NewNoteManager()
+	s := man.Subscribe(1, DropNewest)
+	if err := man.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if _, ok := <-s.Events(); ok {
+		t.Errorf("expected closing the manager to close subscriptions")
+	}
+	s.Close()
+}
@@ -0,0 +1,202 @@
+package notes
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Format is a file format notes are exported to and imported from.
+type Format string
+
+const (
+	// Markdown is a zip archive of Markdown files, one per note, with the
+	// metadata of the note in YAML front matter.
+	Markdown Format = "markdown"
+	// JSONLines is a JSON encoded note per line.
+	JSONLines Format = "jsonl"
+	// ENEX is the XML export format of Evernote.
+	ENEX Format = "enex"
+)
+
+// MaxImportSize limits the size of the notes read by Import, once the files
+// of an archive are extracted.
+var MaxImportSize int64 = 256 << 20
+
+// ErrImportTooLarge is returned by Import, as the Err of an *ImportError,
+// when the notes read are larger than MaxImportSize.
+var ErrImportTooLarge = errors.New("notes larger than the import limit")
+
+// ImportError is returned by Import when the input can't be read in the
+// format it was given in.
+type ImportError struct {
+	Format Format
+	Err    error
+}
+
+func (e *ImportError) Error() string {
+	return fmt.Sprintf("import %s: %v", e.Format, e.Err)
+}
+
+func (e *ImportError) Unwrap() error {
+	return e.Err
+}
+
+// ImportResult lists the IDs of the notes an import created or updated.
+type ImportResult struct {
+	Imported []string
+}
+
+// Export writes every note to w in format f, ordered by ID.
+func (man *NoteManager) Export(w io.Writer, f Format) error {
+	ns, tags := man.exportNotes()
+	switch f {
+	case Markdown:
+		return exportMarkdown(w, ns, tags)
+	case JSONLines:
+		enc := json.NewEncoder(w)
+		for _, n := range ns {
+			if err := enc.Encode(n); err != nil {
+				return err
+			}
+		}
+		return nil
+	case ENEX:
+		return exportENEX(w, ns, tags)
+	}
+	return fmt.Errorf("unknown format %q", f)
+}
+
+// exportNotes returns copies of the notes, ordered by ID, and their sorted
+// tags.
+func (man *NoteManager) exportNotes() ([]*Note, [][]string) {
+	man.mu.RLock()
+	defer man.mu.RUnlock()
+	ns := make([]*Note, 0, len(man.notes))
+	for _, n := range man.notes {
+		c := *n
+		ns = append(ns, &c)
+	}
+	sort.Slice(ns, func(i, j int) bool { return ns[i].ID < ns[j].ID })
+	tags := make([][]string, len(ns))
+	for i, n := range ns {
+		for t := range man.tagSet(n.Content) {
+			tags[i] = append(tags[i], t)
+		}
+		sort.Strings(tags[i])
+	}
+	return ns, tags
+}
+
+// Import reads notes in format f from r and saves them at once. Notes keep
+// their ID, replacing the note with the same ID if any, or get a new one if
+// they have none, as notes from Evernote. Digests are recomputed. Tags
+// given apart from the content, as in front matter or Evernote, are
+// appended to the content as hashtags if it lacks them. Nothing is saved if
+// r can't be read or holds a note with an invalid ID, which is reported as
+// an *ImportError.
+func (man *NoteManager) Import(r io.Reader, f Format) (ImportResult, error) {
+	var ns []importedNote
+	var err error
+	switch f {
+	case Markdown:
+		ns, err = importMarkdown(r)
+	case JSONLines:
+		ns, err = importJSONLines(r)
+	case ENEX:
+		ns, err = importENEX(r)
+	default:
+		err = fmt.Errorf("unknown format %q", f)
+	}
+	if err != nil {
+		return ImportResult{}, &ImportError{f, err}
+	}
+
+	man.mu.Lock()
+	defer man.mu.Unlock()
+	res := ImportResult{Imported: []string{}}
+	byID := make(map[string]int)
+	var batch []*Note
+	for _, in := range ns {
+		n := in.note
+		if n.ID == "" {
+			n.ID = GenerateID()
+		} else if !validID.MatchString(n.ID) {
+			return ImportResult{}, &ImportError{f, fmt.Errorf("invalid note ID %q", n.ID)}
+		}
+		n.Content = man.addTags(n.Content, in.tags)
+		if i, ok := byID[n.ID]; ok {
+			// the last note with an ID wins.
+			batch[i] = n
+			continue
+		}
+		byID[n.ID] = len(batch)
+		batch = append(batch, n)
+		res.Imported = append(res.Imported, n.ID)
+	}
+	if len(batch) == 0 {
+		return res, nil
+	}
+	if err := man.saveAll(batch); err != nil {
+		return ImportResult{}, err
+	}
+	return res, nil
+}
+
+// importedNote is a note read by an import, with tags given apart from its
+// content.
+type importedNote struct {
+	note *Note
+	tags []string
+}
+
+// addTags appends the tags content lacks to it as hashtags. Spaces in tags
+// become dashes, tags that still aren't valid are dropped.
+func (man *NoteManager) addTags(content string, tags []string) string {
+	have := man.tagSet(content)
+	var add []string
+	for _, t := range tags {
+		h, ok := man.hashtag(t)
+		if !ok || have[man.tok.fold(h[1:])] {
+			continue
+		}
+		have[man.tok.fold(h[1:])] = true
+		add = append(add, h)
+	}
+	if len(add) == 0 {
+		return content
+	}
+	if content != "" && !strings.HasSuffix(content, "\n") {
+		content += "\n"
+	}
+	return content + strings.Join(add, " ")
+}
+
+// hashtag returns tag as a hashtag, with spaces as dashes, and whether it is
+// a valid one.
+func (man *NoteManager) hashtag(tag string) (string, bool) {
+	t := strings.Join(strings.Fields(tag), "-")
+	ts := man.tok.Tags("#" + t)
+	return "#" + t, len(ts) == 1 && ts[0] == man.tok.fold(t)
+}
+
+func importJSONLines(r io.Reader) ([]importedNote, error) {
+	var ns []importedNote
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 64<<20)
+	for line := 1; sc.Scan(); line++ {
+		if strings.TrimSpace(sc.Text()) == "" {
+			continue
+		}
+		var n Note
+		if err := json.Unmarshal(sc.Bytes(), &n); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		ns = append(ns, importedNote{note: &n})
+	}
+	return ns, sc.Err()
+}
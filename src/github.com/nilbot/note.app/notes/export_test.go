@@ -0,0 +1,234 @@
+package notes
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func exportedNotes(t *testing.T) *NoteManager {
+	man := NewNoteManager()
+	man.now = tickingClock()
+	for _, n := range []*Note{
+		{ID: "aaaa0001", Content: "buy milk #todo #shopping\n\n- [ ] oat milk\n"},
+		{ID: "aaaa0002", Title: "Plans: \"2015\"", Content: "#work/urgent <b>ship</b> it ]]> now", Pinned: true},
+		{ID: "aaaa0003", Content: "  indented\n```\n#notatag\n```", Archived: true},
+	} {
+		if err := man.Save(n); err != nil {
+			t.Fatalf("save: %v", err)
+		}
+	}
+	return man
+}
+
+// byContent returns the notes of man by content, without their ID and
+// digest.
+func byContent(man *NoteManager) map[string]Note {
+	res := make(map[string]Note)
+	for _, n := range man.AllNotes() {
+		n.ID, n.Digest = "", ""
+		res[n.Content] = *n
+	}
+	return res
+}
+
+func tagsOf(man *NoteManager) []string {
+	var tags []string
+	for _, n := range man.AllNotes() {
+		tags = append(tags, man.tok.Tags(n.Content)...)
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+func TestExportImportRoundTrip(t *testing.T) {
+	for _, f := range []Format{Markdown, JSONLines, ENEX} {
+		src := exportedNotes(t)
+		var buf bytes.Buffer
+		if err := src.Export(&buf, f); err != nil {
+			t.Fatalf("%s: export: %v", f, err)
+		}
+		dst := NewNoteManager()
+		res, err := dst.Import(&buf, f)
+		if err != nil {
+			t.Fatalf("%s: import: %v", f, err)
+		}
+		if len(res.Imported) != 3 {
+			t.Errorf("%s: expected 3 imported notes, got %v", f, res.Imported)
+		}
+		want, got := byContent(src), byContent(dst)
+		if f == ENEX {
+			// Evernote has no pinned or archived notes, and keeps
+			// timestamps to the second.
+			for c, n := range want {
+				n.Pinned, n.Archived = false, false
+				n.CreatedAt, n.UpdatedAt = n.CreatedAt.Truncate(1e9), n.UpdatedAt.Truncate(1e9)
+				want[c] = n
+			}
+		} else if !reflect.DeepEqual(contents(src), contents(dst)) {
+			t.Errorf("%s: expected IDs to be kept, got %v", f, contents(dst))
+		}
+		if !reflect.DeepEqual(want, got) {
+			t.Errorf("%s: expected notes\n%+v\ngot\n%+v", f, want, got)
+		}
+		if want, got := tagsOf(src), tagsOf(dst); !reflect.DeepEqual(want, got) {
+			t.Errorf("%s: expected tags %v, got %v", f, want, got)
+		}
+		if ids, _ := dst.NotesWith("work/urgent"); len(ids) != 1 {
+			t.Errorf("%s: expected the tag index to be rebuilt, got %v", f, ids)
+		}
+	}
+}
+
+func TestImportReplacesNotes(t *testing.T) {
+	man := exportedNotes(t)
+	in := `{"ID": "aaaa0001", "Content": "buy bread #shopping", "Digest": "stale"}
+
+{"ID": "aaaa0004", "Content": "new"}
+`
+	res, err := man.Import(strings.NewReader(in), JSONLines)
+	if err != nil {
+		t.Fatalf("import: %v", err)
+	}
+	if expected := []string{"aaaa0001", "aaaa0004"}; !reflect.DeepEqual(res.Imported, expected) {
+		t.Errorf("expected %v to be imported, got %v", expected, res.Imported)
+	}
+	if n, _ := man.Find("aaaa0001"); n.Content != "buy bread #shopping" || n.Digest != digest(n.Content) {
+		t.Errorf("expected the note to be replaced, got %+v", n)
+	}
+	if ids, _ := man.NotesWith("todo"); len(ids) != 0 {
+		t.Errorf("expected the old tags to be dropped, got %v", ids)
+	}
+	_, err = man.Import(strings.NewReader("{\n"), JSONLines)
+	if _, ok := err.(*ImportError); !ok || !strings.Contains(err.Error(), "line 1") {
+		t.Errorf("expected an import error on line 1, got %v", err)
+	}
+	_, err = man.Import(strings.NewReader(`{"ID": "aaaa/0005", "Content": "x"}`), JSONLines)
+	if _, ok := err.(*ImportError); !ok || !strings.Contains(err.Error(), "invalid note ID") {
+		t.Errorf("expected an invalid note ID, got %v", err)
+	}
+	if _, ok := man.Find("aaaa/0005"); ok {
+		t.Errorf("expected the note with an invalid ID not to be imported")
+	}
+	if _, err := man.Import(strings.NewReader(""), "csv"); err == nil {
+		t.Errorf("expected an unknown format error")
+	}
+}
+
+func TestImportMarkdownFrontMatter(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, body := range map[string]string{
+		"notes/flow.md":  "---\nid: aaaa0001\ntitle: 'It''s here'\ntags: [\"work\", todo, big plans]\n---\nbuy milk #todo\n",
+		"notes/block.md": "---\r\ntags:\r\n  - shopping\r\n  - \"not a tag!\"\r\npinned: true\r\n---\r\nbread\r\n",
+		"plain.markdown": "no front matter",
+		"image.png":      "---\nid: aaaa0009\n---\n",
+	} {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatalf("create: %v", err)
+		}
+		w.Write([]byte(body))
+	}
+	zw.Close()
+
+	man := NewNoteManager()
+	res, err := man.Import(&buf, Markdown)
+	if err != nil {
+		t.Fatalf("import: %v", err)
+	}
+	if len(res.Imported) != 3 {
+		t.Fatalf("expected 3 notes, got %v", res.Imported)
+	}
+	n, ok := man.Find("aaaa0001")
+	if !ok || n.Title != "It's here" || n.Content != "buy milk #todo\n#work #big-plans" {
+		t.Errorf("expected tags to be appended as hashtags, got %+v", n)
+	}
+	c := byContent(man)
+	if n, ok := c["bread\r\n#shopping"]; !ok || !n.Pinned {
+		t.Errorf("expected a pinned note with the block list tags, got %v", c)
+	}
+	if _, ok := c["no front matter"]; !ok {
+		t.Errorf("expected a note without front matter, got %v", c)
+	}
+
+	bad := new(bytes.Buffer)
+	zw = zip.NewWriter(bad)
+	w, _ := zw.Create("bad.md")
+	w.Write([]byte("---\ncreated: yesterday\n---\n"))
+	zw.Close()
+	if _, err := man.Import(bad, Markdown); err == nil || !strings.Contains(err.Error(), "bad.md") {
+		t.Errorf("expected an error in bad.md, got %v", err)
+	}
+}
+
+func TestImportMarkdownTooLarge(t *testing.T) {
+	defer func(max int64) { MaxImportSize = max }(MaxImportSize)
+	MaxImportSize = 1 << 20
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, name := range []string{"a.md", "b.md"} {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatalf("create: %v", err)
+		}
+		w.Write(bytes.Repeat([]byte("x"), 600<<10))
+	}
+	zw.Close()
+	if buf.Len() > 64<<10 {
+		t.Fatalf("expected the archive to be compressed, got %d bytes", buf.Len())
+	}
+
+	man := NewNoteManager()
+	_, err := man.Import(&buf, Markdown)
+	if !errors.Is(err, ErrImportTooLarge) || !strings.Contains(err.Error(), "b.md") {
+		t.Errorf("expected the archive to be too large, got %v", err)
+	}
+	if all := man.AllNotes(); len(all) != 0 {
+		t.Errorf("expected nothing to be imported, got %d notes", len(all))
+	}
+}
+
+func TestImportENEX(t *testing.T) {
+	in := `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE en-export SYSTEM "http://xml.evernote.com/pub/evernote-export3.dtd">
+<en-export export-date="20150301T100000Z" application="Evernote">
+  <note>
+    <title>Groceries</title>
+    <content><![CDATA[<?xml version="1.0" encoding="UTF-8" standalone="no"?>
+<!DOCTYPE en-note SYSTEM "http://xml.evernote.com/pub/enml2.dtd">
+<en-note>
+<div>Groceries</div>
+<div><en-todo checked="true"/>milk&nbsp;&amp; bread</div>
+<div><en-todo/>eggs<br/></div>
+<p>done</p></en-note>]]></content>
+    <created>20150301T100000Z</created>
+    <updated>20150302T110000Z</updated>
+    <tag>shopping</tag>
+    <tag>weekly list</tag>
+  </note>
+</en-export>`
+	man := NewNoteManager()
+	res, err := man.Import(strings.NewReader(in), ENEX)
+	if err != nil {
+		t.Fatalf("import: %v", err)
+	}
+	if len(res.Imported) != 1 {
+		t.Fatalf("expected a note, got %v", res.Imported)
+	}
+	n, _ := man.Find(res.Imported[0])
+	expected := "Groceries\n- [x] milk\u00a0& bread\n- [ ] eggs\ndone\n#shopping #weekly-list"
+	if n.Content != expected || n.Title != "" {
+		t.Errorf("expected content %q without a title, got %+v", expected, n)
+	}
+	if n.CreatedAt.Format(enexTime) != "20150301T100000Z" || n.UpdatedAt.Format(enexTime) != "20150302T110000Z" {
+		t.Errorf("expected the timestamps to be kept, got %+v", n)
+	}
+	if tasks, _ := man.Tasks(n.ID); len(tasks) != 2 || !tasks[0].Done {
+		t.Errorf("expected to-dos to become tasks, got %+v", tasks)
+	}
+}
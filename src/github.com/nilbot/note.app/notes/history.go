@@ -0,0 +1,246 @@
+package notes
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+// ErrNoRevision is returned when a note has no revision with a given number.
+var ErrNoRevision = errors.New("revision not found")
+
+// ErrDiffTooLarge is returned when diffing revisions with more than
+// MaxDiffLines lines between them.
+var ErrDiffTooLarge = errors.New("revisions too large to diff")
+
+// MaxDiffLines bounds the lines of the revisions diffed, so a diff can't
+// take more than a moment.
+const MaxDiffLines = 20000
+
+// Revision is a version of the content of a note. Revisions of a note are
+// numbered from 1 in the order they were saved.
+type Revision struct {
+	Rev     int
+	Time    time.Time
+	Content string
+}
+
+// DiffLine is a line of a diff between two revisions. Op is "+" for a line
+// only in the newer revision, "-" for a line only in the older one and " "
+// for a line in both.
+type DiffLine struct {
+	Op   string
+	Text string
+}
+
+// nextRevision returns the revision to record for n at time now, if its
+// content differs from the latest revision.
+func (man *NoteManager) nextRevision(n *Note, now time.Time) (Revision, bool) {
+	h := man.history[n.ID]
+	if len(h) > 0 && h[len(h)-1].Content == n.Content {
+		return Revision{}, false
+	}
+	return Revision{len(h) + 1, now, n.Content}, true
+}
+
+// Revisions returns the revisions of the note with the given ID, oldest first.
+func (man *NoteManager) Revisions(id string) ([]Revision, error) {
+	man.mu.RLock()
+	defer man.mu.RUnlock()
+	if _, ok := man.notes[id]; !ok {
+		return nil, ErrNotFound
+	}
+	return append([]Revision(nil), man.history[id]...), nil
+}
+
+// Revision returns revision rev of the note with the given ID.
+func (man *NoteManager) Revision(id string, rev int) (Revision, error) {
+	man.mu.RLock()
+	defer man.mu.RUnlock()
+	return man.revision(id, rev)
+}
+
+func (man *NoteManager) revision(id string, rev int) (Revision, error) {
+	if _, ok := man.notes[id]; !ok {
+		return Revision{}, ErrNotFound
+	}
+	h := man.history[id]
+	if rev < 1 || rev > len(h) {
+		return Revision{}, ErrNoRevision
+	}
+	return h[rev-1], nil
+}
+
+// Diff returns the line diff from revision from to revision to of the note
+// with the given ID, or ErrDiffTooLarge.
+func (man *NoteManager) Diff(id string, from, to int) ([]DiffLine, error) {
+	man.mu.RLock()
+	defer man.mu.RUnlock()
+	a, err := man.revision(id, from)
+	if err != nil {
+		return nil, err
+	}
+	b, err := man.revision(id, to)
+	if err != nil {
+		return nil, err
+	}
+	if strings.Count(a.Content, "\n")+strings.Count(b.Content, "\n")+2 > MaxDiffLines {
+		return nil, ErrDiffTooLarge
+	}
+	return diffLines(a.Content, b.Content), nil
+}
+
+// Revert saves the content of revision rev as the newest revision of the
+// note with the given ID and returns the updated note. The revisions in
+// between are kept.
+func (man *NoteManager) Revert(id string, rev int) (*Note, error) {
+	man.mu.Lock()
+	defer man.mu.Unlock()
+	r, err := man.revision(id, rev)
+	if err != nil {
+		return nil, err
+	}
+	n := *man.notes[id]
+	n.Content = r.Content
+	if err := man.save(&n); err != nil {
+		return nil, err
+	}
+	return &n, nil
+}
+
+// diffLines computes a line diff of a and b with the linear space variant
+// of Myers' algorithm, which takes O((N+M)D) time for N and M lines and D
+// differences.
+func diffLines(a, b string) []DiffLine {
+	x := strings.Split(a, "\n")
+	y := strings.Split(b, "\n")
+	// lines are compared by number.
+	nums := make(map[string]int)
+	number := func(lines []string) []int {
+		ns := make([]int, len(lines))
+		for i, l := range lines {
+			n, ok := nums[l]
+			if !ok {
+				n = len(nums)
+				nums[l] = n
+			}
+			ns[i] = n
+		}
+		return ns
+	}
+	xn, yn := number(x), number(y)
+
+	var d []DiffLine
+	var walk func(x0, x1, y0, y1 int)
+	walk = func(x0, x1, y0, y1 int) {
+		for x0 < x1 && y0 < y1 && xn[x0] == yn[y0] {
+			d = append(d, DiffLine{" ", x[x0]})
+			x0++
+			y0++
+		}
+		common := x1
+		for x1 > x0 && y1 > y0 && xn[x1-1] == yn[y1-1] {
+			x1--
+			y1--
+		}
+		if sx, sy, ok := bisect(xn[x0:x1], yn[y0:y1]); ok {
+			walk(x0, x0+sx, y0, y0+sy)
+			walk(x0+sx, x1, y0+sy, y1)
+		} else {
+			for i := x0; i < x1; i++ {
+				d = append(d, DiffLine{"-", x[i]})
+			}
+			for j := y0; j < y1; j++ {
+				d = append(d, DiffLine{"+", y[j]})
+			}
+		}
+		for i := x1; i < common; i++ {
+			d = append(d, DiffLine{" ", x[i]})
+		}
+	}
+	walk(0, len(x), 0, len(y))
+	return d
+}
+
+// bisect finds where the shortest edit script from a to b crosses its
+// middle, searching forward from the start and backward from the end at
+// once. It returns false if a and b have nothing in common, or either is
+// empty.
+func bisect(a, b []int) (int, int, bool) {
+	n, m := len(a), len(b)
+	if n == 0 || m == 0 {
+		return 0, 0, false
+	}
+	max := (n + m + 1) / 2
+	off := max
+	// v1 and v2 hold the furthest x reached on every diagonal k = x - y,
+	// forward and backward.
+	v1 := make([]int, 2*max+2)
+	v2 := make([]int, 2*max+2)
+	for i := range v1 {
+		v1[i], v2[i] = -1, -1
+	}
+	v1[off+1], v2[off+1] = 0, 0
+	delta := n - m
+	// with an odd delta the paths meet going forward, otherwise backward.
+	front := delta%2 != 0
+	var k1start, k1end, k2start, k2end int
+	for d := 0; d < max; d++ {
+		for k1 := -d + k1start; k1 <= d-k1end; k1 += 2 {
+			k1off := off + k1
+			var x1 int
+			if k1 == -d || (k1 != d && v1[k1off-1] < v1[k1off+1]) {
+				x1 = v1[k1off+1]
+			} else {
+				x1 = v1[k1off-1] + 1
+			}
+			y1 := x1 - k1
+			for x1 < n && y1 < m && a[x1] == b[y1] {
+				x1++
+				y1++
+			}
+			v1[k1off] = x1
+			switch {
+			case x1 > n:
+				k1end += 2
+			case y1 > m:
+				k1start += 2
+			case front:
+				k2off := off + delta - k1
+				if k2off >= 0 && k2off < len(v2) && v2[k2off] != -1 && x1 >= n-v2[k2off] {
+					return x1, y1, true
+				}
+			}
+		}
+		for k2 := -d + k2start; k2 <= d-k2end; k2 += 2 {
+			k2off := off + k2
+			var x2 int
+			if k2 == -d || (k2 != d && v2[k2off-1] < v2[k2off+1]) {
+				x2 = v2[k2off+1]
+			} else {
+				x2 = v2[k2off-1] + 1
+			}
+			y2 := x2 - k2
+			for x2 < n && y2 < m && a[n-x2-1] == b[m-y2-1] {
+				x2++
+				y2++
+			}
+			v2[k2off] = x2
+			switch {
+			case x2 > n:
+				k2end += 2
+			case y2 > m:
+				k2start += 2
+			case !front:
+				k1off := off + delta - k2
+				if k1off >= 0 && k1off < len(v1) && v1[k1off] != -1 {
+					x1 := v1[k1off]
+					if x1 >= n-x2 {
+						return x1, off + x1 - k1off, true
+					}
+				}
+			}
+		}
+	}
+	return 0, 0, false
+}
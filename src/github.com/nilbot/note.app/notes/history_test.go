@@ -0,0 +1,147 @@
+package notes
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// tickingClock returns a clock advancing one minute on every call.
+func tickingClock() func() time.Time {
+	t := time.Date(2015, 3, 1, 10, 0, 0, 0, time.UTC)
+	return func() time.Time {
+		t = t.Add(time.Minute)
+		return t
+	}
+}
+
+func editOrFatal(t *testing.T, man *NoteManager, id string, contents ...string) {
+	for _, c := range contents {
+		if err := man.Save(&Note{ID: id, Content: c}); err != nil {
+			t.Fatalf("save: %v", err)
+		}
+	}
+}
+
+func TestRevisions(t *testing.T) {
+	man := NewNoteManager()
+	man.now = tickingClock()
+	note := newNoteOrFatal(t, "buy milk")
+	editOrFatal(t, man, note.ID, "buy milk", "buy milk", "buy milk #todo")
+
+	revs, err := man.Revisions(note.ID)
+	if err != nil {
+		t.Fatalf("revisions: %v", err)
+	}
+	if len(revs) != 2 {
+		t.Fatalf("expected 2 revisions, got %v", revs)
+	}
+	for i, r := range revs {
+		if r.Rev != i+1 {
+			t.Errorf("expected revision %d, got %d", i+1, r.Rev)
+		}
+	}
+	if !revs[0].Time.Before(revs[1].Time) {
+		t.Errorf("expected ordered timestamps, got %v and %v", revs[0].Time, revs[1].Time)
+	}
+	if r, err := man.Revision(note.ID, 1); err != nil || r.Content != "buy milk" {
+		t.Errorf("expected first revision %q, got %q, %v", "buy milk", r.Content, err)
+	}
+	if _, err := man.Revision(note.ID, 3); err != ErrNoRevision {
+		t.Errorf("expected %v, got %v", ErrNoRevision, err)
+	}
+	if _, err := man.Revisions("missing"); err != ErrNotFound {
+		t.Errorf("expected %v, got %v", ErrNotFound, err)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	man := NewNoteManager()
+	note := newNoteOrFatal(t, "groceries\nmilk\nbread")
+	editOrFatal(t, man, note.ID, "groceries\nmilk\nbread", "groceries\nbread\neggs #todo")
+
+	d, err := man.Diff(note.ID, 1, 2)
+	if err != nil {
+		t.Fatalf("diff: %v", err)
+	}
+	want := []DiffLine{
+		{" ", "groceries"},
+		{"-", "milk"},
+		{" ", "bread"},
+		{"+", "eggs #todo"},
+	}
+	if !reflect.DeepEqual(d, want) {
+		t.Errorf("expected %v, got %v", want, d)
+	}
+}
+
+func TestDiffLarge(t *testing.T) {
+	man := NewNoteManager()
+	lines := make([]string, MaxDiffLines/2-1)
+	for i := range lines {
+		lines[i] = fmt.Sprintf("line %d", i)
+	}
+	before := strings.Join(lines, "\n")
+	lines[len(lines)/2] = "changed"
+	editOrFatal(t, man, "aaaa0001", before, strings.Join(lines, "\n"))
+	d, err := man.Diff("aaaa0001", 1, 2)
+	if err != nil {
+		t.Fatalf("diff: %v", err)
+	}
+	if len(d) != len(lines)+1 {
+		t.Errorf("expected a single line to change, got %d lines", len(d))
+	}
+
+	editOrFatal(t, man, "aaaa0001", before+"\nand\nmore\nlines")
+	if _, err := man.Diff("aaaa0001", 2, 3); err != ErrDiffTooLarge {
+		t.Errorf("expected %v, got %v", ErrDiffTooLarge, err)
+	}
+}
+
+func TestRevert(t *testing.T) {
+	man := NewNoteManager()
+	note := newNoteOrFatal(t, "first #one")
+	editOrFatal(t, man, note.ID, "first #one", "second #two")
+
+	n, err := man.Revert(note.ID, 1)
+	if err != nil {
+		t.Fatalf("revert: %v", err)
+	}
+	if n.Content != "first #one" {
+		t.Errorf("expected reverted content %q, got %q", "first #one", n.Content)
+	}
+	if revs, _ := man.Revisions(note.ID); len(revs) != 3 {
+		t.Errorf("expected 3 revisions, got %v", len(revs))
+	}
+	if _, ok := man.NotesWith("two"); ok {
+		t.Errorf("expected tag %q to be dropped on revert", "two")
+	}
+	if ids, _ := man.NotesWith("one"); len(ids) != 1 {
+		t.Errorf("expected tag %q back on revert, got %v", "one", ids)
+	}
+}
+
+func TestHistoryPersists(t *testing.T) {
+	dir := tempDirOrFatal(t)
+	defer os.RemoveAll(dir)
+
+	for _, open := range []func() *NoteManager{
+		func() *NoteManager { return openFileManagerOrFatal(t, dir+"/file") },
+		func() *NoteManager { return openWALManagerOrFatal(t, dir+"/wal", WALOptions{CompactEvery: 2}) },
+	} {
+		man := open()
+		note := newNoteOrFatal(t, "v1")
+		editOrFatal(t, man, note.ID, "v1", "v2", "v3")
+		man.Close()
+
+		man = open()
+		revs, err := man.Revisions(note.ID)
+		if err != nil || len(revs) != 3 || revs[2].Content != "v3" {
+			t.Errorf("expected 3 revisions after reload, got %v, %v", revs, err)
+		}
+		man.Close()
+	}
+}
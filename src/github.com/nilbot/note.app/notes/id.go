@@ -0,0 +1,46 @@
+package notes
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// IDGenerator returns a new unique note ID.
+type IDGenerator func() string
+
+// GenerateID is used by NewNote to identify new notes. It may be replaced
+// before any note is created.
+var GenerateID IDGenerator = TimeID
+
+var timeID struct {
+	sync.Mutex
+	ms  uint64
+	seq uint64
+}
+
+// TimeID returns a 24 character hex ID made of the milliseconds since the
+// epoch followed by 48 random bits. IDs generated within the same
+// millisecond increment the random part, so IDs from one process sort in
+// the order they were generated.
+func TimeID() string {
+	ms := uint64(time.Now().UnixNano() / int64(time.Millisecond))
+
+	timeID.Lock()
+	defer timeID.Unlock()
+	if ms <= timeID.ms {
+		ms = timeID.ms
+		timeID.seq++
+	} else {
+		var b [8]byte
+		if _, err := rand.Read(b[2:]); err != nil {
+			panic(err)
+		}
+		// leave room in the random part for the IDs to come.
+		timeID.seq = binary.BigEndian.Uint64(b[:]) >> 1
+	}
+	timeID.ms = ms
+	return fmt.Sprintf("%012x%012x", ms&(1<<48-1), timeID.seq&(1<<48-1))
+}
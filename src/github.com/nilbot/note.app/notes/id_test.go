@@ -0,0 +1,25 @@
+package notes
+
+import "testing"
+
+func TestTimeIDOrdered(t *testing.T) {
+	prev := TimeID()
+	for i := 0; i < 1000; i++ {
+		id := TimeID()
+		if len(id) != 24 {
+			t.Fatalf("expected a 24 character ID, got %q", id)
+		}
+		if id <= prev {
+			t.Fatalf("expected %q to sort after %q", id, prev)
+		}
+		prev = id
+	}
+}
+
+func TestGenerateID(t *testing.T) {
+	defer func(g IDGenerator) { GenerateID = g }(GenerateID)
+	GenerateID = func() string { return "fixed" }
+	if n := newNoteOrFatal(t, "content"); n.ID != "fixed" {
+		t.Errorf("expected ID %q, got %q", "fixed", n.ID)
+	}
+}
@@ -0,0 +1,549 @@
+package notes
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+)
+
+// DefaultNotebook is the notebook the notes kept before notebooks existed
+// are migrated into.
+const DefaultNotebook = "default"
+
+var (
+	// ErrNoNotebook is returned when a library has no notebook with a name.
+	ErrNoNotebook = errors.New("notebook not found")
+	// ErrNotebookExists is returned when creating a notebook whose name is
+	// taken.
+	ErrNotebookExists = errors.New("notebook already exists")
+	// ErrNoUser is returned when a library has no user with a name.
+	ErrNoUser = errors.New("user not found")
+	// ErrUserExists is returned when adding a user whose name is taken.
+	ErrUserExists = errors.New("user already exists")
+	// ErrBadCredentials is returned when a user can't be authenticated.
+	ErrBadCredentials = errors.New("invalid user name or password")
+	// ErrInvalidName is returned for names of users and notebooks that
+	// aren't lower case letters, digits, dots, dashes and underscores.
+	ErrInvalidName = errors.New("invalid name")
+	// ErrShareOwner is returned when sharing a notebook with its owner.
+	ErrShareOwner = errors.New("notebook is owned by the user")
+)
+
+// validName matches the names of users and notebooks. They are used as
+// file names and in URLs, so they are kept simple.
+var validName = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{0,63}$`)
+
+// Access is what a user may do with the notes of a notebook.
+type Access int
+
+const (
+	NoAccess Access = iota
+	ReadAccess
+	WriteAccess
+)
+
+var accessNames = []string{"none", "read", "write"}
+
+func (a Access) String() string {
+	if a < NoAccess || a > WriteAccess {
+		return fmt.Sprintf("Access(%d)", int(a))
+	}
+	return accessNames[a]
+}
+
+// MarshalText encodes a as none, read or write.
+func (a Access) MarshalText() ([]byte, error) {
+	if a < NoAccess || a > WriteAccess {
+		return nil, fmt.Errorf("invalid access %d", int(a))
+	}
+	return []byte(a.String()), nil
+}
+
+// UnmarshalText decodes none, read or write.
+func (a *Access) UnmarshalText(text []byte) error {
+	for i, name := range accessNames {
+		if string(text) == name {
+			*a = Access(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid access %q", text)
+}
+
+// Notebook is a namespace of notes, with its own tags, owned by a user who
+// may share it with others.
+type Notebook struct {
+	Name   string
+	Owner  string
+	Shares map[string]Access `json:",omitempty"`
+}
+
+// Access returns what user may do with the notes of nb: owners may write
+// to their notebooks, other users as shared with them.
+func (nb *Notebook) Access(user string) Access {
+	if user == nb.Owner {
+		return WriteAccess
+	}
+	return nb.Shares[user]
+}
+
+func (nb *Notebook) copy() Notebook {
+	c := *nb
+	c.Shares = make(map[string]Access, len(nb.Shares))
+	for u, a := range nb.Shares {
+		c.Shares[u] = a
+	}
+	return c
+}
+
+// account is a user of a library, who authenticates with a password. Only
+// a key derived from the password is kept.
+type account struct {
+	Name string
+	KDF  KDF
+	Salt []byte
+	Key  []byte
+}
+
+// StoreOpener opens the store of the notebook with the given name.
+type StoreOpener func(notebook string) (Store, error)
+
+// Library holds the users and the notebooks they own and share. Every
+// notebook is kept by its own NoteManager, opened from its store the first
+// time it is needed.
+//
+// Library is safe for concurrent use.
+type Library struct {
+	mu        sync.Mutex
+	path      string
+	open      StoreOpener
+	users     map[string]*account
+	notebooks map[string]*Notebook
+	managers  map[string]*NoteManager
+	watchers  []func(name string, man *NoteManager)
+	// verified holds the sha256 of the last password every user was
+	// authenticated with, so keys aren't derived on every request.
+	verified map[string][sha256.Size]byte
+}
+
+// libraryFile is the JSON encoding of the users and notebooks of a library.
+type libraryFile struct {
+	Users     []*account
+	Notebooks []*Notebook
+}
+
+// NewLibrary returns a Library keeping its users and notes in memory only.
+func NewLibrary() *Library {
+	return newLibrary("", func(string) (Store, error) {
+		return NewMemoryStore(), nil
+	})
+}
+
+func newLibrary(path string, open StoreOpener) *Library {
+	return &Library{
+		path:      path,
+		open:      open,
+		users:     make(map[string]*account),
+		notebooks: make(map[string]*Notebook),
+		managers:  make(map[string]*NoteManager),
+		verified:  make(map[string][sha256.Size]byte),
+	}
+}
+
+// OpenLibrary opens, or creates, a Library keeping its users and notebooks
+// in the file at path, and the notes of every notebook in the store
+// returned by open for its name, such as
+//
+//	notes.OpenLibrary(filepath.Join(dir, "library.json"), func(nb string) (notes.Store, error) {
+//		return notes.OpenWALStore(filepath.Join(dir, "notebooks", nb), notes.WALOptions{})
+//	})
+func OpenLibrary(path string, open StoreOpener) (*Library, error) {
+	l := newLibrary(path, open)
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return l, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var f libraryFile
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	for _, u := range f.Users {
+		l.users[u.Name] = u
+	}
+	for _, nb := range f.Notebooks {
+		l.notebooks[nb.Name] = nb
+	}
+	return l, nil
+}
+
+// save writes the users and notebooks to a temporary file renamed into
+// place, so a crash leaves either the old or the new file.
+func (l *Library) save() error {
+	if l.path == "" {
+		return nil
+	}
+	f := libraryFile{Users: []*account{}, Notebooks: []*Notebook{}}
+	for _, u := range l.users {
+		f.Users = append(f.Users, u)
+	}
+	sort.Slice(f.Users, func(i, j int) bool { return f.Users[i].Name < f.Users[j].Name })
+	for _, nb := range l.notebooks {
+		f.Notebooks = append(f.Notebooks, nb)
+	}
+	sort.Slice(f.Notebooks, func(i, j int) bool { return f.Notebooks[i].Name < f.Notebooks[j].Name })
+	b, err := json.MarshalIndent(f, "", "\t")
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(l.path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(dir, filepath.Base(l.path)+".tmp-")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(b)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), l.path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return syncDir(dir)
+}
+
+// AddUser adds a user authenticating with password.
+func (l *Library) AddUser(name, password string) error {
+	if !validName.MatchString(name) {
+		return ErrInvalidName
+	}
+	if password == "" {
+		return ErrEmptyPassphrase
+	}
+	// keys are derived outside the lock, it takes a while.
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	kdf := DefaultKDF
+	key, err := kdf.key(password, salt)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.users[name]; ok {
+		return ErrUserExists
+	}
+	l.users[name] = &account{name, kdf, salt, key}
+	if err := l.save(); err != nil {
+		delete(l.users, name)
+		return err
+	}
+	return nil
+}
+
+// Users returns the names of the users, in order.
+func (l *Library) Users() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	names := make([]string, 0, len(l.users))
+	for name := range l.users {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Authenticate returns ErrBadCredentials unless the user with the given
+// name has the given password.
+func (l *Library) Authenticate(name, password string) error {
+	sum := sha256.Sum256([]byte(password))
+	l.mu.Lock()
+	u, ok := l.users[name]
+	last, seen := l.verified[name]
+	l.mu.Unlock()
+	if !ok {
+		return ErrBadCredentials
+	}
+	if seen && subtle.ConstantTimeCompare(last[:], sum[:]) == 1 {
+		return nil
+	}
+	key, err := u.KDF.key(password, u.Salt)
+	if err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare(key, u.Key) != 1 {
+		return ErrBadCredentials
+	}
+	l.mu.Lock()
+	l.verified[name] = sum
+	l.mu.Unlock()
+	return nil
+}
+
+// CreateNotebook creates an empty notebook owned by the given user.
+func (l *Library) CreateNotebook(name, owner string) (Notebook, error) {
+	if !validName.MatchString(name) {
+		return Notebook{}, ErrInvalidName
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.users[owner]; !ok {
+		return Notebook{}, ErrNoUser
+	}
+	if _, ok := l.notebooks[name]; ok {
+		return Notebook{}, ErrNotebookExists
+	}
+	nb := &Notebook{Name: name, Owner: owner}
+	l.notebooks[name] = nb
+	if err := l.save(); err != nil {
+		delete(l.notebooks, name)
+		return Notebook{}, err
+	}
+	return nb.copy(), nil
+}
+
+// Notebook returns the notebook with the given name.
+func (l *Library) Notebook(name string) (Notebook, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	nb, ok := l.notebooks[name]
+	if !ok {
+		return Notebook{}, false
+	}
+	return nb.copy(), true
+}
+
+// Notebooks returns the notebooks user may read, ordered by name.
+func (l *Library) Notebooks(user string) []Notebook {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	nbs := []Notebook{}
+	for _, nb := range l.notebooks {
+		if nb.Access(user) > NoAccess {
+			nbs = append(nbs, nb.copy())
+		}
+	}
+	sort.Slice(nbs, func(i, j int) bool { return nbs[i].Name < nbs[j].Name })
+	return nbs
+}
+
+// Share gives user access to the notebook with the given name. Sharing
+// with NoAccess stops sharing the notebook with user.
+func (l *Library) Share(name, user string, a Access) error {
+	if a < NoAccess || a > WriteAccess {
+		return fmt.Errorf("invalid access %d", int(a))
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	nb, ok := l.notebooks[name]
+	if !ok {
+		return ErrNoNotebook
+	}
+	if _, ok := l.users[user]; !ok {
+		return ErrNoUser
+	}
+	if user == nb.Owner {
+		return ErrShareOwner
+	}
+	old, shared := nb.Shares[user]
+	if a == NoAccess {
+		delete(nb.Shares, user)
+	} else {
+		if nb.Shares == nil {
+			nb.Shares = make(map[string]Access)
+		}
+		nb.Shares[user] = a
+	}
+	if err := l.save(); err != nil {
+		if shared {
+			nb.Shares[user] = old
+		} else {
+			delete(nb.Shares, user)
+		}
+		return err
+	}
+	return nil
+}
+
+// Manager returns the NoteManager keeping the notes of the notebook with
+// the given name, opening it the first time.
+func (l *Library) Manager(name string) (*NoteManager, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.notebooks[name]; !ok {
+		return nil, ErrNoNotebook
+	}
+	if man, ok := l.managers[name]; ok {
+		return man, nil
+	}
+	s, err := l.open(name)
+	if err != nil {
+		return nil, err
+	}
+	man, err := OpenNoteManager(s)
+	if err != nil {
+		s.Close()
+		return nil, fmt.Errorf("notebook %s: %v", name, err)
+	}
+	l.opened(name, man)
+	return man, nil
+}
+
+// Watch calls f with the manager of every notebook opened so far, and of
+// every notebook opened from now on as it is opened. f is called with the
+// library locked, so it must not call its methods.
+func (l *Library) Watch(f func(name string, man *NoteManager)) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.watchers = append(l.watchers, f)
+	for name, man := range l.managers {
+		f(name, man)
+	}
+}
+
+// opened records man as the manager of the notebook with the given name
+// and tells the watchers.
+func (l *Library) opened(name string, man *NoteManager) {
+	l.managers[name] = man
+	for _, f := range l.watchers {
+		f(name, man)
+	}
+}
+
+// Managers returns the managers of every notebook, ordered by name, opening
+// the ones not opened yet.
+func (l *Library) Managers() ([]*NoteManager, error) {
+	l.mu.Lock()
+	names := make([]string, 0, len(l.notebooks))
+	for name := range l.notebooks {
+		names = append(names, name)
+	}
+	l.mu.Unlock()
+	sort.Strings(names)
+	mans := make([]*NoteManager, len(names))
+	for i, name := range names {
+		man, err := l.Manager(name)
+		if err != nil {
+			return nil, err
+		}
+		mans[i] = man
+	}
+	return mans, nil
+}
+
+// Migrate creates DefaultNotebook, owned by the given user, with the notes,
+// revisions and tombstones held by from, the store of the notes kept before
+// notebooks existed. Nothing is done once DefaultNotebook exists, and false
+// is returned. from is left untouched.
+func (l *Library) Migrate(from Store, owner string) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.notebooks[DefaultNotebook]; ok {
+		return false, nil
+	}
+	if _, ok := l.users[owner]; !ok {
+		return false, ErrNoUser
+	}
+	ns, err := from.Load()
+	if err != nil {
+		return false, err
+	}
+	history, err := from.LoadHistory()
+	if err != nil {
+		return false, err
+	}
+	tombstones, err := from.LoadTombstones()
+	if err != nil {
+		return false, err
+	}
+	s, err := l.open(DefaultNotebook)
+	if err != nil {
+		return false, err
+	}
+	// revisions copied by an interrupted migration aren't copied twice.
+	copied, err := s.LoadHistory()
+	if err != nil {
+		s.Close()
+		return false, err
+	}
+	for _, n := range ns {
+		revs := history[n.ID]
+		if len(copied[n.ID]) <= len(revs) {
+			revs = revs[len(copied[n.ID]):]
+		}
+		if len(revs) == 0 {
+			err = s.Put(n, nil)
+		} else {
+			// every revision is put along with the note, as one batch.
+			batch := make([]*Note, len(revs))
+			rs := make([]*Revision, len(revs))
+			for i := range revs {
+				batch[i], rs[i] = n, &revs[i]
+			}
+			err = s.PutAll(batch, rs)
+		}
+		if err != nil {
+			s.Close()
+			return false, err
+		}
+	}
+	// deletions are still synced to peers.
+	for id, vs := range tombstones {
+		if err := s.Delete(id, vs); err != nil {
+			s.Close()
+			return false, err
+		}
+	}
+	man, err := OpenNoteManager(s)
+	if err != nil {
+		s.Close()
+		return false, err
+	}
+	// the notebook is recorded last, so an interrupted migration is
+	// resumed.
+	l.notebooks[DefaultNotebook] = &Notebook{Name: DefaultNotebook, Owner: owner}
+	if err := l.save(); err != nil {
+		delete(l.notebooks, DefaultNotebook)
+		man.Close()
+		return false, err
+	}
+	l.opened(DefaultNotebook, man)
+	return true, nil
+}
+
+// Close closes the managers of the notebooks opened.
+func (l *Library) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var err error
+	for name, man := range l.managers {
+		if cerr := man.Close(); err == nil {
+			err = cerr
+		}
+		delete(l.managers, name)
+	}
+	return err
+}
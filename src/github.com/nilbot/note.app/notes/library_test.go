@@ -0,0 +1,225 @@
+package notes
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func addUserOrFatal(t *testing.T, l *Library, names ...string) {
+	for _, name := range names {
+		if err := l.AddUser(name, name+" password"); err != nil {
+			t.Fatalf("add user %s: %v", name, err)
+		}
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	defer fastKDF()()
+	l := NewLibrary()
+	addUserOrFatal(t, l, "alice")
+	if err := l.AddUser("alice", "again"); err != ErrUserExists {
+		t.Errorf("expected user names to be unique, got %v", err)
+	}
+	if err := l.AddUser("Bob:1", "pw"); err != ErrInvalidName {
+		t.Errorf("expected an invalid name, got %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := l.Authenticate("alice", "alice password"); err != nil {
+			t.Errorf("expected alice to be authenticated, got %v", err)
+		}
+		if err := l.Authenticate("alice", "wrong"); err != ErrBadCredentials {
+			t.Errorf("expected a wrong password to be refused, got %v", err)
+		}
+	}
+	if err := l.Authenticate("mallory", "alice password"); err != ErrBadCredentials {
+		t.Errorf("expected unknown users to be refused, got %v", err)
+	}
+}
+
+func TestShareNotebook(t *testing.T) {
+	defer fastKDF()()
+	l := NewLibrary()
+	addUserOrFatal(t, l, "alice", "bob", "carol")
+	if _, err := l.CreateNotebook("ops", "alice"); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if _, err := l.CreateNotebook("ops", "bob"); err != ErrNotebookExists {
+		t.Errorf("expected notebook names to be unique, got %v", err)
+	}
+	if _, err := l.CreateNotebook("../ops", "bob"); err != ErrInvalidName {
+		t.Errorf("expected an invalid name, got %v", err)
+	}
+	if _, err := l.CreateNotebook("dave", "dave"); err != ErrNoUser {
+		t.Errorf("expected notebooks to be owned by users, got %v", err)
+	}
+
+	if err := l.Share("ops", "bob", WriteAccess); err != nil {
+		t.Fatalf("share: %v", err)
+	}
+	if err := l.Share("ops", "carol", ReadAccess); err != nil {
+		t.Fatalf("share: %v", err)
+	}
+	if err := l.Share("ops", "alice", ReadAccess); err != ErrShareOwner {
+		t.Errorf("expected owners to keep their access, got %v", err)
+	}
+	nb, _ := l.Notebook("ops")
+	for user, expected := range map[string]Access{"alice": WriteAccess, "bob": WriteAccess, "carol": ReadAccess, "dave": NoAccess} {
+		if a := nb.Access(user); a != expected {
+			t.Errorf("expected %s to have %v access, got %v", user, expected, a)
+		}
+	}
+	if err := l.Share("ops", "bob", NoAccess); err != nil {
+		t.Fatalf("unshare: %v", err)
+	}
+	if nbs := l.Notebooks("bob"); len(nbs) != 0 {
+		t.Errorf("expected bob to lose access, got %+v", nbs)
+	}
+	if nbs := l.Notebooks("carol"); len(nbs) != 1 || nbs[0].Name != "ops" {
+		t.Errorf("expected carol to see the notebook, got %+v", nbs)
+	}
+}
+
+func TestNotebookTags(t *testing.T) {
+	defer fastKDF()()
+	l := NewLibrary()
+	addUserOrFatal(t, l, "alice", "bob")
+	l.CreateNotebook("work", "alice")
+	l.CreateNotebook("home", "bob")
+	work, err := l.Manager("work")
+	if err != nil {
+		t.Fatalf("manager: %v", err)
+	}
+	home, _ := l.Manager("home")
+	editOrFatal(t, work, "aaaa0001", "deploy #ops")
+	editOrFatal(t, home, "aaaa0001", "groceries #shopping")
+	if tags := work.AllTags(); !reflect.DeepEqual(tags, []string{"ops"}) {
+		t.Errorf("expected the tags of work only, got %v", tags)
+	}
+	if n, _ := home.Find("aaaa0001"); n.Content != "groceries #shopping" {
+		t.Errorf("expected notebooks to hold their own notes, got %q", n.Content)
+	}
+	if again, _ := l.Manager("work"); again != work {
+		t.Errorf("expected notebooks to be opened once")
+	}
+	if _, err := l.Manager("missing"); err != ErrNoNotebook {
+		t.Errorf("expected a missing notebook, got %v", err)
+	}
+}
+
+func TestWatchNotebooks(t *testing.T) {
+	defer fastKDF()()
+	l := NewLibrary()
+	addUserOrFatal(t, l, "alice")
+	l.CreateNotebook("work", "alice")
+	l.CreateNotebook("home", "alice")
+	work, _ := l.Manager("work")
+	watched := make(map[string]*NoteManager)
+	l.Watch(func(name string, man *NoteManager) {
+		watched[name] = man
+	})
+	if len(watched) != 1 || watched["work"] != work {
+		t.Errorf("expected the notebooks opened to be watched, got %v", watched)
+	}
+	home, _ := l.Manager("home")
+	l.Manager("home")
+	if len(watched) != 2 || watched["home"] != home {
+		t.Errorf("expected notebooks opened later to be watched, got %v", watched)
+	}
+}
+
+func TestOpenLibrary(t *testing.T) {
+	defer fastKDF()()
+	dir, err := ioutil.TempDir("", "library")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "library.json")
+	open := func(nb string) (Store, error) {
+		return OpenFileStore(filepath.Join(dir, "notebooks", nb))
+	}
+	l, err := OpenLibrary(path, open)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	addUserOrFatal(t, l, "alice", "bob")
+	l.CreateNotebook("ops", "alice")
+	l.Share("ops", "bob", ReadAccess)
+	man, _ := l.Manager("ops")
+	editOrFatal(t, man, "aaaa0001", "runbook #ops")
+	if err := l.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if b, _ := ioutil.ReadFile(path); strings.Contains(string(b), "password") {
+		t.Errorf("expected passwords not to be kept, got %s", b)
+	}
+
+	l, err = OpenLibrary(path, open)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer l.Close()
+	if err := l.Authenticate("bob", "bob password"); err != nil {
+		t.Errorf("expected users to be kept, got %v", err)
+	}
+	if nb, _ := l.Notebook("ops"); nb.Owner != "alice" || nb.Access("bob") != ReadAccess {
+		t.Errorf("expected notebooks to be kept, got %+v", nb)
+	}
+	man, _ = l.Manager("ops")
+	if n, ok := man.Find("aaaa0001"); !ok || n.Content != "runbook #ops" {
+		t.Errorf("expected the notes of the notebook to be kept, got %+v", n)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	defer fastKDF()()
+	legacy := NewMemoryStore()
+	old, err := OpenNoteManager(legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	editOrFatal(t, old, "aaaa0001", "first #todo")
+	editOrFatal(t, old, "aaaa0001", "second #todo")
+	editOrFatal(t, old, "aaaa0002", "other #misc")
+	editOrFatal(t, old, "aaaa0003", "deleted")
+	if err := old.Delete("aaaa0003"); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+
+	l := NewLibrary()
+	if _, err := l.Migrate(legacy, "admin"); err != ErrNoUser {
+		t.Errorf("expected the owner to be a user, got %v", err)
+	}
+	addUserOrFatal(t, l, "admin")
+	if ok, err := l.Migrate(legacy, "admin"); !ok || err != nil {
+		t.Fatalf("migrate: %v %v", ok, err)
+	}
+	if nb, _ := l.Notebook(DefaultNotebook); nb.Owner != "admin" {
+		t.Errorf("expected the default notebook to be owned by admin, got %+v", nb)
+	}
+	man, err := l.Manager(DefaultNotebook)
+	if err != nil {
+		t.Fatalf("manager: %v", err)
+	}
+	if n, _ := man.Find("aaaa0001"); n.Content != "second #todo" {
+		t.Errorf("expected the notes to be migrated, got %+v", n)
+	}
+	if tags := man.AllTags(); len(tags) != 2 {
+		t.Errorf("expected the tags to be migrated, got %v", tags)
+	}
+	revs, _ := man.Revisions("aaaa0001")
+	expected, _ := old.Revisions("aaaa0001")
+	if !reflect.DeepEqual(revs, expected) {
+		t.Errorf("expected the revisions to be migrated, got %+v", revs)
+	}
+	if vs := man.sync.tombstones["aaaa0003"]; !reflect.DeepEqual(vs, []string{digest("deleted")}) {
+		t.Errorf("expected the tombstones to be migrated, got %v", vs)
+	}
+	if ok, err := l.Migrate(legacy, "admin"); ok || err != nil {
+		t.Errorf("expected a single migration, got %v %v", ok, err)
+	}
+}
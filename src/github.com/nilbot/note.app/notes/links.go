@@ -0,0 +1,196 @@
+package notes
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// linkPattern matches [[target]] and [[target|label]] links between notes.
+var linkPattern = regexp.MustCompile(`\[\[([^\[\]\n|]+)(?:\|[^\[\]\n]*)?\]\]`)
+
+// Link is a [[Target]] reference from a note to another one. The target is
+// the ID of the note or its title, ID is the note it resolves to, empty if
+// the link is broken.
+type Link struct {
+	Target string
+	ID     string
+}
+
+// BrokenLink is a link whose target matches no note, or several notes by
+// title.
+type BrokenLink struct {
+	Source string
+	Target string
+}
+
+// parseLinks returns the distinct targets linked from content, in order.
+func parseLinks(content string) []string {
+	var targets []string
+	seen := make(map[string]bool)
+	for _, m := range linkPattern.FindAllStringSubmatch(content, -1) {
+		t := strings.TrimSpace(m[1])
+		if t != "" && !seen[t] {
+			seen[t] = true
+			targets = append(targets, t)
+		}
+	}
+	return targets
+}
+
+// noteTitle returns the first non-empty line of content, without the #
+// marks of a Markdown heading.
+func noteTitle(content string) string {
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if h := strings.TrimLeft(line, "#"); h != line && strings.HasPrefix(h, " ") {
+			line = strings.TrimSpace(h)
+		}
+		if line != "" {
+			return line
+		}
+	}
+	return ""
+}
+
+// linkKey normalises link targets and titles so [[Title]] matches a note
+// titled title.
+func linkKey(s string) string {
+	return strings.ToLower(strings.TrimSpace(s))
+}
+
+// linkIndex keeps the links of every note, the notes linking to every
+// target and the notes with every title.
+type linkIndex struct {
+	links   map[string][]string
+	sources map[string]map[string]bool
+	titles  map[string]map[string]bool
+	title   map[string]string
+}
+
+func newLinkIndex() *linkIndex {
+	return &linkIndex{
+		links:   make(map[string][]string),
+		sources: make(map[string]map[string]bool),
+		titles:  make(map[string]map[string]bool),
+		title:   make(map[string]string),
+	}
+}
+
+func addTo(m map[string]map[string]bool, key, id string) {
+	if m[key] == nil {
+		m[key] = make(map[string]bool)
+	}
+	m[key][id] = true
+}
+
+func removeFrom(m map[string]map[string]bool, key, id string) {
+	delete(m[key], id)
+	if len(m[key]) == 0 {
+		delete(m, key)
+	}
+}
+
+// add indexes the links and title of content for id, replacing what was
+// indexed for id before.
+func (idx *linkIndex) add(id, title, content string) {
+	idx.remove(id)
+	targets := parseLinks(content)
+	idx.links[id] = targets
+	for _, t := range targets {
+		addTo(idx.sources, linkKey(t), id)
+	}
+	idx.title[id] = linkKey(title)
+	addTo(idx.titles, linkKey(title), id)
+}
+
+// remove drops id from the index.
+func (idx *linkIndex) remove(id string) {
+	for _, t := range idx.links[id] {
+		removeFrom(idx.sources, linkKey(t), id)
+	}
+	delete(idx.links, id)
+	if t, ok := idx.title[id]; ok {
+		removeFrom(idx.titles, t, id)
+		delete(idx.title, id)
+	}
+}
+
+// resolveLink returns the ID of the note target refers to: the note with
+// that ID or else the only note with that title.
+func (man *NoteManager) resolveLink(target string) string {
+	if _, ok := man.notes[target]; ok {
+		return target
+	}
+	ids := man.links.titles[linkKey(target)]
+	if len(ids) != 1 {
+		return ""
+	}
+	for id := range ids {
+		return id
+	}
+	return ""
+}
+
+// Links returns the links of the note with the given ID, in order of
+// appearance. Broken links have an empty ID.
+func (man *NoteManager) Links(id string) ([]Link, error) {
+	man.mu.RLock()
+	defer man.mu.RUnlock()
+	if _, ok := man.notes[id]; !ok {
+		return nil, ErrNotFound
+	}
+	links := []Link{}
+	for _, t := range man.links.links[id] {
+		links = append(links, Link{t, man.resolveLink(t)})
+	}
+	return links, nil
+}
+
+// Backlinks returns the sorted IDs of the notes linking to the note with
+// the given ID, by ID or by title.
+func (man *NoteManager) Backlinks(id string) ([]string, error) {
+	man.mu.RLock()
+	defer man.mu.RUnlock()
+	if _, ok := man.notes[id]; !ok {
+		return nil, ErrNotFound
+	}
+	set := make(map[string]bool)
+	for _, key := range []string{linkKey(id), man.links.title[id]} {
+		for src := range man.links.sources[key] {
+			for _, t := range man.links.links[src] {
+				if linkKey(t) == key && man.resolveLink(t) == id {
+					set[src] = true
+				}
+			}
+		}
+	}
+	ids := []string{}
+	for src := range set {
+		ids = append(ids, src)
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// BrokenLinks returns the links that don't resolve to a note, ordered by
+// source note.
+func (man *NoteManager) BrokenLinks() []BrokenLink {
+	man.mu.RLock()
+	defer man.mu.RUnlock()
+	broken := []BrokenLink{}
+	for src, targets := range man.links.links {
+		for _, t := range targets {
+			if man.resolveLink(t) == "" {
+				broken = append(broken, BrokenLink{src, t})
+			}
+		}
+	}
+	sort.Slice(broken, func(i, j int) bool {
+		if broken[i].Source != broken[j].Source {
+			return broken[i].Source < broken[j].Source
+		}
+		return broken[i].Target < broken[j].Target
+	})
+	return broken
+}
@@ -0,0 +1,89 @@
+package notes
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestParseLinks(t *testing.T) {
+	tests := []struct {
+		content string
+		want    string
+	}{
+		{"no links", "[]"},
+		{"see [[abcd1234]] and [[ Shopping list ]]", "[abcd1234 Shopping list]"},
+		{"[[target|with a label]] twice [[target]]", "[target]"},
+		{"[[]] [[ ]] [[broken\nacross lines]] [single]", "[]"},
+		{"[[[nested]]]", "[nested]"},
+	}
+	for _, tt := range tests {
+		if got := fmt.Sprint(parseLinks(tt.content)); got != tt.want {
+			t.Errorf("%q: expected %v, got %v", tt.content, tt.want, got)
+		}
+	}
+}
+
+func TestNoteTitle(t *testing.T) {
+	tests := []struct{ content, want string }{
+		{"Shopping list\nmilk", "Shopping list"},
+		{"\n\n  # Heading  \ntext", "Heading"},
+		{"#todo buy milk", "#todo buy milk"},
+		{"  ", ""},
+	}
+	for _, tt := range tests {
+		if got := noteTitle(tt.content); got != tt.want {
+			t.Errorf("%q: expected %q, got %q", tt.content, tt.want, got)
+		}
+	}
+}
+
+func TestBacklinks(t *testing.T) {
+	man := NewNoteManager()
+	man.Save(&Note{ID: "list", Content: "# Shopping list\nmilk"})
+	man.Save(&Note{ID: "a", Content: "see [[list]]"})
+	man.Save(&Note{ID: "b", Content: "see [[shopping LIST|the list]] and [[missing]]"})
+	man.Save(&Note{ID: "c", Content: "no links"})
+
+	if ids, err := man.Backlinks("list"); err != nil || fmt.Sprint(ids) != "[a b]" {
+		t.Errorf("expected backlinks [a b], got %v, %v", ids, err)
+	}
+	links, err := man.Links("b")
+	if err != nil {
+		t.Fatalf("links: %v", err)
+	}
+	if fmt.Sprint(links) != "[{shopping LIST list} {missing }]" {
+		t.Errorf("unexpected links %v", links)
+	}
+	if broken := man.BrokenLinks(); fmt.Sprint(broken) != "[{b missing}]" {
+		t.Errorf("expected one broken link, got %v", broken)
+	}
+
+	// a note created with the missing title fixes the link.
+	man.Save(&Note{ID: "m", Content: "Missing\nnow here"})
+	if broken := man.BrokenLinks(); len(broken) != 0 {
+		t.Errorf("expected no broken link, got %v", broken)
+	}
+	if ids, _ := man.Backlinks("m"); fmt.Sprint(ids) != "[b]" {
+		t.Errorf("expected backlinks [b], got %v", ids)
+	}
+
+	// retitling the target breaks the link by title only.
+	man.Save(&Note{ID: "list", Content: "Groceries\nmilk"})
+	if ids, _ := man.Backlinks("list"); fmt.Sprint(ids) != "[a]" {
+		t.Errorf("expected backlinks [a], got %v", ids)
+	}
+
+	// a second note with the same title makes the link ambiguous.
+	man.Save(&Note{ID: "m2", Content: "missing"})
+	if broken := man.BrokenLinks(); fmt.Sprint(broken) != "[{b missing} {b shopping LIST}]" {
+		t.Errorf("unexpected broken links %v", broken)
+	}
+
+	man.Delete("a")
+	if ids, _ := man.Backlinks("list"); len(ids) != 0 {
+		t.Errorf("expected no backlinks after delete, got %v", ids)
+	}
+	if _, err := man.Backlinks("a"); err != ErrNotFound {
+		t.Errorf("expected %v, got %v", ErrNotFound, err)
+	}
+}
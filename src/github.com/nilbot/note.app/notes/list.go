@@ -0,0 +1,246 @@
+package notes
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SortKey selects the order of a listing of notes.
+type SortKey string
+
+const (
+	// SortCreated orders notes by creation time.
+	SortCreated SortKey = "created"
+	// SortUpdated orders notes by last update time.
+	SortUpdated SortKey = "updated"
+	// SortTitle orders notes by DisplayTitle.
+	SortTitle SortKey = "title"
+)
+
+// ErrInvalidCursor is returned by List for a cursor it didn't hand out, or
+// one handed out for another order.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// ListOptions selects and orders the notes returned by List. Pinned notes
+// always come first, then notes are ordered by Sort, in descending order if
+// Desc is set, and by ID for equal keys. Titles compare case insensitively.
+// Archived notes are left out unless Archived is set. A Limit of 0 returns
+// every note after Cursor.
+type ListOptions struct {
+	Sort     SortKey
+	Desc     bool
+	Limit    int
+	Cursor   string
+	Archived bool
+}
+
+// cursor is the position after the last note of a page, encoded as
+// base64 JSON so clients treat it as opaque.
+type cursor struct {
+	Sort   SortKey
+	Desc   bool
+	Pinned bool
+	Time   time.Time `json:",omitempty"`
+	Title  string    `json:",omitempty"`
+	ID     string
+}
+
+func (c cursor) String() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func parseCursor(s string) (cursor, error) {
+	var c cursor
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, ErrInvalidCursor
+	}
+	if err := json.Unmarshal(b, &c); err != nil {
+		return c, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// listEntry is a note with its sort keys, computed once as it is indexed.
+type listEntry struct {
+	n      *Note
+	pinned bool
+	time   time.Time
+	title  string
+	id     string
+}
+
+func (opt ListOptions) entry(n *Note) listEntry {
+	e := listEntry{n: n, pinned: n.Pinned, id: n.ID}
+	switch opt.Sort {
+	case SortCreated:
+		e.time = n.CreatedAt
+	case SortUpdated:
+		e.time = n.UpdatedAt
+	case SortTitle:
+		e.title = strings.ToLower(n.DisplayTitle())
+	}
+	return e
+}
+
+// less reports whether a is listed before b.
+func (opt ListOptions) less(a, b listEntry) bool {
+	if a.pinned != b.pinned {
+		return a.pinned
+	}
+	var c int
+	switch {
+	case a.time.Before(b.time), a.title < b.title:
+		c = -1
+	case a.time.After(b.time), a.title > b.title:
+		c = 1
+	case a.id < b.id:
+		c = -1
+	case a.id > b.id:
+		c = 1
+	}
+	if opt.Desc {
+		c = -c
+	}
+	return c < 0
+}
+
+// listIndex keeps the notes of a manager sorted by each key listed so far,
+// in ascending order with pinned notes first, so a page is found without
+// sorting every note. mu is held while listing, as orders are built by the
+// first listing in their order.
+type listIndex struct {
+	mu     sync.Mutex
+	sorted map[SortKey][]listEntry
+}
+
+func newListIndex() *listIndex {
+	return &listIndex{sorted: make(map[SortKey][]listEntry)}
+}
+
+// add inserts n in the orders built.
+func (idx *listIndex) add(n *Note) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	for key, es := range idx.sorted {
+		opt := ListOptions{Sort: key}
+		e := opt.entry(n)
+		i := sort.Search(len(es), func(i int) bool { return opt.less(e, es[i]) })
+		es = append(es, listEntry{})
+		copy(es[i+1:], es[i:])
+		es[i] = e
+		idx.sorted[key] = es
+	}
+}
+
+// remove drops n, as it was added, from the orders built.
+func (idx *listIndex) remove(n *Note) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	for key, es := range idx.sorted {
+		opt := ListOptions{Sort: key}
+		e := opt.entry(n)
+		i := sort.Search(len(es), func(i int) bool { return !opt.less(es[i], e) })
+		if i < len(es) && es[i].id == n.ID {
+			idx.sorted[key] = append(es[:i], es[i+1:]...)
+		}
+	}
+}
+
+// reset drops the orders built, so the next listings sort the notes again,
+// which is cheaper than adding many notes one by one.
+func (idx *listIndex) reset() {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.sorted = make(map[SortKey][]listEntry)
+}
+
+// order returns notes in the order of key, sorting them unless the order
+// was built already. idx.mu must be held.
+func (idx *listIndex) order(key SortKey, notes map[string]*Note) []listEntry {
+	if es, ok := idx.sorted[key]; ok {
+		return es
+	}
+	opt := ListOptions{Sort: key}
+	es := make([]listEntry, 0, len(notes))
+	for _, n := range notes {
+		es = append(es, opt.entry(n))
+	}
+	sort.Slice(es, func(i, j int) bool { return opt.less(es[i], es[j]) })
+	idx.sorted[key] = es
+	return es
+}
+
+// List returns a page of at most opt.Limit notes in the order of opt,
+// starting after opt.Cursor, and the cursor of the next page, empty if
+// there are no more notes. Only the notes of the page are copied, and only
+// the notes up to the end of the page are looked at once the order of
+// opt.Sort is built.
+func (man *NoteManager) List(opt ListOptions) ([]*Note, string, error) {
+	switch opt.Sort {
+	case SortCreated, SortUpdated, SortTitle:
+	default:
+		return nil, "", fmt.Errorf("unknown sort key %q", opt.Sort)
+	}
+	if opt.Limit < 0 {
+		return nil, "", fmt.Errorf("negative limit %d", opt.Limit)
+	}
+	var after *listEntry
+	if opt.Cursor != "" {
+		c, err := parseCursor(opt.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		if c.Sort != opt.Sort || c.Desc != opt.Desc {
+			return nil, "", ErrInvalidCursor
+		}
+		after = &listEntry{pinned: c.Pinned, time: c.Time, title: c.Title, id: c.ID}
+	}
+
+	man.mu.RLock()
+	defer man.mu.RUnlock()
+	man.order.mu.Lock()
+	defer man.order.mu.Unlock()
+	es := man.order.order(opt.Sort, man.notes)
+	// at returns the kth entry in the order of opt: the pinned notes, then
+	// the others, each in reverse if descending.
+	pinned := sort.Search(len(es), func(i int) bool { return !es[i].pinned })
+	at := func(k int) listEntry {
+		switch {
+		case !opt.Desc:
+			return es[k]
+		case k < pinned:
+			return es[pinned-1-k]
+		default:
+			return es[len(es)-1-(k-pinned)]
+		}
+	}
+	k := 0
+	if after != nil {
+		k = sort.Search(len(es), func(k int) bool { return opt.less(*after, at(k)) })
+	}
+	page := []*Note{}
+	next := ""
+	var last listEntry
+	for ; k < len(es); k++ {
+		e := at(k)
+		if e.n.Archived && !opt.Archived {
+			continue
+		}
+		if opt.Limit > 0 && len(page) == opt.Limit {
+			next = cursor{opt.Sort, opt.Desc, last.pinned, last.time, last.title, last.id}.String()
+			break
+		}
+		c := *e.n
+		page = append(page, &c)
+		last = e
+	}
+	return page, next, nil
+}
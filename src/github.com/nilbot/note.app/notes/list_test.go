@@ -0,0 +1,152 @@
+package notes
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func listManager(t *testing.T) *NoteManager {
+	man := NewNoteManager()
+	man.now = tickingClock()
+	for _, n := range []*Note{
+		{ID: "aaaa0001", Content: "# banana"},
+		{ID: "aaaa0002", Content: "apple", Pinned: true},
+		{ID: "aaaa0003", Content: "cherry", Archived: true},
+		{ID: "aaaa0004", Content: "Date"},
+		{ID: "aaaa0005", Title: "Apricot", Content: "elderberry"},
+	} {
+		if err := man.Save(n); err != nil {
+			t.Fatalf("save: %v", err)
+		}
+	}
+	// touch banana so it is the most recently updated note.
+	editOrFatal(t, man, "aaaa0001", "# banana\nripe")
+	return man
+}
+
+func listIDs(t *testing.T, man *NoteManager, opt ListOptions) ([]string, string) {
+	ns, next, err := man.List(opt)
+	if err != nil {
+		t.Fatalf("list %+v: %v", opt, err)
+	}
+	ids := []string{}
+	for _, n := range ns {
+		ids = append(ids, n.ID)
+	}
+	return ids, next
+}
+
+func TestListSort(t *testing.T) {
+	man := listManager(t)
+	for _, tc := range []struct {
+		opt ListOptions
+		ids []string
+	}{
+		{ListOptions{Sort: SortCreated}, []string{"aaaa0002", "aaaa0001", "aaaa0004", "aaaa0005"}},
+		{ListOptions{Sort: SortCreated, Desc: true}, []string{"aaaa0002", "aaaa0005", "aaaa0004", "aaaa0001"}},
+		{ListOptions{Sort: SortUpdated, Desc: true}, []string{"aaaa0002", "aaaa0001", "aaaa0005", "aaaa0004"}},
+		{ListOptions{Sort: SortTitle}, []string{"aaaa0002", "aaaa0005", "aaaa0001", "aaaa0004"}},
+		{ListOptions{Sort: SortTitle, Archived: true}, []string{"aaaa0002", "aaaa0005", "aaaa0001", "aaaa0003", "aaaa0004"}},
+	} {
+		if ids, next := listIDs(t, man, tc.opt); !reflect.DeepEqual(ids, tc.ids) || next != "" {
+			t.Errorf("list %+v: expected %v, got %v and cursor %q", tc.opt, tc.ids, ids, next)
+		}
+	}
+}
+
+func TestListPages(t *testing.T) {
+	man := listManager(t)
+	opt := ListOptions{Sort: SortTitle, Desc: true, Limit: 2, Archived: true}
+	var all []string
+	for i := 0; ; i++ {
+		ids, next := listIDs(t, man, opt)
+		all = append(all, ids...)
+		if next == "" {
+			break
+		}
+		if i > 3 {
+			t.Fatalf("expected at most 3 pages, got %v", all)
+		}
+		opt.Cursor = next
+	}
+	expected := []string{"aaaa0002", "aaaa0004", "aaaa0003", "aaaa0001", "aaaa0005"}
+	if !reflect.DeepEqual(all, expected) {
+		t.Errorf("expected pages to list %v, got %v", expected, all)
+	}
+}
+
+func TestListCursorSurvivesDeletion(t *testing.T) {
+	man := listManager(t)
+	opt := ListOptions{Sort: SortCreated, Limit: 2}
+	_, next := listIDs(t, man, opt)
+	if err := man.Delete("aaaa0001"); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	opt.Cursor = next
+	if ids, _ := listIDs(t, man, opt); !reflect.DeepEqual(ids, []string{"aaaa0004", "aaaa0005"}) {
+		t.Errorf("expected the page after the deleted note, got %v", ids)
+	}
+}
+
+func TestListKeepsOrder(t *testing.T) {
+	man := listManager(t)
+	opts := []ListOptions{
+		{Sort: SortCreated},
+		{Sort: SortUpdated, Desc: true},
+		{Sort: SortTitle, Archived: true},
+		{Sort: SortTitle, Desc: true},
+	}
+	check := func(step string) {
+		for _, opt := range opts {
+			var es []listEntry
+			for _, n := range man.AllNotes() {
+				if opt.Archived || !n.Archived {
+					es = append(es, opt.entry(n))
+				}
+			}
+			sort.Slice(es, func(i, j int) bool { return opt.less(es[i], es[j]) })
+			expected := []string{}
+			for _, e := range es {
+				expected = append(expected, e.id)
+			}
+			if ids, _ := listIDs(t, man, opt); !reflect.DeepEqual(ids, expected) {
+				t.Errorf("%s: list %+v: expected %v, got %v", step, opt, expected, ids)
+			}
+		}
+	}
+	check("listed")
+	editOrFatal(t, man, "aaaa0004", "# avocado #fruit")
+	check("retitled")
+	if err := man.Save(&Note{ID: "aaaa0002", Content: "apple", Pinned: false}); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	if err := man.Save(&Note{ID: "aaaa0005", Title: "Apricot", Content: "elderberry", Pinned: true}); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	check("pinned")
+	editOrFatal(t, man, "aaaa0006", "# fig #fruit")
+	if err := man.Delete("aaaa0001"); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	check("added and deleted")
+	if _, err := man.RenameTag("fruit", "food", false); err != nil {
+		t.Fatalf("rename: %v", err)
+	}
+	check("renamed")
+}
+
+func TestListInvalid(t *testing.T) {
+	man := listManager(t)
+	_, next := listIDs(t, man, ListOptions{Sort: SortCreated, Limit: 1})
+	for _, opt := range []ListOptions{
+		{Sort: "size"},
+		{Sort: SortCreated, Limit: -1},
+		{Sort: SortCreated, Cursor: "not a cursor"},
+		{Sort: SortTitle, Cursor: next},
+	} {
+		if _, _, err := man.List(opt); err == nil {
+			t.Errorf("list %+v: expected an error, got nothing", opt)
+		}
+	}
+}
@@ -0,0 +1,254 @@
+package notes
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const frontMatterDelim = "---"
+
+// exportMarkdown writes a zip archive of ns as Markdown files named after
+// their IDs, with front matter such as
+//
+//	---
+//	id: 01a14812a2d951bc9943bb62
+//	title: "Groceries"
+//	created: 2026-10-17T04:15:43Z
+//	updated: 2026-10-17T04:15:43Z
+//	pinned: true
+//	tags: [shopping, todo]
+//	---
+func exportMarkdown(w io.Writer, ns []*Note, tags [][]string) error {
+	zw := zip.NewWriter(w)
+	for i, n := range ns {
+		f, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     n.ID + ".md",
+			Method:   zip.Deflate,
+			Modified: n.UpdatedAt,
+		})
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(f, frontMatter(n, tags[i])+n.Content); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// frontMatter returns the YAML front matter of n with the given tags.
+func frontMatter(n *Note, tags []string) string {
+	var b strings.Builder
+	b.WriteString(frontMatterDelim + "\n")
+	fmt.Fprintf(&b, "id: %s\n", n.ID)
+	if n.Title != "" {
+		fmt.Fprintf(&b, "title: %s\n", strconv.Quote(n.Title))
+	}
+	fmt.Fprintf(&b, "created: %s\n", n.CreatedAt.Format(time.RFC3339Nano))
+	fmt.Fprintf(&b, "updated: %s\n", n.UpdatedAt.Format(time.RFC3339Nano))
+	if n.Pinned {
+		b.WriteString("pinned: true\n")
+	}
+	if n.Archived {
+		b.WriteString("archived: true\n")
+	}
+	if len(tags) > 0 {
+		quoted := make([]string, len(tags))
+		for i, t := range tags {
+			quoted[i] = strconv.Quote(t)
+		}
+		fmt.Fprintf(&b, "tags: [%s]\n", strings.Join(quoted, ", "))
+	}
+	b.WriteString(frontMatterDelim + "\n")
+	return b.String()
+}
+
+// importMarkdown reads the Markdown files of a zip archive. The archive is
+// read in memory, and the files may only be MaxImportSize large in total.
+func importMarkdown(r io.Reader) ([]importedNote, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+	var ns []importedNote
+	left := MaxImportSize
+	for _, f := range zr.File {
+		if ext := strings.ToLower(path.Ext(f.Name)); ext != ".md" && ext != ".markdown" {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		b, err := ioutil.ReadAll(io.LimitReader(rc, left+1))
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+		if left -= int64(len(b)); left < 0 {
+			return nil, fmt.Errorf("%s: %w", f.Name, ErrImportTooLarge)
+		}
+		in, err := parseMarkdownNote(string(b))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", f.Name, err)
+		}
+		ns = append(ns, in)
+	}
+	return ns, nil
+}
+
+// parseMarkdownNote parses a Markdown file with optional front matter.
+// Unknown keys are ignored.
+func parseMarkdownNote(s string) (importedNote, error) {
+	s = strings.TrimPrefix(s, "\ufeff")
+	in := importedNote{note: &Note{Content: s}}
+	if !strings.HasPrefix(s, frontMatterDelim+"\n") && !strings.HasPrefix(s, frontMatterDelim+"\r\n") {
+		return in, nil
+	}
+	lines := strings.SplitAfter(s, "\n")
+	end := -1
+	for i := 1; i < len(lines); i++ {
+		if strings.TrimRight(lines[i], "\r\n") == frontMatterDelim {
+			end = i
+			break
+		}
+	}
+	if end < 0 {
+		return in, nil
+	}
+	n := in.note
+	n.Content = strings.Join(lines[end+1:], "")
+	var list *[]string
+	for i, l := range lines[1:end] {
+		l = strings.TrimRight(l, "\r\n")
+		if strings.TrimSpace(l) == "" || strings.HasPrefix(strings.TrimSpace(l), "#") {
+			continue
+		}
+		if item := strings.TrimSpace(l); list != nil && strings.HasPrefix(item, "- ") {
+			v, err := yamlScalar(item[2:])
+			if err != nil {
+				return in, fmt.Errorf("front matter line %d: %v", i+2, err)
+			}
+			*list = append(*list, v)
+			continue
+		}
+		list = nil
+		colon := strings.Index(l, ":")
+		if colon < 0 {
+			return in, fmt.Errorf("front matter line %d: missing colon", i+2)
+		}
+		key, raw := strings.TrimSpace(l[:colon]), strings.TrimSpace(l[colon+1:])
+		if key == "tags" {
+			if raw == "" {
+				list = &in.tags
+				continue
+			}
+			vs, err := yamlList(raw)
+			if err != nil {
+				return in, fmt.Errorf("front matter line %d: %v", i+2, err)
+			}
+			in.tags = append(in.tags, vs...)
+			continue
+		}
+		v, err := yamlScalar(raw)
+		if err != nil {
+			return in, fmt.Errorf("front matter line %d: %v", i+2, err)
+		}
+		switch key {
+		case "id":
+			n.ID = v
+		case "title":
+			n.Title = v
+		case "created", "updated":
+			t, err := time.Parse(time.RFC3339Nano, v)
+			if err != nil {
+				return in, fmt.Errorf("front matter line %d: %v", i+2, err)
+			}
+			if key == "created" {
+				n.CreatedAt = t
+			} else {
+				n.UpdatedAt = t
+			}
+		case "pinned":
+			n.Pinned = v == "true"
+		case "archived":
+			n.Archived = v == "true"
+		}
+	}
+	return in, nil
+}
+
+// yamlScalar parses a plain, single or double quoted YAML scalar.
+func yamlScalar(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	switch {
+	case strings.HasPrefix(s, `"`):
+		return strconv.Unquote(s)
+	case strings.HasPrefix(s, "'"):
+		if len(s) < 2 || !strings.HasSuffix(s, "'") {
+			return "", fmt.Errorf("unterminated string %s", s)
+		}
+		return strings.Replace(s[1:len(s)-1], "''", "'", -1), nil
+	}
+	if i := strings.Index(s, " #"); i >= 0 {
+		s = strings.TrimSpace(s[:i])
+	}
+	return s, nil
+}
+
+// yamlList parses a YAML flow sequence of scalars, as [a, "b"], or a single
+// scalar.
+func yamlList(s string) ([]string, error) {
+	if !strings.HasPrefix(s, "[") {
+		v, err := yamlScalar(s)
+		return []string{v}, err
+	}
+	if !strings.HasSuffix(s, "]") {
+		return nil, fmt.Errorf("unterminated list %s", s)
+	}
+	var vs []string
+	for _, item := range splitFlow(s[1 : len(s)-1]) {
+		if strings.TrimSpace(item) == "" {
+			continue
+		}
+		v, err := yamlScalar(item)
+		if err != nil {
+			return nil, err
+		}
+		vs = append(vs, v)
+	}
+	return vs, nil
+}
+
+// splitFlow splits the items of a flow sequence at the commas outside of
+// quotes.
+func splitFlow(s string) []string {
+	var items []string
+	start := 0
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote == 0 && (c == '"' || c == '\''):
+			quote = c
+		case quote == 0 && c == ',':
+			items = append(items, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(items, s[start:])
+}
@@ -2,82 +2,459 @@ package notes
 
 import (
 	"crypto/sha512"
+	"errors"
 	"fmt"
 	"io"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 )
 
+// MinIDPrefix is the shortest abbreviated ID Resolve accepts.
+const MinIDPrefix = 4
+
+// ErrNotFound is returned when no note matches an ID.
+var ErrNotFound = errors.New("note not found")
+
+// AmbiguousIDError is returned when an abbreviated ID matches several notes.
+type AmbiguousIDError struct {
+	Prefix     string
+	Candidates []string
+}
+
+func (e *AmbiguousIDError) Error() string {
+	return fmt.Sprintf("ambiguous ID %q, candidates: %s", e.Prefix, strings.Join(e.Candidates, ", "))
+}
+
+// ErrNoteChanged is returned by SaveIf when the note changed since it was
+// read.
+var ErrNoteChanged = errors.New("note changed")
+
+// ErrDigestMismatch is returned when the digest of a note doesn't match its
+// content.
+var ErrDigestMismatch = errors.New("note digest mismatch")
+
+// Note is identified by an ID that stays the same when its content changes.
+// Digest is the sha512 of the content, kept up to date by NoteManager along
+// with the timestamps. Title is optional, see DisplayTitle.
 type Note struct {
-	ID      string
-	Content string
+	ID        string
+	Title     string `json:",omitempty"`
+	Content   string
+	Digest    string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	Pinned    bool `json:",omitempty"`
+	Archived  bool `json:",omitempty"`
 }
 
+// DisplayTitle returns the title of n or, if it has none, the first line of
+// its content, unless it is encrypted.
+func (n *Note) DisplayTitle() string {
+	if n.Title != "" {
+		return n.Title
+	}
+	if IsEncrypted(n.Content) {
+		return "Encrypted note"
+	}
+	return noteTitle(n.Content)
+}
+
+// NoteManager is safe for concurrent use. Readers share the lock, so they
+// don't block each other, and every note handed out is a copy.
 type NoteManager struct {
-	tags  map[string][]string
-	notes map[string]*Note
+	mu      sync.RWMutex
+	store   Store
+	tags    map[string][]string
+	notes   map[string]*Note
+	history map[string][]Revision
+	search  *searchIndex
+	links   *linkIndex
+	order   *listIndex
+	tok     *Tokenizer
+	now     func() time.Time
+	subs    map[*Subscription]bool
+	sync    *syncState
 }
 
+// NewNoteManager returns a NoteManager keeping its notes in memory only.
 func NewNoteManager() *NoteManager {
+	return newNoteManager(NewMemoryStore())
+}
+
+func newNoteManager(s Store) *NoteManager {
 	return &NoteManager{
-		tags:  make(map[string][]string),
-		notes: make(map[string]*Note),
+		store:   s,
+		tags:    make(map[string][]string),
+		notes:   make(map[string]*Note),
+		history: make(map[string][]Revision),
+		search:  newSearchIndex(),
+		links:   newLinkIndex(),
+		order:   newListIndex(),
+		tok:     DefaultTokenizer,
+		now:     time.Now,
+		subs:    make(map[*Subscription]bool),
+		sync:    newSyncState(),
+	}
+}
+
+// OpenNoteManager returns a NoteManager persisting its notes in s.
+// The notes already held by s are loaded and their tags indexed.
+func OpenNoteManager(s Store) (*NoteManager, error) {
+	ns, err := s.Load()
+	if err != nil {
+		return nil, err
+	}
+	history, err := s.LoadHistory()
+	if err != nil {
+		return nil, err
+	}
+	tombstones, err := s.LoadTombstones()
+	if err != nil {
+		return nil, err
 	}
+	man := newNoteManager(s)
+	for _, n := range ns {
+		// notes stored before digests were kept, when IDs were the digest,
+		// keep their ID and get a digest.
+		if n.Digest == "" {
+			n.Digest = digest(n.Content)
+		} else if err := n.Verify(); err != nil {
+			return nil, fmt.Errorf("note %s: %v", n.ID, err)
+		}
+		man.history[n.ID] = history[n.ID]
+		// notes saved before revisions were kept get a first revision.
+		r, missing := man.nextRevision(n, man.timestamp())
+		// notes saved before timestamps were kept get them from their
+		// revisions.
+		if n.CreatedAt.IsZero() {
+			first, last := r, r
+			if h := history[n.ID]; len(h) > 0 {
+				first = h[0]
+				if !missing {
+					last = h[len(h)-1]
+				}
+			}
+			n.CreatedAt, n.UpdatedAt = first.Time, last.Time
+		}
+		man.index(n)
+		man.sync.record(man, nil, n)
+		if missing {
+			if err := s.Put(n, &r); err != nil {
+				return nil, err
+			}
+			man.history[n.ID] = append(man.history[n.ID], r)
+		}
+	}
+	for id, vs := range tombstones {
+		if _, ok := man.notes[id]; !ok {
+			man.sync.bury(id, vs)
+		}
+	}
+	return man, nil
 }
+
+// NewNote returns a note with the given content and an ID from GenerateID.
 func NewNote(content string) (*Note, error) {
 	if content == "" {
 		return nil, fmt.Errorf("empty content")
 	}
 
+	result := &Note{
+		ID:      GenerateID(),
+		Content: content,
+		Digest:  digest(content),
+	}
+	return result, nil
+}
+
+// digest returns the hex encoded sha512 of content.
+func digest(content string) string {
 	hash := sha512.New()
 	io.WriteString(hash, content)
+	return fmt.Sprintf("%x", hash.Sum(nil))
+}
 
-	result := &Note{
-		fmt.Sprintf("%x", hash.Sum(nil)),
-		content,
+// Verify checks the digest of n against its content.
+func (n *Note) Verify() error {
+	if n.Digest != digest(n.Content) {
+		return ErrDigestMismatch
 	}
-	return result, nil
+	return nil
 }
 
-func parseTag(str string) []string {
-	if strings.ContainsRune(str, '#') {
-		str = strings.TrimSpace(str)
-		str = strings.Replace(str, ",", " ", -1)
-		str = strings.Replace(str, ".", " ", -1)
-		str = strings.Replace(str, ":", " ", -1)
-		str = strings.Replace(str, "\"", " ", -1)
-		str = strings.Replace(str, ";", " ", -1)
-		words := strings.Split(str, " ")
-		var a []string
-		for _, v := range words {
-			if strings.Index(v, "#") == 0 {
-				a = append(a, v[1:len(v)])
-			}
+// Save stores n, replacing any note with the same ID. The digest and
+// timestamps of n are filled in: CreatedAt is kept from the note replaced,
+// or set if zero, and UpdatedAt is set whenever the note changes.
+func (man *NoteManager) Save(n *Note) error {
+	c := *n
+	man.mu.Lock()
+	defer man.mu.Unlock()
+	if err := man.save(&c); err != nil {
+		return err
+	}
+	n.Digest, n.CreatedAt, n.UpdatedAt = c.Digest, c.CreatedAt, c.UpdatedAt
+	return nil
+}
+
+// SaveIf stores n like Save, if the note with the same ID is still old, as
+// returned by Find. Otherwise ErrNoteChanged is returned, or ErrNotFound if
+// the note was deleted.
+func (man *NoteManager) SaveIf(n, old *Note) error {
+	c := *n
+	man.mu.Lock()
+	defer man.mu.Unlock()
+	cur, ok := man.notes[n.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	if *cur != *old {
+		return ErrNoteChanged
+	}
+	if err := man.save(&c); err != nil {
+		return err
+	}
+	n.Digest, n.CreatedAt, n.UpdatedAt = c.Digest, c.CreatedAt, c.UpdatedAt
+	return nil
+}
+
+// timestamp returns the current time as stored in notes, in UTC and
+// without monotonic clock reading so it survives encoding.
+func (man *NoteManager) timestamp() time.Time {
+	return man.now().UTC().Round(0)
+}
+
+// save stores n along with a new revision if its content changed.
+func (man *NoteManager) save(n *Note) error {
+	return man.saveAll([]*Note{n})
+}
+
+// saveAll stores ns at once, along with a new revision for every note
+// whose content changed.
+func (man *NoteManager) saveAll(ns []*Note) error {
+	now := man.timestamp()
+	revs := make([]*Revision, len(ns))
+	for i, n := range ns {
+		n.Digest = digest(n.Content)
+		man.stamp(n, now)
+		if r, ok := man.nextRevision(n, now); ok {
+			revs[i] = &r
 		}
-		return a
 	}
+	var err error
+	if len(ns) == 1 {
+		err = man.store.Put(ns[0], revs[0])
+	} else {
+		err = man.store.PutAll(ns, revs)
+	}
+	if err != nil {
+		return err
+	}
+	man.saved(ns, revs)
 	return nil
 }
 
-func (man *NoteManager) Save(n *Note) error {
+// replaceAll stores ns at once, every note with a new revision replacing
+// all of its revisions.
+func (man *NoteManager) replaceAll(ns []*Note) error {
+	now := man.timestamp()
+	revs := make([]*Revision, len(ns))
+	for i, n := range ns {
+		n.Digest = digest(n.Content)
+		man.stamp(n, now)
+		revs[i] = &Revision{1, now, n.Content}
+	}
+	if err := man.store.ResetAll(ns, revs); err != nil {
+		return err
+	}
+	for _, n := range ns {
+		delete(man.history, n.ID)
+	}
+	man.saved(ns, revs)
+	return nil
+}
+
+// saved updates the index and revisions of the notes after ns and their
+// new revisions, if any, were stored.
+func (man *NoteManager) saved(ns []*Note, revs []*Revision) {
+	// the notes are sorted again at once rather than inserted one by one.
+	if len(ns) > 1 {
+		man.order.reset()
+	}
+	for i, n := range ns {
+		old, ok := man.notes[n.ID]
+		man.index(n)
+		if revs[i] != nil {
+			man.history[n.ID] = append(man.history[n.ID], *revs[i])
+		}
+		if !ok {
+			man.changed(nil, n)
+		} else if *old != *n {
+			man.changed(old, n)
+		}
+	}
+}
+
+// stamp sets the timestamps of n, about to replace the note with the same
+// ID, if any.
+func (man *NoteManager) stamp(n *Note, now time.Time) {
+	old, ok := man.notes[n.ID]
+	if !ok {
+		if n.CreatedAt.IsZero() {
+			n.CreatedAt = now
+		}
+		if n.UpdatedAt.IsZero() {
+			n.UpdatedAt = n.CreatedAt
+		}
+		return
+	}
+	n.CreatedAt = old.CreatedAt
+	n.UpdatedAt = old.UpdatedAt
+	if *n != *old {
+		n.UpdatedAt = now
+	}
+}
+
+// index adds n to the notes and its tags to the tag index. If n replaces a
+// note with the same ID, only the difference between their tags is applied.
+func (man *NoteManager) index(n *Note) {
+	before := map[string]bool{}
+	if old, ok := man.notes[n.ID]; ok {
+		before = man.tagSet(old.Content)
+		man.order.remove(old)
+	}
+	after := man.tagSet(n.Content)
 	man.notes[n.ID] = n
-	t := parseTag(n.Content)
-	for _, v := range t {
-		man.tags[v] = append(man.tags[v], n.ID)
+	man.order.add(n)
+	text := publicText(n.Content)
+	man.search.add(n.ID, text)
+	man.links.add(n.ID, n.DisplayTitle(), text)
+	for v := range before {
+		if !after[v] {
+			man.untag(v, n.ID)
+		}
+	}
+	for _, v := range man.tok.Tags(n.Content) {
+		if !before[v] {
+			man.tags[v] = append(man.tags[v], n.ID)
+			before[v] = true
+		}
+	}
+}
+
+// tagSet returns the distinct tags of content.
+func (man *NoteManager) tagSet(content string) map[string]bool {
+	set := make(map[string]bool)
+	for _, v := range man.tok.Tags(content) {
+		set[v] = true
+	}
+	return set
+}
+
+// Delete removes the note with the given ID and drops it from the tag index.
+// Tags left without any note are removed.
+func (man *NoteManager) Delete(id string) error {
+	man.mu.Lock()
+	defer man.mu.Unlock()
+	n, ok := man.notes[id]
+	if !ok {
+		return ErrNotFound
+	}
+	return man.delete(n)
+}
+
+// delete removes n, which must be stored.
+func (man *NoteManager) delete(n *Note) error {
+	if err := man.store.Delete(n.ID, man.versions(n.ID)); err != nil {
+		return err
 	}
+	man.changed(n, nil)
+	man.unindex(n)
+	delete(man.history, n.ID)
 	return nil
 }
 
+// changed records the change of a note from before to after, either of
+// which may be nil, in the change sequence and tells the subscribers. The
+// history of a deleted note must still be there.
+func (man *NoteManager) changed(before, after *Note) {
+	seq := man.sync.record(man, before, after)
+	man.publish(seq, before, after)
+}
+
+// unindex removes n from the notes and from the tag index.
+func (man *NoteManager) unindex(n *Note) {
+	delete(man.notes, n.ID)
+	man.order.remove(n)
+	man.search.remove(n.ID)
+	man.links.remove(n.ID)
+	for v := range man.tagSet(n.Content) {
+		man.untag(v, n.ID)
+	}
+}
+
+// untag removes id from the notes with tag, dropping the tag if no note is
+// left with it.
+func (man *NoteManager) untag(tag, id string) {
+	ids := man.tags[tag][:0]
+	for _, v := range man.tags[tag] {
+		if v != id {
+			ids = append(ids, v)
+		}
+	}
+	if len(ids) == 0 {
+		delete(man.tags, tag)
+	} else {
+		man.tags[tag] = ids
+	}
+}
+
+// SetTokenizer makes the manager find tags with t and rebuilds the tag
+// index accordingly.
+func (man *NoteManager) SetTokenizer(t *Tokenizer) {
+	man.mu.Lock()
+	defer man.mu.Unlock()
+	man.tok = t
+	man.tags = make(map[string][]string)
+	ids := make([]string, 0, len(man.notes))
+	for id := range man.notes {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		for v := range man.tagSet(man.notes[id].Content) {
+			man.tags[v] = append(man.tags[v], id)
+		}
+	}
+}
+
+// Close closes the subscriptions and the underlying store.
+func (man *NoteManager) Close() error {
+	man.mu.Lock()
+	defer man.mu.Unlock()
+	for s := range man.subs {
+		man.unsubscribe(s)
+	}
+	return man.store.Close()
+}
+
 func (man *NoteManager) AllNotes() []*Note {
+	man.mu.RLock()
+	defer man.mu.RUnlock()
 	v := make([]*Note, len(man.notes))
 	idx := 0
 	for _, value := range man.notes {
-		v[idx] = value
+		c := *value
+		v[idx] = &c
 		idx++
 	}
 	return v
 }
 
 func (man *NoteManager) AllTags() []string {
+	man.mu.RLock()
+	defer man.mu.RUnlock()
 	v := make([]string, len(man.tags))
 	idx := 0
 	for key, _ := range man.tags {
@@ -88,16 +465,64 @@ func (man *NoteManager) AllTags() []string {
 }
 
 func (man *NoteManager) NotesWith(tag string) ([]string, bool) {
-	value, ok := man.tags[tag]
-	return value, ok
+	man.mu.RLock()
+	defer man.mu.RUnlock()
+	value, ok := man.tags[man.tok.fold(tag)]
+	return append([]string(nil), value...), ok
 }
 
 func (man *NoteManager) Find(id string) (*Note, bool) {
-	ns := man.AllNotes()
-	for _, n := range ns {
-		if n.ID == id {
-			return n, true
+	man.mu.RLock()
+	defer man.mu.RUnlock()
+	n, ok := man.notes[id]
+	if !ok {
+		return nil, false
+	}
+	c := *n
+	return &c, true
+}
+
+// NotesWithDigest returns the IDs of the notes whose content has the given
+// digest, which is how duplicates are found.
+func (man *NoteManager) NotesWithDigest(d string) []string {
+	man.mu.RLock()
+	defer man.mu.RUnlock()
+	var ids []string
+	for id, n := range man.notes {
+		if n.Digest == d {
+			ids = append(ids, id)
 		}
 	}
-	return nil, false
+	sort.Strings(ids)
+	return ids
+}
+
+// Resolve returns the note whose ID is id or, git style, starts with id.
+// Abbreviations must be at least MinIDPrefix long; an abbreviation matching
+// several notes yields an *AmbiguousIDError.
+func (man *NoteManager) Resolve(id string) (*Note, error) {
+	man.mu.RLock()
+	defer man.mu.RUnlock()
+	if n, ok := man.notes[id]; ok {
+		c := *n
+		return &c, nil
+	}
+	if len(id) < MinIDPrefix {
+		return nil, ErrNotFound
+	}
+	var found []string
+	for k := range man.notes {
+		if strings.HasPrefix(k, id) {
+			found = append(found, k)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return nil, ErrNotFound
+	case 1:
+		c := *man.notes[found[0]]
+		return &c, nil
+	}
+	sort.Strings(found)
+	return nil, &AmbiguousIDError{id, found}
 }
@@ -4,7 +4,9 @@ import (
 	"crypto/sha512"
 	"fmt"
 	"io"
+	"sync"
 	"testing"
+	"time"
 )
 
 func newNoteOrFatal(t *testing.T, content string) *Note {
@@ -24,10 +26,35 @@ func TestNewNote(t *testing.T) {
 	if note.Content != content {
 		t.Errorf("expected content %q, got %q", content, note.Content)
 	}
-	if note.ID != sha512_checksum {
-		t.Errorf("expected checksum %q, got %q", sha512_checksum, note.ID)
+	if note.Digest != sha512_checksum {
+		t.Errorf("expected checksum %q, got %q", sha512_checksum, note.Digest)
+	}
+	if twin := newNoteOrFatal(t, content); twin.ID == note.ID {
+		t.Errorf("expected notes with the same content to get distinct IDs, got %q twice", note.ID)
+	}
+}
+
+func TestSaveKeepsIDAndUpdatesDigest(t *testing.T) {
+	man := NewNoteManager()
+	note := newNoteOrFatal(t, "first draft")
+	twin := newNoteOrFatal(t, "first draft")
+	man.Save(note)
+	man.Save(twin)
+	if ids := man.NotesWithDigest(note.Digest); len(ids) != 2 {
+		t.Errorf("expected 2 notes with the same digest, got %v", ids)
 	}
 
+	man.Save(&Note{ID: note.ID, Content: "second draft", Digest: "stale"})
+	n, ok := man.Find(note.ID)
+	if !ok {
+		t.Fatalf("expected to find the edited note")
+	}
+	if err := n.Verify(); err != nil {
+		t.Errorf("expected the digest to follow the content, got %v", err)
+	}
+	if ids := man.NotesWithDigest(note.Digest); len(ids) != 1 || ids[0] != twin.ID {
+		t.Errorf("expected only %q with the old digest, got %v", twin.ID, ids)
+	}
 }
 
 func TestSave(t *testing.T) {
@@ -44,20 +71,35 @@ func TestSave(t *testing.T) {
 	}
 }
 
-func TestParse(t *testing.T) {
-	content := "test a new note with a single hashtag #test, without linebreaks etc."
-	tags := parseTag(content)
-	if len(tags) != 1 {
-		t.Errorf("expected 1 tag, got %v", len(tags))
+func TestSaveIf(t *testing.T) {
+	man := NewNoteManager()
+	editOrFatal(t, man, "aaaa0001", "draft")
+	old, _ := man.Find("aaaa0001")
+	n := *old
+	n.Title = "Draft"
+	// the note is edited concurrently.
+	editOrFatal(t, man, "aaaa0001", "rewritten")
+	if err := man.SaveIf(&n, old); err != ErrNoteChanged {
+		t.Errorf("expected ErrNoteChanged, got %v", err)
+	}
+	if cur, _ := man.Find("aaaa0001"); cur.Content != "rewritten" || cur.Title != "" {
+		t.Errorf("expected the concurrent edit to be kept, got %+v", cur)
+	}
+	old, _ = man.Find("aaaa0001")
+	n = *old
+	n.Title = "Draft"
+	if err := man.SaveIf(&n, old); err != nil {
+		t.Errorf("expected the note to be saved, got %v", err)
 	}
-	if tags[0] != "test" {
-		t.Errorf("expected parsed tag %q, got %q", "test", tags[0])
+	man.Delete("aaaa0001")
+	if err := man.SaveIf(&n, old); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound, got %v", err)
 	}
 }
 
 func getNoteWithTagAndSaveInManager(t *testing.T) (*NoteManager, *Note, string, []string) {
 	content := "test a new note with a single hashtag #test, without linebreaks etc."
-	tags := parseTag(content)
+	tags := DefaultTokenizer.Tags(content)
 	note := newNoteOrFatal(t, content)
 	man := NewNoteManager()
 	man.Save(note)
@@ -97,3 +139,238 @@ func TestGetNoteById(t *testing.T) {
 		t.Errorf("expected to find a matching, got nothing")
 	}
 }
+
+// TestConcurrentAccess is meant to be run with the race detector:
+//
+//	go test -race
+func TestConcurrentAccess(t *testing.T) {
+	man := NewNoteManager()
+	seed := newNoteOrFatal(t, "seed note #shared")
+	man.Save(seed)
+
+	const workers, rounds = 8, 200
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(4)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				n, _ := NewNote(fmt.Sprintf("note %d-%d #shared #w%d", w, i, w))
+				if err := man.Save(n); err != nil {
+					t.Errorf("save: %v", err)
+				}
+			}
+		}(w)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				if _, ok := man.Find(seed.ID); !ok {
+					t.Errorf("expected to find the seed note")
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				man.AllTags()
+				man.AllNotes()
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				if ids, ok := man.NotesWith("shared"); !ok || len(ids) == 0 {
+					t.Errorf("expected notes tagged %q", "shared")
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if all := man.AllNotes(); len(all) != workers*rounds+1 {
+		t.Errorf("expected %v notes, got %v", workers*rounds+1, len(all))
+	}
+	if tags := man.AllTags(); len(tags) != workers+1 {
+		t.Errorf("expected %v tags, got %v", workers+1, len(tags))
+	}
+}
+
+func TestResolve(t *testing.T) {
+	man := NewNoteManager()
+	for _, id := range []string{"3fa9c1aa", "3fa9c1bb", "77aa0000"} {
+		man.Save(&Note{ID: id, Content: "note " + id})
+	}
+	tests := []struct {
+		id    string
+		want  string
+		err   error
+		cands []string
+	}{
+		{id: "3fa9c1aa", want: "3fa9c1aa"},
+		{id: "3fa9c1b", want: "3fa9c1bb"},
+		{id: "77aa", want: "77aa0000"},
+		{id: "77a", err: ErrNotFound},
+		{id: "ffff", err: ErrNotFound},
+		{id: "3fa9", cands: []string{"3fa9c1aa", "3fa9c1bb"}},
+	}
+	for _, tt := range tests {
+		n, err := man.Resolve(tt.id)
+		if tt.cands != nil {
+			amb, ok := err.(*AmbiguousIDError)
+			if !ok {
+				t.Errorf("%q: expected an ambiguous ID error, got %v", tt.id, err)
+				continue
+			}
+			if fmt.Sprint(amb.Candidates) != fmt.Sprint(tt.cands) {
+				t.Errorf("%q: expected candidates %v, got %v", tt.id, tt.cands, amb.Candidates)
+			}
+			continue
+		}
+		if err != tt.err {
+			t.Errorf("%q: expected error %v, got %v", tt.id, tt.err, err)
+			continue
+		}
+		if err == nil && n.ID != tt.want {
+			t.Errorf("%q: expected %q, got %q", tt.id, tt.want, n.ID)
+		}
+	}
+}
+
+func TestDelete(t *testing.T) {
+	man := NewNoteManager()
+	keep := newNoteOrFatal(t, "keep me #shared")
+	gone := newNoteOrFatal(t, "delete me #shared #only")
+	man.Save(keep)
+	man.Save(gone)
+
+	if err := man.Delete(gone.ID); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if _, ok := man.Find(gone.ID); ok {
+		t.Errorf("expected deleted note to be gone")
+	}
+	if ids, ok := man.NotesWith("shared"); !ok || len(ids) != 1 || ids[0] != keep.ID {
+		t.Errorf("expected only %q tagged %q, got %v", keep.ID, "shared", ids)
+	}
+	if _, ok := man.NotesWith("only"); ok {
+		t.Errorf("expected tag %q to be dropped", "only")
+	}
+	if tags := man.AllTags(); len(tags) != 1 {
+		t.Errorf("expected 1 tag, got %v", tags)
+	}
+	if err := man.Delete(gone.ID); err != ErrNotFound {
+		t.Errorf("expected %v deleting twice, got %v", ErrNotFound, err)
+	}
+}
+
+func TestUpdateReindexesTags(t *testing.T) {
+	man := NewNoteManager()
+	other := newNoteOrFatal(t, "another #keep note")
+	man.Save(other)
+	note := newNoteOrFatal(t, "first draft #keep #old")
+	man.Save(note)
+
+	edits := []struct {
+		content string
+		tags    map[string][]string
+	}{
+		{
+			// unchanged tags, edited text.
+			"second draft #keep #old",
+			map[string][]string{"keep": {other.ID, note.ID}, "old": {note.ID}},
+		},
+		{
+			// tag added, tag removed, and a repeated tag.
+			"third draft #keep #new #new",
+			map[string][]string{"keep": {other.ID, note.ID}, "new": {note.ID}},
+		},
+		{
+			// same content saved again.
+			"third draft #keep #new #new",
+			map[string][]string{"keep": {other.ID, note.ID}, "new": {note.ID}},
+		},
+		{
+			// every tag of the note removed.
+			"final draft without tags",
+			map[string][]string{"keep": {other.ID}},
+		},
+		{
+			// a tag brought back.
+			"back to #old",
+			map[string][]string{"keep": {other.ID}, "old": {note.ID}},
+		},
+	}
+	for i, e := range edits {
+		if err := man.Save(&Note{ID: note.ID, Content: e.content}); err != nil {
+			t.Fatalf("edit %d: save: %v", i, err)
+		}
+		if tags := man.AllTags(); len(tags) != len(e.tags) {
+			t.Errorf("edit %d: expected tags %v, got %v", i, e.tags, tags)
+		}
+		for tag, want := range e.tags {
+			got, _ := man.NotesWith(tag)
+			if fmt.Sprint(got) != fmt.Sprint(want) {
+				t.Errorf("edit %d: expected %q to map to %v, got %v", i, tag, want, got)
+			}
+		}
+	}
+}
+
+func TestTimestamps(t *testing.T) {
+	man := NewNoteManager()
+	man.now = tickingClock()
+	note := newNoteOrFatal(t, "buy milk")
+	if err := man.Save(note); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	created := note.CreatedAt
+	if created.IsZero() || !note.UpdatedAt.Equal(created) {
+		t.Fatalf("expected new note to be created and updated at the same time, got %v and %v", created, note.UpdatedAt)
+	}
+
+	editOrFatal(t, man, note.ID, "buy milk")
+	n, _ := man.Find(note.ID)
+	if !n.UpdatedAt.Equal(created) {
+		t.Errorf("expected an unchanged note to keep its update time, got %v", n.UpdatedAt)
+	}
+
+	edit := &Note{ID: note.ID, Content: "buy milk", Pinned: true, CreatedAt: time.Unix(0, 0)}
+	if err := man.Save(edit); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	if !edit.CreatedAt.Equal(created) {
+		t.Errorf("expected creation time %v to be kept, got %v", created, edit.CreatedAt)
+	}
+	if !edit.UpdatedAt.After(created) {
+		t.Errorf("expected pinning to update the note, got update time %v", edit.UpdatedAt)
+	}
+}
+
+func TestDisplayTitle(t *testing.T) {
+	for _, tc := range []struct {
+		note  Note
+		title string
+	}{
+		{Note{Content: "# Groceries\nbuy milk"}, "Groceries"},
+		{Note{Title: "Shopping", Content: "# Groceries\nbuy milk"}, "Shopping"},
+		{Note{}, ""},
+	} {
+		if got := tc.note.DisplayTitle(); got != tc.title {
+			t.Errorf("expected title %q for %+v, got %q", tc.title, tc.note, got)
+		}
+	}
+}
+
+func TestTitleLinks(t *testing.T) {
+	man := NewNoteManager()
+	target := &Note{ID: "aaaa0001", Title: "Groceries", Content: "buy milk"}
+	source := &Note{ID: "aaaa0002", Content: "see [[groceries]]"}
+	for _, n := range []*Note{target, source} {
+		if err := man.Save(n); err != nil {
+			t.Fatalf("save: %v", err)
+		}
+	}
+	if ids, _ := man.Backlinks(target.ID); len(ids) != 1 || ids[0] != source.ID {
+		t.Errorf("expected %q to link to the note titled Groceries, got %v", source.ID, ids)
+	}
+}
@@ -0,0 +1,84 @@
+package notes
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// LogNotifier logs reminders, to the standard logger if Logger is nil.
+type LogNotifier struct {
+	Logger *log.Logger
+}
+
+// Notify logs r.
+func (n LogNotifier) Notify(r Reminder) error {
+	msg := fmt.Sprintf("reminder: note %s %q due %s", r.Note, r.Title, r.Due.Format(dateTimeLayout))
+	if n.Logger == nil {
+		log.Println(msg)
+	} else {
+		n.Logger.Println(msg)
+	}
+	return nil
+}
+
+// WebhookNotifier posts reminders as JSON to URL, with http.DefaultClient
+// if Client is nil.
+type WebhookNotifier struct {
+	URL    string
+	Client *http.Client
+}
+
+// Notify posts r to n.URL.
+func (n WebhookNotifier) Notify(r Reminder) error {
+	body, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	c := n.Client
+	if c == nil {
+		c = http.DefaultClient
+	}
+	res, err := c.Post(n.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook %s: %s", n.URL, res.Status)
+	}
+	return nil
+}
+
+// CommandNotifier runs a command for every reminder, with the reminder as
+// JSON on its standard input and in the NOTE_ID, NOTE_TITLE, NOTE_DUE and
+// NOTE_REMIND environment variables.
+type CommandNotifier struct {
+	Path string
+	Args []string
+}
+
+// Notify runs the command for r.
+func (n CommandNotifier) Notify(r Reminder) error {
+	body, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(n.Path, n.Args...)
+	cmd.Stdin = bytes.NewReader(body)
+	cmd.Env = append(os.Environ(),
+		"NOTE_ID="+r.Note,
+		"NOTE_TITLE="+r.Title,
+		"NOTE_DUE="+r.Due.Format(time.RFC3339),
+		"NOTE_REMIND="+r.At.Format(time.RFC3339),
+	)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s: %v: %s", n.Path, err, bytes.TrimSpace(out))
+	}
+	return nil
+}
@@ -0,0 +1,258 @@
+package notes
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// QueryError is returned for a malformed tag query. Pos is the byte offset
+// in the query where the problem was found.
+type QueryError struct {
+	Query string
+	Pos   int
+	Msg   string
+}
+
+func (e *QueryError) Error() string {
+	return fmt.Sprintf("tag query %q: %s at offset %d", e.Query, e.Msg, e.Pos)
+}
+
+// tagQuery is a parsed tag query, evaluated to the set of matching note IDs.
+type tagQuery interface {
+	eval(man *NoteManager) map[string]bool
+}
+
+type (
+	tagTerm     string
+	subtreeTerm string
+	notQuery    struct{ q tagQuery }
+	andQuery    []tagQuery
+	orQuery     []tagQuery
+)
+
+func (t tagTerm) eval(man *NoteManager) map[string]bool {
+	set := make(map[string]bool)
+	for _, id := range man.tags[man.tok.fold(string(t))] {
+		set[id] = true
+	}
+	return set
+}
+
+func (t subtreeTerm) eval(man *NoteManager) map[string]bool {
+	return man.subtree(string(t))
+}
+
+func (q notQuery) eval(man *NoteManager) map[string]bool {
+	exclude := q.q.eval(man)
+	set := make(map[string]bool)
+	for id := range man.notes {
+		if !exclude[id] {
+			set[id] = true
+		}
+	}
+	return set
+}
+
+func (q andQuery) eval(man *NoteManager) map[string]bool {
+	set := q[0].eval(man)
+	for _, sub := range q[1:] {
+		other := sub.eval(man)
+		for id := range set {
+			if !other[id] {
+				delete(set, id)
+			}
+		}
+	}
+	return set
+}
+
+func (q orQuery) eval(man *NoteManager) map[string]bool {
+	set := make(map[string]bool)
+	for _, sub := range q {
+		for id := range sub.eval(man) {
+			set[id] = true
+		}
+	}
+	return set
+}
+
+// queryToken is a lexical element of a tag query.
+type queryToken struct {
+	text string
+	pos  int
+}
+
+// lexQuery splits q into parentheses and words separated by white space.
+func lexQuery(q string) []queryToken {
+	var ts []queryToken
+	start := -1
+	flush := func(i int) {
+		if start >= 0 {
+			ts = append(ts, queryToken{q[start:i], start})
+			start = -1
+		}
+	}
+	for i, r := range q {
+		switch {
+		case r == '(' || r == ')':
+			flush(i)
+			ts = append(ts, queryToken{string(r), i})
+		case r == ' ' || r == '\t' || r == '\n' || r == '\r':
+			flush(i)
+		case start < 0:
+			start = i
+		}
+	}
+	flush(len(q))
+	return ts
+}
+
+// queryParser is a recursive descent parser of the grammar
+//
+//	or   = and { "OR" and }
+//	and  = not { ["AND"] not }
+//	not  = "NOT" not | term
+//	term = "#" tag | "#" tag "/*" | "(" or ")"
+//
+// Operators are case insensitive, AND may be left out. A tag followed by /*
+// also matches its descendants.
+type queryParser struct {
+	q      string
+	tokens []queryToken
+	next   int
+}
+
+func (p *queryParser) peek() (queryToken, bool) {
+	if p.next >= len(p.tokens) {
+		return queryToken{pos: len(p.q)}, false
+	}
+	return p.tokens[p.next], true
+}
+
+func (p *queryParser) errorf(pos int, format string, args ...interface{}) error {
+	return &QueryError{p.q, pos, fmt.Sprintf(format, args...)}
+}
+
+// isOp reports whether t is the operator op.
+func isOp(t queryToken, op string) bool {
+	return strings.EqualFold(t.text, op)
+}
+
+func (p *queryParser) parseOr() (tagQuery, error) {
+	var or orQuery
+	for {
+		q, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		or = append(or, q)
+		if t, ok := p.peek(); !ok || !isOp(t, "OR") {
+			break
+		}
+		p.next++
+	}
+	if len(or) == 1 {
+		return or[0], nil
+	}
+	return or, nil
+}
+
+func (p *queryParser) parseAnd() (tagQuery, error) {
+	var and andQuery
+	for {
+		q, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		and = append(and, q)
+		t, ok := p.peek()
+		if !ok || t.text == ")" || isOp(t, "OR") {
+			break
+		}
+		if isOp(t, "AND") {
+			p.next++
+		}
+	}
+	if len(and) == 1 {
+		return and[0], nil
+	}
+	return and, nil
+}
+
+func (p *queryParser) parseNot() (tagQuery, error) {
+	t, ok := p.peek()
+	if ok && isOp(t, "NOT") {
+		p.next++
+		q, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return notQuery{q}, nil
+	}
+	return p.parseTerm()
+}
+
+func (p *queryParser) parseTerm() (tagQuery, error) {
+	t, ok := p.peek()
+	if !ok {
+		return nil, p.errorf(t.pos, "unexpected end of query")
+	}
+	p.next++
+	switch {
+	case t.text == "(":
+		q, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		end, ok := p.peek()
+		if !ok || end.text != ")" {
+			return nil, p.errorf(end.pos, "missing closing parenthesis for the one at offset %d", t.pos)
+		}
+		p.next++
+		return q, nil
+	case strings.HasPrefix(t.text, "#") && strings.HasSuffix(t.text, TagSeparator+"*") && len(t.text) > 3:
+		return subtreeTerm(t.text[1 : len(t.text)-2]), nil
+	case strings.HasPrefix(t.text, "#") && len(t.text) > 1:
+		return tagTerm(t.text[1:]), nil
+	}
+	return nil, p.errorf(t.pos, "unexpected %q, expected a #tag", t.text)
+}
+
+// parseTagQuery parses a boolean query over tags.
+func parseTagQuery(q string) (tagQuery, error) {
+	p := &queryParser{q: q, tokens: lexQuery(q)}
+	res, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t, ok := p.peek(); ok {
+		return nil, p.errorf(t.pos, "unexpected %q", t.text)
+	}
+	return res, nil
+}
+
+// Query returns the notes matching the boolean tag query q, ordered by ID.
+// Tags are combined with AND, OR, NOT and parentheses, as in
+//
+//	#todo AND #work AND NOT #done
+//	(#bug OR #incident) AND #prod
+//	#project/* AND NOT #project/legacy/*
+//
+// A malformed query yields a *QueryError.
+func (man *NoteManager) Query(q string) ([]*Note, error) {
+	tq, err := parseTagQuery(q)
+	if err != nil {
+		return nil, err
+	}
+	man.mu.RLock()
+	defer man.mu.RUnlock()
+	set := tq.eval(man)
+	v := make([]*Note, 0, len(set))
+	for id := range set {
+		c := *man.notes[id]
+		v = append(v, &c)
+	}
+	sort.Slice(v, func(i, j int) bool { return v[i].ID < v[j].ID })
+	return v, nil
+}
@@ -0,0 +1,77 @@
+package notes
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestQuery(t *testing.T) {
+	man := NewNoteManager()
+	for id, c := range map[string]string{
+		"a": "#todo #work",
+		"b": "#todo #work #done",
+		"c": "#todo #home",
+		"d": "#bug #prod",
+		"e": "#incident #prod",
+		"f": "#bug #staging",
+	} {
+		man.Save(&Note{ID: id, Content: c})
+	}
+
+	tests := []struct {
+		q    string
+		want string
+	}{
+		{"#todo", "a,b,c"},
+		{"#todo AND #work AND NOT #done", "a"},
+		{"#todo #work not #done", "a"},
+		{"(#bug OR #incident) AND #prod", "d,e"},
+		{"#bug OR #incident AND #prod", "d,e,f"},
+		{"NOT #todo", "d,e,f"},
+		{"NOT NOT #home", "c"},
+		{"((#home))", "c"},
+		{"#missing", ""},
+		{"#todo AND #missing", ""},
+	}
+	for _, tt := range tests {
+		ns, err := man.Query(tt.q)
+		if err != nil {
+			t.Errorf("%q: %v", tt.q, err)
+			continue
+		}
+		var ids []string
+		for _, n := range ns {
+			ids = append(ids, n.ID)
+		}
+		if got := strings.Join(ids, ","); got != tt.want {
+			t.Errorf("%q: expected %v, got %v", tt.q, tt.want, got)
+		}
+	}
+}
+
+func TestQueryMalformed(t *testing.T) {
+	man := NewNoteManager()
+	tests := []struct {
+		q   string
+		pos int
+	}{
+		{"", 0},
+		{"#todo AND", 9},
+		{"(#bug OR #incident", 18},
+		{"#bug)", 4},
+		{"todo", 0},
+		{"#todo AND OR #work", 10},
+		{"#", 0},
+	}
+	for _, tt := range tests {
+		_, err := man.Query(tt.q)
+		qe, ok := err.(*QueryError)
+		if !ok {
+			t.Errorf("%q: expected a *QueryError, got %v", tt.q, err)
+			continue
+		}
+		if qe.Pos != tt.pos {
+			t.Errorf("%q: expected error at %d, got %v", tt.q, tt.pos, qe)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package notes
+
+import (
+	"errors"
+	"sort"
+	"strings"
+)
+
+// ErrInvalidTag is returned when a tag wouldn't be recognised as one in the
+// content of a note.
+var ErrInvalidTag = errors.New("invalid tag")
+
+// TagChange describes how renaming or merging tags changes a note.
+type TagChange struct {
+	ID     string
+	Before string
+	After  string
+}
+
+// RenameTag renames the tag from to to in the content of every note using
+// it, descendants of a hierarchical tag included, so renaming #project to
+// #work turns #project/api into #work/api. The notes and the tag index are
+// updated at once. If dryRun is set nothing is changed. It returns the
+// changes, ordered by note ID.
+func (man *NoteManager) RenameTag(from, to string, dryRun bool) ([]TagChange, error) {
+	return man.MergeTags([]string{from}, to, dryRun)
+}
+
+// MergeTags renames every tag of from to to, as RenameTag does.
+func (man *NoteManager) MergeTags(from []string, to string, dryRun bool) ([]TagChange, error) {
+	man.mu.Lock()
+	defer man.mu.Unlock()
+
+	if tags := man.tok.Tags("#" + to); len(tags) != 1 || tags[0] != man.tok.fold(to) {
+		return nil, ErrInvalidTag
+	}
+	to = man.tok.fold(to)
+	folded := make([]string, len(from))
+	for i, f := range from {
+		folded[i] = man.tok.fold(f)
+	}
+	from = folded
+	affected := make(map[string]bool)
+	for t, ids := range man.tags {
+		for _, f := range from {
+			if isUnder(t, f) {
+				for _, id := range ids {
+					affected[id] = true
+				}
+			}
+		}
+	}
+	ids := make([]string, 0, len(affected))
+	for id := range affected {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var changes []TagChange
+	var ns []*Note
+	for _, id := range ids {
+		n := *man.notes[id]
+		n.Content = man.retag(n.Content, from, to)
+		if n.Content == man.notes[id].Content {
+			continue
+		}
+		changes = append(changes, TagChange{id, man.notes[id].Content, n.Content})
+		ns = append(ns, &n)
+	}
+	if dryRun || len(ns) == 0 {
+		return changes, nil
+	}
+	if err := man.saveAll(ns); err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// retag rewrites the hashtags of content under any of from to be under to.
+func (man *NoteManager) retag(content string, from []string, to string) string {
+	var b strings.Builder
+	at := 0
+	for _, s := range man.tok.spans(content) {
+		for _, f := range from {
+			if isUnder(s.Tag, f) {
+				b.WriteString(content[at:s.Start])
+				b.WriteString("#" + to + s.Tag[len(f):])
+				at = s.End
+				break
+			}
+		}
+	}
+	b.WriteString(content[at:])
+	return b.String()
+}
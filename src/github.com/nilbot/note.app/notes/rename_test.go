@@ -0,0 +1,87 @@
+package notes
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestRenameTag(t *testing.T) {
+	man := NewNoteManager()
+	man.Save(&Note{ID: "a", Content: "crash on login #bugs #prod"})
+	man.Save(&Note{ID: "b", Content: "#bugs/ui misaligned, see #bugsy"})
+	man.Save(&Note{ID: "c", Content: "nothing to see #prod"})
+
+	changes, err := man.RenameTag("bugs", "bug", true)
+	if err != nil {
+		t.Fatalf("dry run: %v", err)
+	}
+	want := []TagChange{
+		{"a", "crash on login #bugs #prod", "crash on login #bug #prod"},
+		{"b", "#bugs/ui misaligned, see #bugsy", "#bug/ui misaligned, see #bugsy"},
+	}
+	if fmt.Sprint(changes) != fmt.Sprint(want) {
+		t.Errorf("expected %v, got %v", want, changes)
+	}
+	if _, ok := man.NotesWith("bug"); ok {
+		t.Errorf("expected a dry run to leave the index alone")
+	}
+	if n, _ := man.Find("a"); n.Content != want[0].Before {
+		t.Errorf("expected a dry run to leave notes alone, got %q", n.Content)
+	}
+
+	if _, err := man.RenameTag("bugs", "bug", false); err != nil {
+		t.Fatalf("rename: %v", err)
+	}
+	if n, _ := man.Find("b"); n.Content != want[1].After {
+		t.Errorf("expected %q, got %q", want[1].After, n.Content)
+	}
+	if _, ok := man.NotesWith("bugs"); ok {
+		t.Errorf("expected tag %q to be gone", "bugs")
+	}
+	if ids, _ := man.NotesWith("bug/ui"); len(ids) != 1 {
+		t.Errorf("expected the descendant to be renamed, got %v", ids)
+	}
+	if ids, _ := man.NotesWith("bugsy"); len(ids) != 1 {
+		t.Errorf("expected %q to be left alone, got %v", "bugsy", ids)
+	}
+	if revs, _ := man.Revisions("a"); len(revs) != 2 {
+		t.Errorf("expected the rename to add a revision, got %v", revs)
+	}
+}
+
+func TestMergeTags(t *testing.T) {
+	dir := tempDirOrFatal(t)
+	defer os.RemoveAll(dir)
+
+	man := openWALManagerOrFatal(t, dir, WALOptions{})
+	man.Save(&Note{ID: "a", Content: "#bugs"})
+	man.Save(&Note{ID: "b", Content: "#bugz #bug"})
+	man.Save(&Note{ID: "c", Content: "#Bug"})
+	changes, err := man.MergeTags([]string{"bugs", "bugz"}, "bug", false)
+	if err != nil {
+		t.Fatalf("merge: %v", err)
+	}
+	if len(changes) != 2 {
+		t.Errorf("expected 2 changes, got %v", changes)
+	}
+	man.Close()
+
+	man = openWALManagerOrFatal(t, dir, WALOptions{})
+	defer man.Close()
+	if ids, _ := man.NotesWith("bug"); fmt.Sprint(ids) != "[a b]" && fmt.Sprint(ids) != "[b a]" {
+		t.Errorf("expected a and b tagged %q after reload, got %v", "bug", ids)
+	}
+	if tags := man.AllTags(); len(tags) != 2 {
+		t.Errorf("expected tags bug and Bug, got %v", tags)
+	}
+}
+
+func TestRenameTagInvalid(t *testing.T) {
+	man := NewNoteManager()
+	for _, to := range []string{"", "two words", "42", "#hash", "trailing/"} {
+		if _, err := man.RenameTag("a", to, true); err != ErrInvalidTag {
+			t.Errorf("%q: expected %v, got %v", to, ErrInvalidTag, err)
+		}
+	}
+}
@@ -0,0 +1,441 @@
+package notes
+
+import (
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// RenderOptions gives the URLs a rendered note links to.
+type RenderOptions struct {
+	// TagURL returns the URL of the notes tagged with tag.
+	TagURL func(tag string) string
+	// NoteURL returns the URL of the note with the given ID.
+	NoteURL func(id string) string
+	// AttachmentURL returns the URL of the attachment of the note with
+	// the given ID with the given hash. Without it links to attachments
+	// are left out.
+	AttachmentURL func(id, hash string) string
+}
+
+// Render renders the Markdown content of the note with the given ID to
+// HTML. Hashtags link to opt.TagURL and [[links]] to opt.NoteURL of the note
+// they resolve to, broken links are marked as such, and attachments to
+// opt.AttachmentURL. Raw HTML in the content
+// is escaped and shown as text, and links to URLs are only kept for the
+// http, https and mailto schemes, so the result is safe to embed in a page.
+//
+// Headings, paragraphs, block quotes, lists with - [ ] tasks, fenced code
+// blocks, rules, emphasis, code spans, links and images are supported.
+func (man *NoteManager) Render(id string, opt RenderOptions) (string, error) {
+	man.mu.RLock()
+	defer man.mu.RUnlock()
+	n, ok := man.notes[id]
+	if !ok {
+		return "", ErrNotFound
+	}
+	r := &renderer{id: id, opt: opt, resolve: man.resolveLink, tags: make(map[int]tagSpan)}
+	for _, s := range man.tok.spans(n.Content) {
+		r.tags[s.Start] = s
+	}
+	r.blocks(n.Content)
+	return r.b.String(), nil
+}
+
+// line is a piece of the content of a note with its offset in the content,
+// so hashtags found by the tokenizer can be recognised while rendering.
+type line struct {
+	text string
+	off  int
+}
+
+func (l line) from(i int) line {
+	return line{l.text[i:], l.off + i}
+}
+
+func (l line) to(i int) line {
+	return line{l.text[:i], l.off}
+}
+
+// trim trims the white space around l.
+func (l line) trim() line {
+	s := strings.TrimLeft(l.text, " \t")
+	l = l.from(len(l.text) - len(s))
+	return l.to(len(strings.TrimRight(l.text, " \t\r")))
+}
+
+type renderer struct {
+	id      string
+	opt     RenderOptions
+	resolve func(target string) string
+	tags    map[int]tagSpan
+	b       strings.Builder
+}
+
+// blocks renders content block by block.
+func (r *renderer) blocks(content string) {
+	var lines []line
+	off := 0
+	for _, s := range strings.Split(content, "\n") {
+		lines = append(lines, line{s, off})
+		off += len(s) + 1
+	}
+	for i := 0; i < len(lines); {
+		i = r.block(lines, i)
+	}
+}
+
+// block renders the block starting at lines[i] and returns the index of
+// the line after it.
+func (r *renderer) block(lines []line, i int) int {
+	l := lines[i].trim()
+	switch {
+	case l.text == "":
+		return i + 1
+	case isFence(l.text):
+		return r.code(lines, i)
+	case isRule(l.text):
+		r.b.WriteString("<hr>\n")
+		return i + 1
+	case headingLevel(l.text) > 0:
+		level := headingLevel(l.text)
+		tag := "h" + strconv.Itoa(level)
+		r.b.WriteString("<" + tag + ">")
+		r.inline(l.from(level).trim())
+		r.b.WriteString("</" + tag + ">\n")
+		return i + 1
+	case strings.HasPrefix(l.text, ">"):
+		r.b.WriteString("<blockquote><p>")
+		j := i
+		for ; j < len(lines); j++ {
+			q := lines[j].trim()
+			if !strings.HasPrefix(q.text, ">") {
+				break
+			}
+			if j > i {
+				r.b.WriteString("\n")
+			}
+			r.inline(q.from(1).trim())
+		}
+		r.b.WriteString("</p></blockquote>\n")
+		return j
+	}
+	if ordered, _, ok := listItem(l); ok {
+		return r.list(lines, i, ordered)
+	}
+	r.b.WriteString("<p>")
+	j := i
+	for ; j < len(lines) && (j == i || !startsBlock(lines[j].trim())); j++ {
+		if j > i {
+			r.b.WriteString("\n")
+		}
+		r.inline(lines[j].trim())
+	}
+	r.b.WriteString("</p>\n")
+	return j
+}
+
+// code renders the fenced code block opened at lines[i].
+func (r *renderer) code(lines []line, i int) int {
+	open := lines[i].trim().text
+	lang := strings.TrimSpace(strings.TrimLeft(open, open[:1]))
+	if lang != "" {
+		r.b.WriteString(`<pre><code class="language-` + html.EscapeString(lang) + `">`)
+	} else {
+		r.b.WriteString("<pre><code>")
+	}
+	j := i + 1
+	for ; j < len(lines) && !isFence(lines[j].text); j++ {
+		r.b.WriteString(html.EscapeString(strings.TrimRight(lines[j].text, "\r")) + "\n")
+	}
+	r.b.WriteString("</code></pre>\n")
+	return j + 1
+}
+
+// list renders the list whose first item is lines[i]. Indented lines
+// continue the item above them.
+func (r *renderer) list(lines []line, i int, ordered bool) int {
+	tag := "ul"
+	if ordered {
+		tag = "ol"
+	}
+	r.b.WriteString("<" + tag + ">\n")
+	j := i
+	for j < len(lines) {
+		o, item, ok := listItem(lines[j].trim())
+		if !ok || o != ordered {
+			break
+		}
+		if done, ok := taskBox(item.text); ok {
+			checked := ""
+			if done {
+				checked = " checked"
+			}
+			r.b.WriteString(`<li class="task"><input type="checkbox" disabled` + checked + "> ")
+			item = item.from(3).trim()
+		} else {
+			r.b.WriteString("<li>")
+		}
+		r.inline(item)
+		for j++; j < len(lines); j++ {
+			next := lines[j]
+			if !strings.HasPrefix(next.text, " ") && !strings.HasPrefix(next.text, "\t") || next.trim().text == "" {
+				break
+			}
+			if _, _, ok := listItem(next.trim()); ok {
+				break
+			}
+			r.b.WriteString("\n")
+			r.inline(next.trim())
+		}
+		r.b.WriteString("</li>\n")
+	}
+	r.b.WriteString("</" + tag + ">\n")
+	return j
+}
+
+// startsBlock reports whether l ends a paragraph by starting another block.
+func startsBlock(l line) bool {
+	if l.text == "" || isFence(l.text) || isRule(l.text) || headingLevel(l.text) > 0 || strings.HasPrefix(l.text, ">") {
+		return true
+	}
+	_, _, ok := listItem(l)
+	return ok
+}
+
+// headingLevel returns the level of the heading l is, 0 if it isn't one.
+// Unlike hashtags, the # marks of a heading are followed by a space.
+func headingLevel(s string) int {
+	n := 0
+	for n < len(s) && s[n] == '#' {
+		n++
+	}
+	if n == 0 || n > 6 || n < len(s) && s[n] != ' ' && s[n] != '\t' {
+		return 0
+	}
+	return n
+}
+
+// isRule reports whether s is a horizontal rule, three or more -, * or _.
+func isRule(s string) bool {
+	s = strings.Replace(s, " ", "", -1)
+	return len(s) >= 3 && strings.Count(s, s[:1]) == len(s) && strings.Contains("-*_", s[:1])
+}
+
+// listItem reports whether l is an item of a list, ordered or not, and
+// returns its content.
+func listItem(l line) (ordered bool, item line, ok bool) {
+	s := l.text
+	if len(s) >= 2 && strings.Contains("-*+", s[:1]) && (s[1] == ' ' || s[1] == '\t') {
+		return false, l.from(2).trim(), true
+	}
+	n := 0
+	for n < len(s) && s[n] >= '0' && s[n] <= '9' {
+		n++
+	}
+	if n > 0 && n+1 < len(s) && (s[n] == '.' || s[n] == ')') && s[n+1] == ' ' {
+		return true, l.from(n + 2).trim(), true
+	}
+	return false, l, false
+}
+
+// taskBox reports whether s starts with the [ ] or [x] box of a task, and
+// whether it is checked.
+func taskBox(s string) (done bool, ok bool) {
+	if len(s) < 3 || s[0] != '[' || s[2] != ']' || len(s) > 3 && s[3] != ' ' {
+		return false, false
+	}
+	switch s[1] {
+	case ' ':
+		return false, true
+	case 'x', 'X':
+		return true, true
+	}
+	return false, false
+}
+
+// wikiLink matches a [[link]] at the start of a string, see linkPattern.
+var wikiLink = regexp.MustCompile(`^\[\[([^\[\]\n|]+)(?:\|([^\[\]\n]*))?\]\]`)
+
+// inline renders the text of a block.
+func (r *renderer) inline(l line) {
+	s := l.text
+	for i := 0; i < len(s); {
+		if span, ok := r.tags[l.off+i]; ok && span.End <= l.off+len(s) {
+			end := span.End - l.off
+			r.b.WriteString(`<a class="tag" href="` + html.EscapeString(r.opt.TagURL(span.Tag)) + `">`)
+			r.b.WriteString(html.EscapeString(s[i:end]) + "</a>")
+			i = end
+			continue
+		}
+		if end := r.special(l, i); end > i {
+			i = end
+			continue
+		}
+		c, size := utf8.DecodeRuneInString(s[i:])
+		if c == '\\' && i+1 < len(s) && strings.IndexByte("\\`*_[]()#!>-", s[i+1]) >= 0 {
+			i++
+			c, size = rune(s[i]), 1
+		}
+		r.b.WriteString(html.EscapeString(string(c)))
+		i += size
+	}
+}
+
+// special renders the inline element starting at l.text[i], if any, and
+// returns the offset after it, or i if there is none.
+func (r *renderer) special(l line, i int) int {
+	s := l.text
+	switch s[i] {
+	case '`':
+		if end := codeSpanEnd(s, i); end > i {
+			n := 0
+			for s[i+n] == '`' {
+				n++
+			}
+			r.b.WriteString("<code>" + html.EscapeString(strings.TrimSpace(s[i+n:end-n])) + "</code>")
+			return end
+		}
+	case '[':
+		if m := wikiLink.FindStringSubmatch(s[i:]); m != nil {
+			label := strings.TrimSpace(m[2])
+			if label == "" {
+				label = strings.TrimSpace(m[1])
+			}
+			if id := r.resolve(strings.TrimSpace(m[1])); id != "" {
+				r.b.WriteString(`<a class="note-link" href="` + html.EscapeString(r.opt.NoteURL(id)) + `">`)
+				r.b.WriteString(html.EscapeString(label) + "</a>")
+			} else {
+				r.b.WriteString(`<span class="broken-link">` + html.EscapeString(label) + "</span>")
+			}
+			return i + len(m[0])
+		}
+		if text, url, end := mdLink(s, i); end > i {
+			if url = r.attachmentURL(url); safeURL(url) {
+				r.b.WriteString(`<a href="` + html.EscapeString(url) + `">` + html.EscapeString(text) + "</a>")
+			} else {
+				r.b.WriteString(html.EscapeString(text))
+			}
+			return end
+		}
+	case '!':
+		if text, url, end := mdLink(s, i+1); end > i+1 {
+			if url = r.attachmentURL(url); safeURL(url) {
+				r.b.WriteString(`<img src="` + html.EscapeString(url) + `" alt="` + html.EscapeString(text) + `">`)
+			} else {
+				r.b.WriteString(html.EscapeString(text))
+			}
+			return end
+		}
+	case '*', '_':
+		if end, n := emphasis(s, i); end > i {
+			tag := "em"
+			if n == 2 {
+				tag = "strong"
+			}
+			r.b.WriteString("<" + tag + ">")
+			r.inline(l.to(end - n).from(i + n))
+			r.b.WriteString("</" + tag + ">")
+			return end
+		}
+	default:
+		prev, _ := utf8.DecodeLastRuneInString(s[:i])
+		if isWordRune(prev) || !isURL(s[i:]) {
+			return i
+		}
+		end := strings.IndexAny(s[i:], " \t")
+		if end < 0 {
+			end = len(s) - i
+		}
+		end = i + len(strings.TrimRight(s[i:i+end], ".,;:!?)"))
+		url := s[i:end]
+		href := url
+		if strings.HasPrefix(url, "www.") {
+			href = "http://" + url
+		}
+		if safeURL(href) {
+			r.b.WriteString(`<a href="` + html.EscapeString(href) + `">` + html.EscapeString(url) + "</a>")
+		} else {
+			r.b.WriteString(html.EscapeString(url))
+		}
+		return end
+	}
+	return i
+}
+
+// attachmentURL returns the URL of the attachment url refers to as
+// attachment:hash, or url if it refers to none.
+func (r *renderer) attachmentURL(url string) string {
+	if hash := strings.TrimPrefix(url, "attachment:"); hash != url && r.opt.AttachmentURL != nil {
+		return r.opt.AttachmentURL(r.id, hash)
+	}
+	return url
+}
+
+// mdLink parses a [text](url) link at s[i] and returns the offset after it,
+// or i if there is none.
+func mdLink(s string, i int) (text, url string, end int) {
+	if i >= len(s) || s[i] != '[' {
+		return "", "", i
+	}
+	close := strings.IndexByte(s[i:], ']')
+	if close < 0 || i+close+1 >= len(s) || s[i+close+1] != '(' {
+		return "", "", i
+	}
+	close += i
+	paren := strings.IndexByte(s[close:], ')')
+	if paren < 0 {
+		return "", "", i
+	}
+	paren += close
+	return s[i+1 : close], strings.TrimSpace(s[close+2 : paren]), paren + 1
+}
+
+// emphasis parses emphasis delimited by one or two * or _ at s[i] and
+// returns the offset after it and the number of delimiters, or i if there
+// is none. _ only delimits emphasis outside of words, as in snake_case.
+func emphasis(s string, i int) (end, n int) {
+	c := s[i]
+	n = 1
+	if i+1 < len(s) && s[i+1] == c {
+		n = 2
+	}
+	delim := s[i : i+n]
+	if c == '_' {
+		if prev, _ := utf8.DecodeLastRuneInString(s[:i]); isWordRune(prev) {
+			return i, 0
+		}
+	}
+	if i+n >= len(s) || s[i+n] == ' ' {
+		return i, 0
+	}
+	for at := i + n; at < len(s); {
+		j := strings.Index(s[at:], delim)
+		if j < 0 {
+			return i, 0
+		}
+		j += at
+		next, _ := utf8.DecodeRuneInString(s[j+n:])
+		if j > i+n && s[j-1] != ' ' && (c != '_' || !isWordRune(next)) && (j+n >= len(s) || s[j+n] != c) {
+			return j + n, n
+		}
+		at = j + 1
+	}
+	return i, 0
+}
+
+// safeURL reports whether url is relative or uses a scheme that can't run
+// scripts.
+func safeURL(url string) bool {
+	i := strings.IndexAny(url, ":/?#")
+	if i < 0 || url[i] != ':' {
+		return true
+	}
+	switch strings.ToLower(url[:i]) {
+	case "http", "https", "mailto":
+		return true
+	}
+	return false
+}
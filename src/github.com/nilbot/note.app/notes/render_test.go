@@ -0,0 +1,98 @@
+package notes
+
+import (
+	"strings"
+	"testing"
+)
+
+var testRenderOptions = RenderOptions{
+	TagURL:  func(tag string) string { return "/tag/" + tag },
+	NoteURL: func(id string) string { return "/note/" + id },
+}
+
+func renderOrFatal(t *testing.T, man *NoteManager, content string) string {
+	n := &Note{ID: "render01", Content: content}
+	if err := man.Save(n); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	res, err := man.Render(n.ID, testRenderOptions)
+	if err != nil {
+		t.Fatalf("render: %v", err)
+	}
+	return res
+}
+
+func TestRender(t *testing.T) {
+	man := NewNoteManager()
+	if err := man.Save(&Note{ID: "aaaa0001", Content: "# Groceries\nmilk"}); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	for _, tc := range []struct {
+		content, html string
+	}{
+		{"# Title #tag\ntext", "<h1>Title <a class=\"tag\" href=\"/tag/tag\">#tag</a></h1>\n<p>text</p>\n"},
+		{"one\ntwo\n\nthree", "<p>one\ntwo</p>\n<p>three</p>\n"},
+		{"*em* **strong** snake_case_name", "<p><em>em</em> <strong>strong</strong> snake_case_name</p>\n"},
+		{"`#include` and #c", "<p><code>#include</code> and <a class=\"tag\" href=\"/tag/c\">#c</a></p>\n"},
+		{"```go\nx := 1 // #not\n```", "<pre><code class=\"language-go\">x := 1 // #not\n</code></pre>\n"},
+		{"- one\n- [x] done\n- [ ] todo", "<ul>\n<li>one</li>\n<li class=\"task\"><input type=\"checkbox\" disabled checked> done</li>\n<li class=\"task\"><input type=\"checkbox\" disabled> todo</li>\n</ul>\n"},
+		{"1. first\n2. second", "<ol>\n<li>first</li>\n<li>second</li>\n</ol>\n"},
+		{"> quoted\n> more", "<blockquote><p>quoted\nmore</p></blockquote>\n"},
+		{"---", "<hr>\n"},
+		{"see [[Groceries]] and [[nothing|that]]", "<p>see <a class=\"note-link\" href=\"/note/aaaa0001\">Groceries</a> and <span class=\"broken-link\">that</span></p>\n"},
+		{"[docs](https://example.com/a?b=1&c=2) ![pic](/blob/1)", "<p><a href=\"https://example.com/a?b=1&amp;c=2\">docs</a> <img src=\"/blob/1\" alt=\"pic\"></p>\n"},
+		{"go to https://example.com/#anchor.", "<p>go to <a href=\"https://example.com/#anchor\">https://example.com/#anchor</a>.</p>\n"},
+		{`\*not em\*`, "<p>*not em*</p>\n"},
+	} {
+		if got := renderOrFatal(t, man, tc.content); got != tc.html {
+			t.Errorf("render %q:\nexpected %q\ngot      %q", tc.content, tc.html, got)
+		}
+	}
+}
+
+func TestRenderSanitizes(t *testing.T) {
+	man := NewNoteManager()
+	for _, content := range []string{
+		"<script>alert(1)</script>",
+		"<img src=x onerror=alert(1)>",
+		"[click](javascript:alert(1))",
+		"[click](JavaScript:alert(1))",
+		"![x](data:text/html,<script>alert(1)</script>)",
+		"javascript://%0aalert(1)",
+		"[[<b>x</b>]]",
+		"#tag\"><script>",
+	} {
+		got := renderOrFatal(t, man, content)
+		for _, bad := range []string{"<script", "<img src=\"x", "<b>", `href="javascript`, `href="JavaScript`, `src="data`, "\"><"} {
+			if strings.Contains(got, bad) {
+				t.Errorf("render %q: expected no %q, got %q", content, bad, got)
+			}
+		}
+	}
+}
+
+func TestRenderNotFound(t *testing.T) {
+	man := NewNoteManager()
+	if _, err := man.Render("missing", testRenderOptions); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestRenderAttachments(t *testing.T) {
+	man := NewNoteManager()
+	hash := digest("PNG...")
+	content := "![shot](attachment:" + hash + ") [log](attachment:" + hash + ")"
+	if got := renderOrFatal(t, man, content); strings.Contains(got, "attachment:") || strings.Contains(got, "<img") {
+		t.Errorf("expected attachments to be left out without AttachmentURL, got %q", got)
+	}
+	opt := testRenderOptions
+	opt.AttachmentURL = func(id, hash string) string { return "/note/" + id + "/attachments/" + hash[:8] }
+	got, err := man.Render("render01", opt)
+	if err != nil {
+		t.Fatalf("render: %v", err)
+	}
+	expected := `<p><img src="/note/render01/attachments/` + hash[:8] + `" alt="shot"> <a href="/note/render01/attachments/` + hash[:8] + `">log</a></p>`
+	if strings.TrimSpace(got) != expected {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+}
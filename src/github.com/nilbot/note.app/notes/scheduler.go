@@ -0,0 +1,120 @@
+package notes
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// Clock tells the time and waits for it, so a Scheduler can be tested
+// without waiting.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time                         { return time.Now() }
+func (systemClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// SystemClock is the Clock of the system.
+var SystemClock Clock = systemClock{}
+
+// Notifier is told about reminders when they fire.
+type Notifier interface {
+	Notify(r Reminder) error
+}
+
+// NotifierFunc adapts a function to a Notifier.
+type NotifierFunc func(r Reminder) error
+
+// Notify calls f(r).
+func (f NotifierFunc) Notify(r Reminder) error {
+	return f(r)
+}
+
+// Scheduler fires the reminders of the notes of a NoteManager to a
+// Notifier when they are due. Reminders due while the scheduler isn't
+// running are not fired.
+type Scheduler struct {
+	// Location is the time zone of the times written in notes, time.Local
+	// by default.
+	Location *time.Location
+	// Poll is how long the scheduler sleeps at most when no reminder is
+	// due, one minute by default or if not positive. It wakes up whenever
+	// notes change.
+	Poll time.Duration
+
+	man      *NoteManager
+	notifier Notifier
+	clock    Clock
+	stop     chan struct{}
+	done     sync.WaitGroup
+}
+
+// NewScheduler returns a scheduler of the reminders of man, to be started
+// with Start.
+func NewScheduler(man *NoteManager, n Notifier, c Clock) *Scheduler {
+	return &Scheduler{
+		Location: time.Local,
+		Poll:     time.Minute,
+		man:      man,
+		notifier: n,
+		clock:    c,
+	}
+}
+
+// Start starts firing reminders due from now on.
+func (s *Scheduler) Start() {
+	s.stop = make(chan struct{})
+	since := s.clock.Now()
+	// a single buffered event is enough to know notes changed.
+	sub := s.man.Subscribe(1, DropNewest)
+	s.done.Add(1)
+	go func() {
+		defer s.done.Done()
+		defer sub.Close()
+		s.run(since, sub.Events())
+	}()
+}
+
+// Stop stops the scheduler and waits for it to return.
+func (s *Scheduler) Stop() {
+	close(s.stop)
+	s.done.Wait()
+}
+
+// run fires the reminders due after since until stopped. It sleeps until
+// the next reminder, for Poll at most, or until notes change.
+func (s *Scheduler) run(since time.Time, changes <-chan Event) {
+	for {
+		now := s.clock.Now()
+		wait := s.Poll
+		if wait <= 0 {
+			wait = time.Minute
+		}
+		for _, r := range s.man.Reminders(since, s.Location) {
+			if r.At.After(now) {
+				if d := r.At.Sub(now); d < wait {
+					wait = d
+				}
+				break
+			}
+			if err := s.notifier.Notify(r); err != nil {
+				log.Printf("reminder for note %s: %v", r.Note, err)
+			}
+		}
+		since = now
+		select {
+		case <-s.clock.After(wait):
+		case _, ok := <-changes:
+			if !ok {
+				// the manager was closed.
+				return
+			}
+		case <-s.stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package notes
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a Clock whose time only moves on Advance.
+type fakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []fakeWaiter
+}
+
+type fakeWaiter struct {
+	at time.Time
+	c  chan time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	w := fakeWaiter{c.now.Add(d), make(chan time.Time, 1)}
+	if d <= 0 {
+		w.c <- c.now
+	} else {
+		c.waiters = append(c.waiters, w)
+	}
+	return w.c
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	var left []fakeWaiter
+	for _, w := range c.waiters {
+		if w.at.After(c.now) {
+			left = append(left, w)
+		} else {
+			w.c <- c.now
+		}
+	}
+	c.waiters = left
+}
+
+// waitForWaiters waits until something waits on c, so advancing it wakes
+// it up.
+func (c *fakeClock) waitForWaiters(t *testing.T) {
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		c.mu.Lock()
+		n := len(c.waiters)
+		c.mu.Unlock()
+		if n > 0 {
+			return
+		}
+	}
+	t.Fatalf("expected the scheduler to wait on the clock")
+}
+
+func expectReminder(t *testing.T, fired <-chan Reminder, id string) {
+	select {
+	case r := <-fired:
+		if r.Note != id {
+			t.Errorf("expected a reminder for %q, got %+v", id, r)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected a reminder for %q, got nothing", id)
+	}
+}
+
+func TestScheduler(t *testing.T) {
+	man := NewNoteManager()
+	if err := man.Save(&Note{ID: "aaaa0001", Content: "#todo due:2026-11-01 remind:09:00"}); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	clock := &fakeClock{now: time.Date(2026, 11, 1, 8, 0, 0, 0, time.UTC)}
+	fired := make(chan Reminder, 10)
+	s := NewScheduler(man, NotifierFunc(func(r Reminder) error {
+		fired <- r
+		return nil
+	}), clock)
+	s.Location = time.UTC
+	s.Poll = 24 * time.Hour
+	s.Start()
+	defer s.Stop()
+
+	clock.waitForWaiters(t)
+	clock.Advance(59 * time.Minute)
+	clock.Advance(time.Minute)
+	expectReminder(t, fired, "aaaa0001")
+
+	// a reminder added meanwhile fires too.
+	clock.waitForWaiters(t)
+	if err := man.Save(&Note{ID: "aaaa0002", Content: "due:2026-11-01 remind:10:00"}); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	clock.Advance(24 * time.Hour)
+	expectReminder(t, fired, "aaaa0002")
+	select {
+	case r := <-fired:
+		t.Errorf("expected reminders to fire once, got %+v", r)
+	default:
+	}
+}
+
+func TestSchedulerZeroPoll(t *testing.T) {
+	man := NewNoteManager()
+	clock := &fakeClock{now: time.Date(2026, 11, 1, 8, 0, 0, 0, time.UTC)}
+	s := NewScheduler(man, NotifierFunc(func(Reminder) error { return nil }), clock)
+	s.Poll = 0
+	s.Start()
+	defer s.Stop()
+
+	clock.waitForWaiters(t)
+	clock.mu.Lock()
+	defer clock.mu.Unlock()
+	if d := clock.waiters[0].at.Sub(clock.now); d != time.Minute {
+		t.Errorf("expected the scheduler to sleep for a minute, got %v", d)
+	}
+}
+
+func TestWebhookNotifier(t *testing.T) {
+	got := make(chan Reminder, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var rem Reminder
+		if err := json.NewDecoder(r.Body).Decode(&rem); err != nil {
+			t.Errorf("decode: %v", err)
+		}
+		got <- rem
+	}))
+	defer ts.Close()
+	r := Reminder{Note: "aaaa0001", Title: "Taxes", At: time.Date(2026, 11, 1, 9, 0, 0, 0, time.UTC)}
+	if err := (WebhookNotifier{URL: ts.URL}).Notify(r); err != nil {
+		t.Fatalf("notify: %v", err)
+	}
+	if rem := <-got; !rem.At.Equal(r.At) || rem.Note != r.Note || rem.Title != r.Title {
+		t.Errorf("expected %+v to be posted, got %+v", r, rem)
+	}
+}
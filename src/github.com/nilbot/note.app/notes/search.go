@@ -0,0 +1,325 @@
+package notes
+
+import (
+	"fmt"
+	"html"
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// BM25 parameters.
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// snippetContext is how many bytes of content are kept before the first
+// match of a snippet, snippetLength how many in total.
+const (
+	snippetContext = 40
+	snippetLength  = 160
+)
+
+// SearchResult is a note matching a search query.
+// Snippet is an HTML escaped excerpt of the content with the matches
+// wrapped in <mark> elements.
+type SearchResult struct {
+	Note    *Note
+	Score   float64
+	Snippet string
+}
+
+// token is a word of a note, lower cased, with its byte offsets in the
+// content.
+type token struct {
+	term       string
+	start, end int
+}
+
+// tokenize splits content into words made of letters and digits.
+func tokenize(content string) []token {
+	var ts []token
+	start := -1
+	for i, r := range content {
+		word := unicode.IsLetter(r) || unicode.IsDigit(r)
+		if word && start < 0 {
+			start = i
+		}
+		if !word && start >= 0 {
+			ts = append(ts, token{strings.ToLower(content[start:i]), start, i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		ts = append(ts, token{strings.ToLower(content[start:]), start, len(content)})
+	}
+	return ts
+}
+
+// searchIndex is an inverted index from terms to the positions of the
+// term in every note containing it.
+type searchIndex struct {
+	postings map[string]map[string][]int
+	docs     map[string][]token
+	totalLen int
+}
+
+func newSearchIndex() *searchIndex {
+	return &searchIndex{
+		postings: make(map[string]map[string][]int),
+		docs:     make(map[string][]token),
+	}
+}
+
+// add indexes content for id, replacing what was indexed for id before.
+func (idx *searchIndex) add(id, content string) {
+	idx.remove(id)
+	ts := tokenize(content)
+	for pos, t := range ts {
+		p, ok := idx.postings[t.term]
+		if !ok {
+			p = make(map[string][]int)
+			idx.postings[t.term] = p
+		}
+		p[id] = append(p[id], pos)
+	}
+	idx.docs[id] = ts
+	idx.totalLen += len(ts)
+}
+
+// remove drops id from the index.
+func (idx *searchIndex) remove(id string) {
+	ts, ok := idx.docs[id]
+	if !ok {
+		return
+	}
+	for _, t := range ts {
+		if p := idx.postings[t.term]; p != nil {
+			delete(p, id)
+			if len(p) == 0 {
+				delete(idx.postings, t.term)
+			}
+		}
+	}
+	idx.totalLen -= len(ts)
+	delete(idx.docs, id)
+}
+
+// clause is a part of a query: a word, a prefix or a phrase of words.
+type clause struct {
+	terms  []string
+	prefix bool
+}
+
+// parseQuery splits q into clauses. Words are separated by spaces, a word
+// ending in * matches every word starting with it and words between double
+// quotes must appear next to each other.
+func parseQuery(q string) ([]clause, error) {
+	var cs []clause
+	for q = strings.TrimSpace(q); q != ""; q = strings.TrimSpace(q) {
+		if q[0] == '"' {
+			end := strings.IndexByte(q[1:], '"')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated phrase in query")
+			}
+			var terms []string
+			for _, t := range tokenize(q[1 : end+1]) {
+				terms = append(terms, t.term)
+			}
+			if len(terms) > 0 {
+				cs = append(cs, clause{terms: terms})
+			}
+			q = q[end+2:]
+			continue
+		}
+		word := q
+		if i := strings.IndexAny(q, " \t\n\""); i >= 0 {
+			word = q[:i]
+		}
+		q = q[len(word):]
+		ts := tokenize(word)
+		for _, t := range ts {
+			cs = append(cs, clause{terms: []string{t.term}})
+		}
+		if len(ts) > 0 && strings.HasSuffix(word, "*") {
+			cs[len(cs)-1].prefix = true
+		}
+	}
+	if len(cs) == 0 {
+		return nil, fmt.Errorf("empty query")
+	}
+	return cs, nil
+}
+
+// match is a span of tokens of a note matching a clause.
+type match struct {
+	first, last int
+}
+
+// matches returns, for every note matching c, the token spans it matches
+// grouped by matched term, so that each group can be scored on its own.
+func (idx *searchIndex) matches(c clause) map[string]map[string][]match {
+	res := make(map[string]map[string][]match)
+	add := func(term, id string, m match) {
+		if res[term] == nil {
+			res[term] = make(map[string][]match)
+		}
+		res[term][id] = append(res[term][id], m)
+	}
+	switch {
+	case c.prefix:
+		for term, p := range idx.postings {
+			if !strings.HasPrefix(term, c.terms[0]) {
+				continue
+			}
+			for id, pos := range p {
+				for _, i := range pos {
+					add(term, id, match{i, i})
+				}
+			}
+		}
+	case len(c.terms) == 1:
+		for id, pos := range idx.postings[c.terms[0]] {
+			for _, i := range pos {
+				add(c.terms[0], id, match{i, i})
+			}
+		}
+	default:
+		phrase := strings.Join(c.terms, " ")
+		for id, pos := range idx.postings[c.terms[0]] {
+			ts := idx.docs[id]
+		next:
+			for _, i := range pos {
+				if i+len(c.terms) > len(ts) {
+					continue
+				}
+				for k, term := range c.terms {
+					if ts[i+k].term != term {
+						continue next
+					}
+				}
+				add(phrase, id, match{i, i + len(c.terms) - 1})
+			}
+		}
+	}
+	return res
+}
+
+// search returns the IDs of the notes matching every clause with their
+// BM25 score and matched spans.
+func (idx *searchIndex) search(cs []clause) (map[string]float64, map[string][]match) {
+	n := float64(len(idx.docs))
+	avg := 0.0
+	if n > 0 {
+		avg = float64(idx.totalLen) / n
+	}
+	var scores map[string]float64
+	spans := make(map[string][]match)
+	for _, c := range cs {
+		found := make(map[string]float64)
+		for _, docs := range idx.matches(c) {
+			df := float64(len(docs))
+			idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+			for id, ms := range docs {
+				tf := float64(len(ms))
+				dl := float64(len(idx.docs[id]))
+				found[id] += idf * tf * (bm25K1 + 1) / (tf + bm25K1*(1-bm25B+bm25B*dl/avg))
+				spans[id] = append(spans[id], ms...)
+			}
+		}
+		if scores == nil {
+			scores = found
+			continue
+		}
+		for id, s := range scores {
+			if f, ok := found[id]; ok {
+				scores[id] = s + f
+			} else {
+				delete(scores, id)
+			}
+		}
+	}
+	return scores, spans
+}
+
+// snippet returns an HTML escaped excerpt of content around the first of
+// the given token spans, with every span in the excerpt highlighted.
+func snippet(content string, ts []token, spans []match) string {
+	sort.Slice(spans, func(i, j int) bool { return spans[i].first < spans[j].first })
+	start := 0
+	if len(spans) > 0 {
+		start = ts[spans[0].first].start - snippetContext
+	}
+	if start < 0 {
+		start = 0
+	}
+	for start > 0 && !utf8.RuneStart(content[start]) {
+		start--
+	}
+	end := start + snippetLength
+	if end >= len(content) {
+		end = len(content)
+	}
+	for end < len(content) && !utf8.RuneStart(content[end]) {
+		end++
+	}
+
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString("…")
+	}
+	at := start
+	for _, m := range spans {
+		s, e := ts[m.first].start, ts[m.last].end
+		if s < at || e > end {
+			continue
+		}
+		b.WriteString(html.EscapeString(content[at:s]))
+		b.WriteString("<mark>")
+		b.WriteString(html.EscapeString(content[s:e]))
+		b.WriteString("</mark>")
+		at = e
+	}
+	b.WriteString(html.EscapeString(content[at:end]))
+	if end < len(content) {
+		b.WriteString("…")
+	}
+	return b.String()
+}
+
+// Search returns the notes matching every word, phrase and prefix of the
+// query q, best matches first. Notes are ranked by their BM25 score.
+//
+// Examples of queries:
+//
+//	milk bread        notes containing both words
+//	"buy milk"        notes containing the phrase
+//	gro*              notes containing a word starting with gro
+func (man *NoteManager) Search(q string) ([]SearchResult, error) {
+	cs, err := parseQuery(q)
+	if err != nil {
+		return nil, err
+	}
+	man.mu.RLock()
+	defer man.mu.RUnlock()
+	scores, spans := man.search.search(cs)
+	res := make([]SearchResult, 0, len(scores))
+	for id, score := range scores {
+		c := *man.notes[id]
+		res = append(res, SearchResult{
+			Note:    &c,
+			Score:   score,
+			Snippet: snippet(publicText(c.Content), man.search.docs[id], spans[id]),
+		})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Score != res[j].Score {
+			return res[i].Score > res[j].Score
+		}
+		return res[i].Note.ID < res[j].Note.ID
+	})
+	return res, nil
+}
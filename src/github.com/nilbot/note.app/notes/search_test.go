@@ -0,0 +1,97 @@
+package notes
+
+import (
+	"strings"
+	"testing"
+)
+
+func searchIDs(t *testing.T, man *NoteManager, q string) []string {
+	res, err := man.Search(q)
+	if err != nil {
+		t.Fatalf("search %q: %v", q, err)
+	}
+	var ids []string
+	for _, r := range res {
+		ids = append(ids, r.Note.ID)
+	}
+	return ids
+}
+
+func TestSearch(t *testing.T) {
+	man := NewNoteManager()
+	for id, c := range map[string]string{
+		"milk":     "Buy milk and bread #groceries",
+		"moremilk": "milk milk milk, we are out of milk",
+		"phrase":   "remember to buy fresh milk",
+		"garden":   "Grow tomatoes in the garden #gardening",
+		"unicode":  "Café au lait, später",
+	} {
+		man.Save(&Note{ID: id, Content: c})
+	}
+
+	tests := []struct {
+		q    string
+		want []string
+	}{
+		{"milk", []string{"moremilk", "milk", "phrase"}},
+		{"MILK bread", []string{"milk"}},
+		{`"buy milk"`, []string{"milk"}},
+		{`"milk buy"`, nil},
+		{"gro*", []string{"milk", "garden"}},
+		{"garden*", []string{"garden"}},
+		{"café", []string{"unicode"}},
+		{"spät*", []string{"unicode"}},
+		{"nothing", nil},
+	}
+	for _, tt := range tests {
+		got := searchIDs(t, man, tt.q)
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("%q: expected %v, got %v", tt.q, tt.want, got)
+		}
+	}
+}
+
+func TestSearchMalformed(t *testing.T) {
+	man := NewNoteManager()
+	for _, q := range []string{"", "  ", `"unterminated`, "*"} {
+		if _, err := man.Search(q); err == nil {
+			t.Errorf("%q: expected an error, got nothing", q)
+		}
+	}
+}
+
+func TestSearchFollowsUpdates(t *testing.T) {
+	man := NewNoteManager()
+	man.Save(&Note{ID: "a", Content: "old words"})
+	man.Save(&Note{ID: "a", Content: "new words"})
+	if ids := searchIDs(t, man, "old"); ids != nil {
+		t.Errorf("expected no match for replaced content, got %v", ids)
+	}
+	if ids := searchIDs(t, man, "new"); len(ids) != 1 {
+		t.Errorf("expected a match for new content, got %v", ids)
+	}
+	man.Delete("a")
+	if ids := searchIDs(t, man, "words"); ids != nil {
+		t.Errorf("expected no match for a deleted note, got %v", ids)
+	}
+	if len(man.search.postings) != 0 || man.search.totalLen != 0 {
+		t.Errorf("expected an empty index, got %v", man.search.postings)
+	}
+}
+
+func TestSearchSnippet(t *testing.T) {
+	man := NewNoteManager()
+	long := strings.Repeat("filler ", 20) + "the <b>milk</b> is here " + strings.Repeat("tail ", 40)
+	man.Save(&Note{ID: "a", Content: long})
+	res, err := man.Search("milk")
+	if err != nil || len(res) != 1 {
+		t.Fatalf("expected 1 result, got %v, %v", res, err)
+	}
+	s := res[0].Snippet
+	if !strings.Contains(s, "&lt;b&gt;<mark>milk</mark>&lt;/b&gt;") {
+		t.Errorf("expected an escaped, highlighted match, got %q", s)
+	}
+	if !strings.HasPrefix(s, "…") || !strings.HasSuffix(s, "…") {
+		t.Errorf("expected a snippet cut on both ends, got %q", s)
+	}
+}
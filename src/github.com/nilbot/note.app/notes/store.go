@@ -0,0 +1,337 @@
+package notes
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Store persists the notes of a NoteManager.
+type Store interface {
+	// Load returns every note held by the store.
+	Load() ([]*Note, error)
+	// Put saves n, replacing any note with the same ID, and appends r, if
+	// not nil, to its revisions.
+	Put(n *Note, r *Revision) error
+	// PutAll is like Put for every note of ns and revision of rs, which
+	// have the same length. Either all of the notes are saved or none.
+	PutAll(ns []*Note, rs []*Revision) error
+	// ResetAll saves ns like PutAll, with every revision of rs replacing
+	// all of the revisions of its note.
+	ResetAll(ns []*Note, rs []*Revision) error
+	// Delete removes the note with the given ID and its revisions. The
+	// digests of the versions it went through are kept as its tombstone,
+	// until a note with the same ID is put again.
+	Delete(id string, versions []string) error
+	// LoadHistory returns the revisions held by the store by note ID.
+	LoadHistory() (map[string][]Revision, error)
+	// LoadTombstones returns the tombstones held by the store by note ID.
+	LoadTombstones() (map[string][]string, error)
+	// Close releases any resource held by the store.
+	Close() error
+}
+
+// memoryStore keeps notes in memory only, they are lost on restart.
+type memoryStore struct {
+	notes      map[string]*Note
+	history    map[string][]Revision
+	tombstones map[string][]string
+}
+
+// NewMemoryStore returns a Store that keeps notes in memory only.
+func NewMemoryStore() Store {
+	return &memoryStore{
+		notes:      make(map[string]*Note),
+		history:    make(map[string][]Revision),
+		tombstones: make(map[string][]string),
+	}
+}
+
+func (s *memoryStore) Load() ([]*Note, error) {
+	v := make([]*Note, 0, len(s.notes))
+	for _, n := range s.notes {
+		v = append(v, n)
+	}
+	return v, nil
+}
+
+func (s *memoryStore) Put(n *Note, r *Revision) error {
+	s.notes[n.ID] = n
+	delete(s.tombstones, n.ID)
+	if r != nil {
+		s.history[n.ID] = append(s.history[n.ID], *r)
+	}
+	return nil
+}
+
+func (s *memoryStore) PutAll(ns []*Note, rs []*Revision) error {
+	for i, n := range ns {
+		s.Put(n, rs[i])
+	}
+	return nil
+}
+
+func (s *memoryStore) ResetAll(ns []*Note, rs []*Revision) error {
+	for i, n := range ns {
+		s.notes[n.ID] = n
+		s.history[n.ID] = []Revision{*rs[i]}
+		delete(s.tombstones, n.ID)
+	}
+	return nil
+}
+
+func (s *memoryStore) Delete(id string, versions []string) error {
+	delete(s.notes, id)
+	delete(s.history, id)
+	s.tombstones[id] = versions
+	return nil
+}
+
+func (s *memoryStore) LoadHistory() (map[string][]Revision, error) {
+	h := make(map[string][]Revision, len(s.history))
+	for id, revs := range s.history {
+		h[id] = append([]Revision(nil), revs...)
+	}
+	return h, nil
+}
+
+func (s *memoryStore) LoadTombstones() (map[string][]string, error) {
+	t := make(map[string][]string, len(s.tombstones))
+	for id, vs := range s.tombstones {
+		t[id] = vs
+	}
+	return t, nil
+}
+
+func (s *memoryStore) Close() error {
+	return nil
+}
+
+// logFile is the name of the JSON-lines file kept by a file store.
+const logFile = "notes.jsonl"
+
+// record is a single line of the file store log.
+type record struct {
+	Op      string
+	Note    *Note      `json:",omitempty"`
+	ID      string     `json:",omitempty"`
+	Rev     *Revision  `json:",omitempty"`
+	History []Revision `json:",omitempty"`
+	// Versions is the tombstone of a deleted note.
+	Versions []string `json:",omitempty"`
+	Batch    []record `json:",omitempty"`
+}
+
+// batch returns a record grouping a put record for every note of ns.
+func batch(ns []*Note, rs []*Revision) record {
+	rec := record{Op: "batch"}
+	for i, n := range ns {
+		rec.Batch = append(rec.Batch, record{Op: "put", Note: n, Rev: rs[i]})
+	}
+	return rec
+}
+
+// reset returns a record replacing every note with the ID of a note of ns,
+// and its revisions, with the note and the revision of rs.
+func reset(ns []*Note, rs []*Revision) record {
+	rec := record{Op: "batch"}
+	for i, n := range ns {
+		rec.Batch = append(rec.Batch, record{Op: "delete", ID: n.ID}, record{Op: "put", Note: n, Rev: rs[i]})
+	}
+	return rec
+}
+
+// fileStore appends every change as a JSON line to a log file in a data
+// directory, the log is replayed on Load.
+type fileStore struct {
+	path string
+	f    *os.File
+	size int64
+}
+
+// OpenFileStore opens, or creates, a file backed Store in dir. A partial
+// last line, as left by a crash in the middle of a write, is discarded.
+func OpenFileStore(dir string) (Store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, logFile)
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	size, err := completeLines(f)
+	if err == nil {
+		err = f.Truncate(size)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &fileStore{path, f, size}, nil
+}
+
+// completeLines returns the size of f up to the end of its last line.
+func completeLines(f *os.File) (int64, error) {
+	st, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	buf := make([]byte, 4096)
+	for end := st.Size(); end > 0; {
+		start := end - int64(len(buf))
+		if start < 0 {
+			start = 0
+		}
+		b := buf[:end-start]
+		if _, err := f.ReadAt(b, start); err != nil {
+			return 0, err
+		}
+		if i := bytes.LastIndexByte(b, '\n'); i >= 0 {
+			return start + int64(i) + 1, nil
+		}
+		end = start
+	}
+	return 0, nil
+}
+
+// replay calls apply for every record in the log, batches are unpacked.
+func (s *fileStore) replay(apply func(rec record) error) error {
+	f, err := os.Open(s.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, 64<<20)
+	for line := 1; sc.Scan(); line++ {
+		var rec record
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			return fmt.Errorf("%s:%d: %v", s.path, line, err)
+		}
+		recs := []record{rec}
+		if rec.Op == "batch" {
+			recs = rec.Batch
+		}
+		for _, rec := range recs {
+			if err := apply(rec); err != nil {
+				return fmt.Errorf("%s:%d: %v", s.path, line, err)
+			}
+		}
+	}
+	return sc.Err()
+}
+
+func (s *fileStore) Load() ([]*Note, error) {
+	notes := make(map[string]*Note)
+	var order []string
+	err := s.replay(func(rec record) error {
+		switch rec.Op {
+		case "put":
+			if rec.Note == nil {
+				return fmt.Errorf("put without note")
+			}
+			if _, ok := notes[rec.Note.ID]; !ok {
+				order = append(order, rec.Note.ID)
+			}
+			notes[rec.Note.ID] = rec.Note
+		case "delete":
+			delete(notes, rec.ID)
+		default:
+			return fmt.Errorf("unknown op %q", rec.Op)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	v := make([]*Note, 0, len(notes))
+	for _, id := range order {
+		if n, ok := notes[id]; ok {
+			v = append(v, n)
+			delete(notes, id)
+		}
+	}
+	return v, nil
+}
+
+func (s *fileStore) LoadHistory() (map[string][]Revision, error) {
+	h := make(map[string][]Revision)
+	err := s.replay(func(rec record) error {
+		switch rec.Op {
+		case "put":
+			if rec.Note != nil && rec.Rev != nil {
+				h[rec.Note.ID] = append(h[rec.Note.ID], *rec.Rev)
+			}
+		case "delete":
+			delete(h, rec.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+func (s *fileStore) LoadTombstones() (map[string][]string, error) {
+	t := make(map[string][]string)
+	err := s.replay(func(rec record) error {
+		switch rec.Op {
+		case "put":
+			if rec.Note != nil {
+				delete(t, rec.Note.ID)
+			}
+		case "delete":
+			if rec.Versions != nil {
+				t[rec.ID] = rec.Versions
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// append writes rec as a line of the log and flushes it to stable storage.
+// Whatever part of a failed write made it is dropped, so later records
+// don't follow a partial line.
+func (s *fileStore) append(rec record) error {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	if _, err := s.f.Write(b); err != nil {
+		s.f.Truncate(s.size)
+		return err
+	}
+	s.size += int64(len(b))
+	return s.f.Sync()
+}
+
+func (s *fileStore) Put(n *Note, r *Revision) error {
+	return s.append(record{Op: "put", Note: n, Rev: r})
+}
+
+func (s *fileStore) PutAll(ns []*Note, rs []*Revision) error {
+	return s.append(batch(ns, rs))
+}
+
+func (s *fileStore) ResetAll(ns []*Note, rs []*Revision) error {
+	return s.append(reset(ns, rs))
+}
+
+func (s *fileStore) Delete(id string, versions []string) error {
+	return s.append(record{Op: "delete", ID: id, Versions: versions})
+}
+
+func (s *fileStore) Close() error {
+	return s.f.Close()
+}
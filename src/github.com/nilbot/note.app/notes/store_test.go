@@ -0,0 +1,196 @@
+package notes
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func tempDirOrFatal(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "notes")
+	if err != nil {
+		t.Fatalf("temp dir: %v", err)
+	}
+	return dir
+}
+
+func openFileManagerOrFatal(t *testing.T, dir string) *NoteManager {
+	s, err := OpenFileStore(dir)
+	if err != nil {
+		t.Fatalf("open file store: %v", err)
+	}
+	man, err := OpenNoteManager(s)
+	if err != nil {
+		t.Fatalf("open note manager: %v", err)
+	}
+	return man
+}
+
+func TestMemoryStore(t *testing.T) {
+	s := NewMemoryStore()
+	man, err := OpenNoteManager(s)
+	if err != nil {
+		t.Fatalf("open note manager: %v", err)
+	}
+	note := newNoteOrFatal(t, "remember the #milk")
+	if err := man.Save(note); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	ns, _ := s.Load()
+	if len(ns) != 1 || *ns[0] != *note {
+		t.Errorf("expected store to hold %v, got %v", note, ns)
+	}
+}
+
+func TestFileStoreReload(t *testing.T) {
+	dir := tempDirOrFatal(t)
+	defer os.RemoveAll(dir)
+
+	man := openFileManagerOrFatal(t, dir)
+	first := newNoteOrFatal(t, "buy bread #todo")
+	second := newNoteOrFatal(t, "call mum #todo #family")
+	for _, n := range []*Note{first, second} {
+		if err := man.Save(n); err != nil {
+			t.Fatalf("save: %v", err)
+		}
+	}
+	if err := man.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	man = openFileManagerOrFatal(t, dir)
+	defer man.Close()
+	if all := man.AllNotes(); len(all) != 2 {
+		t.Errorf("expected 2 notes, got %v", len(all))
+	}
+	if n, ok := man.Find(second.ID); !ok || *n != *second {
+		t.Errorf("expected %v, got %v", second, n)
+	}
+	if ids, ok := man.NotesWith("todo"); !ok || len(ids) != 2 {
+		t.Errorf("expected 2 notes tagged %q, got %v", "todo", ids)
+	}
+	if ids, ok := man.NotesWith("family"); !ok || len(ids) != 1 || ids[0] != second.ID {
+		t.Errorf("expected %q tagged %q, got %v", second.ID, "family", ids)
+	}
+}
+
+func TestFileStoreCorrupt(t *testing.T) {
+	dir := tempDirOrFatal(t)
+	defer os.RemoveAll(dir)
+
+	if err := ioutil.WriteFile(filepath.Join(dir, logFile), []byte("not json\n"), 0644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	s, err := OpenFileStore(dir)
+	if err != nil {
+		t.Fatalf("open file store: %v", err)
+	}
+	defer s.Close()
+	if _, err := OpenNoteManager(s); err == nil {
+		t.Errorf("expected an error loading a corrupt log, got nothing")
+	}
+}
+
+func TestFileStoreTornLine(t *testing.T) {
+	dir := tempDirOrFatal(t)
+	defer os.RemoveAll(dir)
+
+	man := openFileManagerOrFatal(t, dir)
+	n := newNoteOrFatal(t, "kept #a")
+	if err := man.Save(n); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	man.Close()
+	f, err := os.OpenFile(filepath.Join(dir, logFile), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	f.WriteString(`{"Op":"put","Note":{"ID":"torn`)
+	f.Close()
+
+	man = openFileManagerOrFatal(t, dir)
+	if _, ok := man.Find(n.ID); !ok {
+		t.Errorf("expected to find %q", n.Content)
+	}
+	m := newNoteOrFatal(t, "after #b")
+	if err := man.Save(m); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	man.Close()
+	man = openFileManagerOrFatal(t, dir)
+	defer man.Close()
+	if _, ok := man.Find(m.ID); !ok {
+		t.Errorf("expected to find %q written after the partial line", m.Content)
+	}
+}
+
+func TestFileStoreDelete(t *testing.T) {
+	dir := tempDirOrFatal(t)
+	defer os.RemoveAll(dir)
+
+	man := openFileManagerOrFatal(t, dir)
+	keep := newNoteOrFatal(t, "keep #a")
+	gone := newNoteOrFatal(t, "gone #a #b")
+	man.Save(keep)
+	man.Save(gone)
+	if err := man.Delete(gone.ID); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	man.Close()
+
+	man = openFileManagerOrFatal(t, dir)
+	defer man.Close()
+	if all := man.AllNotes(); len(all) != 1 || all[0].ID != keep.ID {
+		t.Errorf("expected only %v, got %v", keep, all)
+	}
+	if _, ok := man.NotesWith("b"); ok {
+		t.Errorf("expected tag %q to be gone after reload", "b")
+	}
+}
+
+func TestFileStoreLegacyNotes(t *testing.T) {
+	dir := tempDirOrFatal(t)
+	defer os.RemoveAll(dir)
+
+	// notes used to be identified by their sha512 and had no digest.
+	content := "written before digests #old"
+	id := digest(content)
+	line := `{"Op":"put","Note":{"ID":"` + id + `","Content":"` + content + `"}}` + "\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, logFile), []byte(line), 0644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	man := openFileManagerOrFatal(t, dir)
+	defer man.Close()
+	n, ok := man.Find(id)
+	if !ok {
+		t.Fatalf("expected to find the legacy note by its old ID")
+	}
+	if n.Digest != id {
+		t.Errorf("expected digest %q, got %q", id, n.Digest)
+	}
+	if n.CreatedAt.IsZero() || !n.UpdatedAt.Equal(n.CreatedAt) {
+		t.Errorf("expected the legacy note to get timestamps, got %v and %v", n.CreatedAt, n.UpdatedAt)
+	}
+	if err := man.Save(&Note{ID: id, Content: "edited #old"}); err != nil {
+		t.Errorf("expected to update the legacy note, got %v", err)
+	}
+}
+
+func TestFileStoreDigestMismatch(t *testing.T) {
+	dir := tempDirOrFatal(t)
+	defer os.RemoveAll(dir)
+
+	line := `{"Op":"put","Note":{"ID":"a","Content":"tampered","Digest":"` + digest("original") + `"}}` + "\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, logFile), []byte(line), 0644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	s, err := OpenFileStore(dir)
+	if err != nil {
+		t.Fatalf("open file store: %v", err)
+	}
+	defer s.Close()
+	if _, err := OpenNoteManager(s); err == nil {
+		t.Errorf("expected a digest mismatch, got nothing")
+	}
+}
@@ -0,0 +1,453 @@
+package notes
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// syncPage is the number of changes exchanged per request when syncing.
+const syncPage = 500
+
+// syncClient is the client of peers without one, so that a peer that never
+// answers doesn't hold a sync forever.
+var syncClient = &http.Client{Timeout: time.Minute}
+
+// validID matches the IDs notes may be synced or imported with: the IDs
+// generated, and legacy IDs, which are hex digests, or any ID usable in a
+// URL path.
+var validID = regexp.MustCompile(`^[A-Za-z0-9_-]{1,128}$`)
+
+// syncState numbers the changes of the notes of a NoteManager for
+// replication. The sequence starts over, under a new replica ID, whenever a
+// NoteManager is opened, so peers know to start over too. Tombstones are
+// kept by the Store, so deletions are still replicated once it is.
+type syncState struct {
+	replica string
+	seq     uint64
+	// seqs holds the last change of every note, deleted or not.
+	seqs map[string]uint64
+	// tombstones holds the versions of the deleted notes.
+	tombstones map[string][]string
+	// merged holds the versions of notes kept as conflict copies.
+	merged map[string]map[string]bool
+}
+
+func newSyncState() *syncState {
+	return &syncState{
+		replica:    TimeID(),
+		seqs:       make(map[string]uint64),
+		tombstones: make(map[string][]string),
+		merged:     make(map[string]map[string]bool),
+	}
+}
+
+// record numbers the change of a note from before to after, either of
+// which may be nil.
+func (s *syncState) record(man *NoteManager, before, after *Note) uint64 {
+	s.seq++
+	if after == nil {
+		s.tombstones[before.ID] = man.versions(before.ID)
+		delete(s.merged, before.ID)
+		s.seqs[before.ID] = s.seq
+	} else {
+		delete(s.tombstones, after.ID)
+		s.seqs[after.ID] = s.seq
+	}
+	return s.seq
+}
+
+// bury numbers the deletion of the note with the given ID, deleted before
+// the NoteManager was opened after going through versions.
+func (s *syncState) bury(id string, versions []string) {
+	s.seq++
+	s.tombstones[id] = versions
+	s.seqs[id] = s.seq
+}
+
+// versions returns the digests of the contents the note with the given ID
+// went through, or was merged with, oldest first.
+func (man *NoteManager) versions(id string) []string {
+	var vs []string
+	seen := make(map[string]bool)
+	add := func(d string) {
+		if !seen[d] {
+			seen[d] = true
+			vs = append(vs, d)
+		}
+	}
+	for _, r := range man.history[id] {
+		add(digest(r.Content))
+	}
+	if n, ok := man.notes[id]; ok {
+		add(n.Digest)
+	}
+	merged := make([]string, 0, len(man.sync.merged[id]))
+	for d := range man.sync.merged[id] {
+		merged = append(merged, d)
+	}
+	sort.Strings(merged)
+	for _, d := range merged {
+		add(d)
+	}
+	return vs
+}
+
+// knows reports whether the note with the given ID went through, or was
+// merged with, the content with digest d.
+func (man *NoteManager) knows(id, d string) bool {
+	for _, v := range man.versions(id) {
+		if v == d {
+			return true
+		}
+	}
+	return false
+}
+
+// Replica returns the ID of the change sequence of man.
+func (man *NoteManager) Replica() string {
+	man.mu.RLock()
+	defer man.mu.RUnlock()
+	return man.sync.replica
+}
+
+// Change is the last change of a note in the change sequence of a
+// NoteManager. Note is nil if the note was deleted. Versions are the
+// digests of the contents the note went through, so a replica can tell
+// whether its own copy is older, newer or was edited concurrently.
+type Change struct {
+	Seq      uint64
+	ID       string
+	Note     *Note `json:",omitempty"`
+	Versions []string
+}
+
+// ChangeSet is a part of the change sequence of a replica. Seq is the
+// sequence number to ask for changes since next. More is set if there are
+// more changes after Seq already.
+type ChangeSet struct {
+	Replica string
+	Seq     uint64
+	More    bool `json:",omitempty"`
+	Changes []Change
+}
+
+// Changes returns the changes numbered after since, at most limit of them
+// if limit is positive, in order. Only the last change of every note is
+// kept.
+func (man *NoteManager) Changes(since uint64, limit int) ChangeSet {
+	man.mu.RLock()
+	defer man.mu.RUnlock()
+	set := ChangeSet{Replica: man.sync.replica, Seq: man.sync.seq, Changes: []Change{}}
+	for id, seq := range man.sync.seqs {
+		if seq > since {
+			set.Changes = append(set.Changes, Change{Seq: seq, ID: id})
+		}
+	}
+	sort.Slice(set.Changes, func(i, j int) bool { return set.Changes[i].Seq < set.Changes[j].Seq })
+	if limit > 0 && len(set.Changes) > limit {
+		set.Changes = set.Changes[:limit]
+		set.Seq = set.Changes[limit-1].Seq
+		set.More = true
+	}
+	for i := range set.Changes {
+		c := &set.Changes[i]
+		if n, ok := man.notes[c.ID]; ok {
+			cp := *n
+			c.Note = &cp
+			c.Versions = man.versions(c.ID)
+		} else {
+			c.Versions = man.sync.tombstones[c.ID]
+		}
+	}
+	return set
+}
+
+// Conflict is a note edited concurrently on two replicas. The local note
+// is kept and the other version saved as the note Copy.
+type Conflict struct {
+	ID   string
+	Copy string
+}
+
+// ApplyResult tells what applying changes did. Applied is the number of
+// changes that changed notes.
+type ApplyResult struct {
+	Applied   int
+	Conflicts []Conflict
+}
+
+// conflictID returns the ID of the conflict copy of the version with
+// digest d of the note with the given ID, the same on every replica.
+func conflictID(id, d string) string {
+	return digest(id + "\n" + d)[:24]
+}
+
+// Apply applies the changes of another replica:
+//
+//   - new notes are created, unless deleted here since;
+//   - notes are updated if their content here is one of the versions the
+//     change went through, otherwise the change is older or concurrent;
+//   - concurrent edits are kept, the other version as a conflict copy;
+//   - notes are deleted unless edited here since;
+//   - titles, pinning and archiving follow the last update when the
+//     contents are the same.
+//
+// Nothing is applied if a change has an ID notes can't be imported with,
+// or holds a note with another ID or whose digest doesn't match.
+func (man *NoteManager) Apply(changes []Change) (ApplyResult, error) {
+	res := ApplyResult{Conflicts: []Conflict{}}
+	for _, c := range changes {
+		if !validID.MatchString(c.ID) {
+			return res, fmt.Errorf("change %d: invalid note ID %q", c.Seq, c.ID)
+		}
+		if c.Note == nil {
+			continue
+		}
+		if c.Note.ID != c.ID {
+			return res, fmt.Errorf("change %d: inconsistent note IDs", c.Seq)
+		}
+		if err := c.Note.Verify(); err != nil {
+			return res, fmt.Errorf("change %d of note %s: %w", c.Seq, c.ID, err)
+		}
+	}
+	man.mu.Lock()
+	defer man.mu.Unlock()
+	for _, c := range changes {
+		applied, conflict, err := man.apply(c)
+		if err != nil {
+			return res, err
+		}
+		if applied {
+			res.Applied++
+		}
+		if conflict != nil {
+			res.Conflicts = append(res.Conflicts, *conflict)
+		}
+	}
+	return res, nil
+}
+
+func (man *NoteManager) apply(c Change) (bool, *Conflict, error) {
+	local, ok := man.notes[c.ID]
+	remote := c.Note
+	switch {
+	case remote == nil:
+		if !ok || !contains(c.Versions, local.Digest) {
+			return false, nil, nil
+		}
+		return true, nil, man.delete(local)
+	case !ok:
+		if contains(man.sync.tombstones[c.ID], remote.Digest) {
+			return false, nil, nil
+		}
+		n := *remote
+		return true, nil, man.save(&n)
+	case local.Digest == remote.Digest:
+		n := *local
+		n.Title, n.Pinned, n.Archived = remote.Title, remote.Pinned, remote.Archived
+		if n == *local || !remote.UpdatedAt.After(local.UpdatedAt) {
+			return false, nil, nil
+		}
+		return true, nil, man.save(&n)
+	case contains(c.Versions, local.Digest):
+		n := *remote
+		return true, nil, man.save(&n)
+	case man.knows(c.ID, remote.Digest):
+		return false, nil, nil
+	}
+	cp := *remote
+	cp.ID = conflictID(c.ID, remote.Digest)
+	cp.Title = remote.DisplayTitle() + " (conflict copy)"
+	if man.sync.merged[c.ID] == nil {
+		man.sync.merged[c.ID] = make(map[string]bool)
+	}
+	man.sync.merged[c.ID][remote.Digest] = true
+	if _, ok := man.notes[cp.ID]; ok {
+		return false, nil, nil
+	}
+	if err := man.save(&cp); err != nil {
+		return false, nil, err
+	}
+	return true, &Conflict{c.ID, cp.ID}, nil
+}
+
+func contains(a []string, s string) bool {
+	for _, v := range a {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// SyncHandler returns the handler of the sync protocol of man, for a Peer
+// to sync with it. Relative to where it is mounted, it serves
+//
+//	GET /changes?since=N&replica=R&limit=L
+//
+// with the ChangeSet after N, from the start if R isn't the replica of
+// man, and
+//
+//	POST /changes
+//
+// applying the changes of the ChangeSet in the request body, returning an
+// ApplyResult.
+func (man *NoteManager) SyncHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/changes", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+			var since uint64
+			if v := r.FormValue("since"); v != "" {
+				var err error
+				if since, err = strconv.ParseUint(v, 10, 64); err != nil {
+					http.Error(w, "bad since "+strconv.Quote(v), http.StatusBadRequest)
+					return
+				}
+			}
+			if rep := r.FormValue("replica"); rep != "" && rep != man.Replica() {
+				since = 0
+			}
+			limit := syncPage
+			if v := r.FormValue("limit"); v != "" {
+				n, err := strconv.Atoi(v)
+				if err != nil {
+					http.Error(w, "bad limit "+strconv.Quote(v), http.StatusBadRequest)
+					return
+				}
+				limit = n
+			}
+			json.NewEncoder(w).Encode(man.Changes(since, limit))
+		case "POST":
+			var set ChangeSet
+			if err := json.NewDecoder(r.Body).Decode(&set); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			res, err := man.Apply(set.Changes)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			json.NewEncoder(w).Encode(res)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+	return mux
+}
+
+// Peer is another replica served by SyncHandler at URL. It remembers how
+// far syncing with it went. A Peer must not be used concurrently.
+type Peer struct {
+	URL string
+	// Client is used for requests, a client timing out after a minute if
+	// nil.
+	Client *http.Client
+
+	replica string
+	pulled  uint64
+	local   string
+	pushed  uint64
+}
+
+// SyncResult tells what syncing did. Pulled and Pushed are the numbers of
+// changes applied here and by the peer. Conflicts are those found on
+// either side.
+type SyncResult struct {
+	Pulled    int
+	Pushed    int
+	Conflicts []Conflict
+}
+
+func (p *Peer) client() *http.Client {
+	if p.Client == nil {
+		return syncClient
+	}
+	return p.Client
+}
+
+// Sync applies the changes of the peer since the last sync to man, then
+// sends it the changes of man.
+func (p *Peer) Sync(man *NoteManager) (SyncResult, error) {
+	res := SyncResult{Conflicts: []Conflict{}}
+	if err := p.pull(man, &res); err != nil {
+		return res, err
+	}
+	err := p.push(man, &res)
+	return res, err
+}
+
+func (p *Peer) pull(man *NoteManager, res *SyncResult) error {
+	for {
+		u := fmt.Sprintf("%s/changes?since=%d&replica=%s&limit=%d", p.URL, p.pulled, url.QueryEscape(p.replica), syncPage)
+		resp, err := p.client().Get(u)
+		if err != nil {
+			return err
+		}
+		var set ChangeSet
+		err = decodeResponse(resp, &set)
+		if err != nil {
+			return err
+		}
+		ar, err := man.Apply(set.Changes)
+		if err != nil {
+			return err
+		}
+		res.Pulled += ar.Applied
+		res.Conflicts = append(res.Conflicts, ar.Conflicts...)
+		p.replica, p.pulled = set.Replica, set.Seq
+		if !set.More {
+			return nil
+		}
+	}
+}
+
+func (p *Peer) push(man *NoteManager, res *SyncResult) error {
+	if rep := man.Replica(); rep != p.local {
+		p.local, p.pushed = rep, 0
+	}
+	for {
+		set := man.Changes(p.pushed, syncPage)
+		if len(set.Changes) == 0 {
+			p.pushed = set.Seq
+			return nil
+		}
+		body, err := json.Marshal(set)
+		if err != nil {
+			return err
+		}
+		resp, err := p.client().Post(p.URL+"/changes", "application/json", bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		var ar ApplyResult
+		if err := decodeResponse(resp, &ar); err != nil {
+			return err
+		}
+		res.Pushed += ar.Applied
+		res.Conflicts = append(res.Conflicts, ar.Conflicts...)
+		p.pushed = set.Seq
+		if !set.More {
+			return nil
+		}
+	}
+}
+
+// decodeResponse decodes the JSON body of a successful response into v.
+func decodeResponse(resp *http.Response, v interface{}) error {
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var b bytes.Buffer
+		b.ReadFrom(resp.Body)
+		return fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(b.Bytes()))
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,289 @@
+package notes
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// replica is a NoteManager served over HTTP, with a Peer for syncing with
+// another replica.
+type replica struct {
+	man  *NoteManager
+	srv  *httptest.Server
+	peer *Peer
+}
+
+func newReplicas(t *testing.T) (*replica, *replica) {
+	a := &replica{man: NewNoteManager()}
+	b := &replica{man: NewNoteManager()}
+	a.srv = httptest.NewServer(a.man.SyncHandler())
+	b.srv = httptest.NewServer(b.man.SyncHandler())
+	a.peer = &Peer{URL: b.srv.URL}
+	b.peer = &Peer{URL: a.srv.URL}
+	return a, b
+}
+
+func (r *replica) close() {
+	r.srv.Close()
+}
+
+func (r *replica) syncOrFatal(t *testing.T) SyncResult {
+	res, err := r.peer.Sync(r.man)
+	if err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+	return res
+}
+
+// contents returns the contents of the notes of man by ID.
+func contents(man *NoteManager) map[string]string {
+	res := make(map[string]string)
+	for _, n := range man.AllNotes() {
+		res[n.ID] = n.Content
+	}
+	return res
+}
+
+func expectSynced(t *testing.T, a, b *replica) {
+	if ca, cb := contents(a.man), contents(b.man); !reflect.DeepEqual(ca, cb) {
+		t.Errorf("expected replicas to hold the same notes, got %v and %v", ca, cb)
+	}
+}
+
+func TestSyncCreateUpdateDelete(t *testing.T) {
+	a, b := newReplicas(t)
+	defer a.close()
+	defer b.close()
+
+	editOrFatal(t, a.man, "aaaa0001", "buy milk")
+	if res := b.syncOrFatal(t); res.Pulled != 1 || res.Pushed != 0 {
+		t.Errorf("expected to pull one note, got %+v", res)
+	}
+	expectSynced(t, a, b)
+
+	editOrFatal(t, b.man, "aaaa0001", "buy milk #todo")
+	if res := b.syncOrFatal(t); res.Pushed != 1 || len(res.Conflicts) != 0 {
+		t.Errorf("expected to push the edit, got %+v", res)
+	}
+	expectSynced(t, a, b)
+
+	if err := a.man.Delete("aaaa0001"); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	b.syncOrFatal(t)
+	if n := len(b.man.AllNotes()); n != 0 {
+		t.Errorf("expected the deletion to be synced, got %d notes", n)
+	}
+	if res := b.syncOrFatal(t); res.Pulled != 0 || res.Pushed != 0 {
+		t.Errorf("expected nothing left to sync, got %+v", res)
+	}
+}
+
+func TestSyncConflict(t *testing.T) {
+	a, b := newReplicas(t)
+	defer a.close()
+	defer b.close()
+
+	editOrFatal(t, a.man, "aaaa0001", "buy milk")
+	b.syncOrFatal(t)
+	editOrFatal(t, a.man, "aaaa0001", "buy oat milk")
+	editOrFatal(t, b.man, "aaaa0001", "buy soy milk")
+
+	res := b.syncOrFatal(t)
+	if len(res.Conflicts) != 1 || res.Conflicts[0].ID != "aaaa0001" {
+		t.Fatalf("expected a conflict on aaaa0001, got %+v", res)
+	}
+	copyID := res.Conflicts[0].Copy
+	a.syncOrFatal(t)
+	expectSynced(t, a, b)
+	for _, r := range []*replica{a, b} {
+		if n, _ := r.man.Find("aaaa0001"); n.Content != "buy soy milk" {
+			t.Errorf("expected the note of the syncing replica to be kept, got %q", n.Content)
+		}
+		n, ok := r.man.Find(copyID)
+		if !ok || n.Content != "buy oat milk" || n.Title != "buy oat milk (conflict copy)" {
+			t.Errorf("expected a conflict copy of the other edit, got %+v", n)
+		}
+	}
+	if res := b.syncOrFatal(t); len(res.Conflicts) != 0 || res.Pulled != 0 {
+		t.Errorf("expected the conflict to be resolved, got %+v", res)
+	}
+}
+
+func TestSyncEditWinsOverDelete(t *testing.T) {
+	a, b := newReplicas(t)
+	defer a.close()
+	defer b.close()
+
+	editOrFatal(t, a.man, "aaaa0001", "buy milk")
+	b.syncOrFatal(t)
+	if err := a.man.Delete("aaaa0001"); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	editOrFatal(t, b.man, "aaaa0001", "buy milk and bread")
+	b.syncOrFatal(t)
+	expectSynced(t, a, b)
+	if n, ok := a.man.Find("aaaa0001"); !ok || n.Content != "buy milk and bread" {
+		t.Errorf("expected the edit to survive the deletion, got %+v", n)
+	}
+}
+
+func TestSyncMetadata(t *testing.T) {
+	a, b := newReplicas(t)
+	defer a.close()
+	defer b.close()
+	a.man.now = tickingClock()
+	b.man.now = tickingClock()
+
+	editOrFatal(t, a.man, "aaaa0001", "buy milk")
+	b.syncOrFatal(t)
+	n, _ := a.man.Find("aaaa0001")
+	n.Pinned = true
+	for i := 0; i < 3; i++ {
+		// move the clock of a past the one of b.
+		a.man.now()
+	}
+	if err := a.man.Save(n); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	b.syncOrFatal(t)
+	if n, _ := b.man.Find("aaaa0001"); !n.Pinned {
+		t.Errorf("expected pinning to be synced, got %+v", n)
+	}
+}
+
+func TestSyncRestartedReplica(t *testing.T) {
+	a, b := newReplicas(t)
+	defer a.close()
+	defer b.close()
+
+	editOrFatal(t, a.man, "aaaa0001", "one")
+	editOrFatal(t, a.man, "aaaa0002", "two")
+	b.syncOrFatal(t)
+
+	// a restarts with its notes under a new replica ID.
+	restarted, err := OpenNoteManager(a.man.store)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	editOrFatal(t, restarted, "aaaa0003", "three")
+	srv := httptest.NewServer(restarted.SyncHandler())
+	defer srv.Close()
+	b.peer.URL = srv.URL
+	if res := b.syncOrFatal(t); res.Pulled != 1 || len(res.Conflicts) != 0 {
+		t.Errorf("expected to pull the new note only, got %+v", res)
+	}
+	var ids []string
+	for id := range contents(b.man) {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	if expected := []string{"aaaa0001", "aaaa0002", "aaaa0003"}; !reflect.DeepEqual(ids, expected) {
+		t.Errorf("expected notes %v, got %v", expected, ids)
+	}
+}
+
+func TestSyncDeleteAfterRestart(t *testing.T) {
+	a, b := newReplicas(t)
+	defer a.close()
+	defer b.close()
+
+	editOrFatal(t, a.man, "aaaa0001", "one")
+	editOrFatal(t, a.man, "aaaa0002", "two")
+	b.syncOrFatal(t)
+	if err := a.man.Delete("aaaa0001"); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+
+	// a restarts before b learns about the deletion.
+	restarted, err := OpenNoteManager(a.man.store)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	srv := httptest.NewServer(restarted.SyncHandler())
+	defer srv.Close()
+	b.peer.URL = srv.URL
+	b.syncOrFatal(t)
+	if c := contents(b.man); len(c) != 1 || c["aaaa0002"] != "two" {
+		t.Errorf("expected the deletion to be synced, got %v", c)
+	}
+	if c := contents(restarted); len(c) != 1 {
+		t.Errorf("expected the deleted note not to come back, got %v", c)
+	}
+}
+
+func TestChangesEndpoint(t *testing.T) {
+	a, b := newReplicas(t)
+	defer a.close()
+	defer b.close()
+	editOrFatal(t, a.man, "aaaa0001", "one", "one edited")
+	editOrFatal(t, a.man, "aaaa0002", "two")
+	if err := a.man.Delete("aaaa0002"); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+
+	get := func(query string) ChangeSet {
+		resp, err := http.Get(a.srv.URL + "/changes?" + query)
+		if err != nil {
+			t.Fatalf("get: %v", err)
+		}
+		defer resp.Body.Close()
+		var set ChangeSet
+		if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		return set
+	}
+	set := get("since=0")
+	if set.Replica != a.man.Replica() || set.Seq != 4 || len(set.Changes) != 2 {
+		t.Fatalf("expected the last change of both notes, got %+v", set)
+	}
+	if c := set.Changes[0]; c.ID != "aaaa0001" || c.Seq != 2 || c.Note.Content != "one edited" || len(c.Versions) != 2 {
+		t.Errorf("expected the edit of aaaa0001, got %+v", c)
+	}
+	if c := set.Changes[1]; c.ID != "aaaa0002" || c.Note != nil || len(c.Versions) != 1 {
+		t.Errorf("expected the deletion of aaaa0002, got %+v", c)
+	}
+	if set := get("since=2"); len(set.Changes) != 1 || set.Changes[0].ID != "aaaa0002" {
+		t.Errorf("expected the changes since 2, got %+v", set)
+	}
+	if set := get("since=2&replica=other"); len(set.Changes) != 2 {
+		t.Errorf("expected every change for another replica, got %+v", set)
+	}
+	if set := get("since=0&limit=1"); len(set.Changes) != 1 || !set.More || set.Seq != 2 {
+		t.Errorf("expected a page of one change, got %+v", set)
+	}
+	resp, err := http.Get(a.srv.URL + "/changes?since=x")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected a bad request, got %s", resp.Status)
+	}
+}
+
+func TestApplyRejectsTamperedNotes(t *testing.T) {
+	man := NewNoteManager()
+	editOrFatal(t, man, "aaaa0002", "local")
+	for _, c := range []Change{
+		{ID: "aaaa0001", Note: &Note{ID: "aaaa0001", Content: "tampered", Digest: digest("original")}},
+		{ID: "aaaa0001", Note: &Note{ID: "aaaa0001", Content: "no digest"}},
+		{ID: "aaaa0001", Note: &Note{ID: "aaaa0002", Content: "other", Digest: digest("other")}},
+		{ID: "../../x", Note: &Note{ID: "../../x", Content: "escape", Digest: digest("escape")}},
+		{ID: "a/b", Versions: []string{digest("local")}},
+	} {
+		c.Seq = 1
+		ok := &Change{Seq: 2, ID: "aaaa0003", Note: &Note{ID: "aaaa0003", Content: "fine", Digest: digest("fine")}}
+		if _, err := man.Apply([]Change{*ok, c}); err == nil {
+			t.Errorf("expected change %+v to be refused, got nothing", c)
+		}
+		if c := contents(man); len(c) != 1 || c["aaaa0002"] != "local" {
+			t.Errorf("expected nothing to be applied, got %v", c)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package notes
+
+import (
+	"sort"
+	"strings"
+)
+
+// TagSeparator separates the levels of a hierarchical tag.
+const TagSeparator = "/"
+
+// TagNode is a level of the tag hierarchy. Count is the number of notes
+// tagged with Path itself, Total the number of distinct notes tagged with
+// Path or any of its descendants.
+type TagNode struct {
+	Name     string
+	Path     string
+	Count    int
+	Total    int
+	Children []*TagNode `json:",omitempty"`
+}
+
+// isUnder reports whether tag is parent or one of its descendants.
+func isUnder(tag, parent string) bool {
+	return tag == parent || strings.HasPrefix(tag, parent+TagSeparator)
+}
+
+// subtree returns the distinct IDs of the notes tagged with tag or any of
+// its descendants.
+func (man *NoteManager) subtree(tag string) map[string]bool {
+	tag = man.tok.fold(tag)
+	set := make(map[string]bool)
+	for t, ids := range man.tags {
+		if isUnder(t, tag) {
+			for _, id := range ids {
+				set[id] = true
+			}
+		}
+	}
+	return set
+}
+
+// NotesUnder is like NotesWith but also returns the notes tagged with any
+// descendant of tag, so #project finds notes tagged #project/api/auth.
+// IDs are sorted.
+func (man *NoteManager) NotesUnder(tag string) ([]string, bool) {
+	man.mu.RLock()
+	defer man.mu.RUnlock()
+	set := man.subtree(tag)
+	if len(set) == 0 {
+		return nil, false
+	}
+	ids := make([]string, 0, len(set))
+	for id := range set {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids, true
+}
+
+// TagTree returns the roots of the tag hierarchy, sorted by name at every
+// level. Levels only used as part of longer tags appear with a Count of 0.
+func (man *NoteManager) TagTree() []*TagNode {
+	man.mu.RLock()
+	defer man.mu.RUnlock()
+
+	root := &TagNode{}
+	nodes := map[string]*TagNode{"": root}
+	under := make(map[*TagNode]map[string]bool)
+	for tag, ids := range man.tags {
+		parent := root
+		path := ""
+		for _, name := range strings.Split(tag, TagSeparator) {
+			if path != "" {
+				path += TagSeparator
+			}
+			path += name
+			n, ok := nodes[path]
+			if !ok {
+				n = &TagNode{Name: name, Path: path}
+				nodes[path] = n
+				parent.Children = append(parent.Children, n)
+				under[n] = make(map[string]bool)
+			}
+			for _, id := range ids {
+				under[n][id] = true
+			}
+			parent = n
+		}
+		parent.Count = len(ids)
+	}
+	for n, set := range under {
+		n.Total = len(set)
+		sort.Slice(n.Children, func(i, j int) bool { return n.Children[i].Name < n.Children[j].Name })
+	}
+	sort.Slice(root.Children, func(i, j int) bool { return root.Children[i].Name < root.Children[j].Name })
+	return root.Children
+}
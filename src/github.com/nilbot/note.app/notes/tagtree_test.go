@@ -0,0 +1,91 @@
+package notes
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func hierarchyManager() *NoteManager {
+	man := NewNoteManager()
+	for id, c := range map[string]string{
+		"a": "login broken #project/api/auth #bug",
+		"b": "rate limits #project/api",
+		"c": "kickoff #project",
+		"d": "tokens #project/api/auth #project/api/keys",
+		"e": "unrelated #projects #bug",
+	} {
+		man.Save(&Note{ID: id, Content: c})
+	}
+	return man
+}
+
+func TestHierarchicalTags(t *testing.T) {
+	got := DefaultTokenizer.Tags("#project/api/auth #a/ #b//c #/x #c/-d #日本/東京")
+	want := []string{"project/api/auth", "a", "b", "c", "日本/東京"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
+
+func TestNotesUnder(t *testing.T) {
+	man := hierarchyManager()
+	tests := []struct {
+		tag  string
+		want string
+	}{
+		{"project", "[a b c d]"},
+		{"project/api", "[a b d]"},
+		{"project/api/auth", "[a d]"},
+		{"project/api/keys", "[d]"},
+		{"proj", "[]"},
+	}
+	for _, tt := range tests {
+		ids, _ := man.NotesUnder(tt.tag)
+		if got := fmt.Sprint(ids); got != tt.want {
+			t.Errorf("%q: expected %v, got %v", tt.tag, tt.want, got)
+		}
+	}
+	if ids, _ := man.NotesWith("project"); len(ids) != 1 {
+		t.Errorf("expected NotesWith to stay exact, got %v", ids)
+	}
+}
+
+func TestTagQuerySubtree(t *testing.T) {
+	man := hierarchyManager()
+	ns, err := man.Query("#project/* AND NOT #project/api/auth/*")
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	var ids []string
+	for _, n := range ns {
+		ids = append(ids, n.ID)
+	}
+	if got := strings.Join(ids, ","); got != "b,c" {
+		t.Errorf("expected b,c, got %v", got)
+	}
+}
+
+func TestTagTree(t *testing.T) {
+	man := hierarchyManager()
+	var lines []string
+	var walk func(ns []*TagNode, depth int)
+	walk = func(ns []*TagNode, depth int) {
+		for _, n := range ns {
+			lines = append(lines, fmt.Sprintf("%s%s %s %d/%d", strings.Repeat(" ", depth), n.Name, n.Path, n.Count, n.Total))
+			walk(n.Children, depth+1)
+		}
+	}
+	walk(man.TagTree(), 0)
+	want := []string{
+		"bug bug 2/2",
+		"project project 1/4",
+		" api project/api 1/3",
+		"  auth project/api/auth 2/2",
+		"  keys project/api/keys 1/1",
+		"projects projects 1/1",
+	}
+	if strings.Join(lines, "\n") != strings.Join(want, "\n") {
+		t.Errorf("expected\n%s\ngot\n%s", strings.Join(want, "\n"), strings.Join(lines, "\n"))
+	}
+}
@@ -0,0 +1,186 @@
+package notes
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ErrNoTask is returned for a task ID or number a note doesn't have.
+var ErrNoTask = errors.New("task not found")
+
+// Task is a - [ ] or - [x] item of a list in a note. N numbers the tasks of
+// a note from 1 in order of appearance; checking or unchecking tasks keeps
+// their numbers. ID is derived from the text of the task and the number of
+// tasks with the same text before it, so it is kept as other tasks are
+// added, removed or checked, while N may change.
+type Task struct {
+	Note string
+	ID   string
+	N    int
+	Text string
+	Done bool
+}
+
+// taskIDLen is the length of the IDs of tasks.
+const taskIDLen = 8
+
+// taskID returns the ID of the task with the given text, with k tasks with
+// the same text before it.
+func taskID(text string, k int) string {
+	return digest(fmt.Sprintf("%d\n%s", k, text))[:taskIDLen]
+}
+
+// taskItem is a task with the offset in the content of the character
+// between its brackets.
+type taskItem struct {
+	Task
+	box int
+}
+
+// parseTasks returns the tasks of the note with the given ID and content.
+// Items in fenced code blocks aren't tasks.
+func parseTasks(id, content string) []taskItem {
+	if !strings.Contains(content, "[") {
+		return nil
+	}
+	var ts []taskItem
+	seen := make(map[string]int)
+	fenced := false
+	off := 0
+	for _, s := range strings.Split(content, "\n") {
+		l := line{s, off}
+		off += len(s) + 1
+		if isFence(s) {
+			fenced = !fenced
+			continue
+		}
+		if fenced {
+			continue
+		}
+		_, item, ok := listItem(l.trim())
+		if !ok {
+			continue
+		}
+		done, ok := taskBox(item.text)
+		if !ok {
+			continue
+		}
+		text := item.from(3).trim().text
+		t := Task{id, taskID(text, seen[text]), len(ts) + 1, text, done}
+		seen[text]++
+		ts = append(ts, taskItem{t, item.off + 1})
+	}
+	return ts
+}
+
+// Tasks returns the tasks of the note with the given ID.
+func (man *NoteManager) Tasks(id string) ([]Task, error) {
+	man.mu.RLock()
+	defer man.mu.RUnlock()
+	n, ok := man.notes[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	ts := []Task{}
+	for _, t := range parseTasks(n.ID, n.Content) {
+		ts = append(ts, t.Task)
+	}
+	return ts, nil
+}
+
+// findTask returns the task of ts with the given ID or, if task isn't as
+// long as an ID, the task numbered task.
+func findTask(ts []taskItem, task string) (taskItem, bool) {
+	if len(task) == taskIDLen {
+		for _, t := range ts {
+			if t.ID == task {
+				return t, true
+			}
+		}
+		return taskItem{}, false
+	}
+	n, err := strconv.Atoi(task)
+	if err != nil || n < 1 || n > len(ts) {
+		return taskItem{}, false
+	}
+	return ts[n-1], true
+}
+
+// SetTask checks or unchecks the task of the note with the given ID by
+// rewriting its content, and returns the task. task is the ID of the task,
+// or its number, which may refer to another task once the note is edited.
+func (man *NoteManager) SetTask(id, task string, done bool) (Task, error) {
+	return man.updateTask(id, task, func(bool) bool { return done })
+}
+
+// ToggleTask checks the task of the note with the given ID if it is open
+// and unchecks it otherwise, and returns the task. task is the ID or the
+// number of the task, as for SetTask.
+func (man *NoteManager) ToggleTask(id, task string) (Task, error) {
+	return man.updateTask(id, task, func(done bool) bool { return !done })
+}
+
+// updateTask replaces the state of the given task by done of it.
+func (man *NoteManager) updateTask(id, task string, done func(bool) bool) (Task, error) {
+	man.mu.Lock()
+	defer man.mu.Unlock()
+	old, ok := man.notes[id]
+	if !ok {
+		return Task{}, ErrNotFound
+	}
+	t, ok := findTask(parseTasks(old.ID, old.Content), task)
+	if !ok {
+		return Task{}, ErrNoTask
+	}
+	t.Done = done(t.Done)
+	box := " "
+	if t.Done {
+		box = "x"
+	}
+	c := *old
+	c.Content = c.Content[:t.box] + box + c.Content[t.box+1:]
+	if err := man.save(&c); err != nil {
+		return Task{}, err
+	}
+	return t.Task, nil
+}
+
+// OpenTasks returns the tasks left to do in the notes matching the tag
+// query q, see Query, or in every note if q is empty. Tasks are ordered by
+// note ID and number.
+func (man *NoteManager) OpenTasks(q string) ([]Task, error) {
+	var tq tagQuery
+	if q != "" {
+		var err error
+		if tq, err = parseTagQuery(q); err != nil {
+			return nil, err
+		}
+	}
+	man.mu.RLock()
+	defer man.mu.RUnlock()
+	var set map[string]bool
+	if tq != nil {
+		set = tq.eval(man)
+	}
+	ts := []Task{}
+	for id, n := range man.notes {
+		if set != nil && !set[id] {
+			continue
+		}
+		for _, t := range parseTasks(id, n.Content) {
+			if !t.Done {
+				ts = append(ts, t.Task)
+			}
+		}
+	}
+	sort.Slice(ts, func(i, j int) bool {
+		if ts[i].Note != ts[j].Note {
+			return ts[i].Note < ts[j].Note
+		}
+		return ts[i].N < ts[j].N
+	})
+	return ts, nil
+}
@@ -0,0 +1,130 @@
+package notes
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseTasks(t *testing.T) {
+	content := "# Trip #todo\n- [ ] book flight\n  * [x] pack #home\n- not a task\n1. [X] visa\n```\n- [ ] in code\n```\n- [ ]\n- [y] nope"
+	var got []Task
+	for _, it := range parseTasks("a", content) {
+		got = append(got, it.Task)
+		if c := content[it.box]; c != ' ' && c != 'x' && c != 'X' {
+			t.Errorf("expected the box of task %d to point inside the brackets, got %q", it.N, c)
+		}
+	}
+	expected := []Task{
+		{"a", taskID("book flight", 0), 1, "book flight", false},
+		{"a", taskID("pack #home", 0), 2, "pack #home", true},
+		{"a", taskID("visa", 0), 3, "visa", true},
+		{"a", taskID("", 0), 4, "", false},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected tasks %v, got %v", expected, got)
+	}
+}
+
+func TestTaskIDs(t *testing.T) {
+	ids := func(content string) []string {
+		var ids []string
+		for _, it := range parseTasks("a", content) {
+			ids = append(ids, it.ID)
+		}
+		return ids
+	}
+	before := ids("- [ ] milk\n- [ ] call\n- [ ] call")
+	if before[1] == before[2] {
+		t.Errorf("expected tasks with the same text to have distinct IDs, got %v", before)
+	}
+	after := ids("- [ ] eggs\n- [x] milk\n- [ ] call\n- [ ] call")
+	if !reflect.DeepEqual(after[1:], before) {
+		t.Errorf("expected IDs %v to be kept as a task is added and checked, got %v", before, after[1:])
+	}
+}
+
+func TestToggleTask(t *testing.T) {
+	man := NewNoteManager()
+	note := &Note{ID: "aaaa0001", Content: "- [ ] milk\n- [ ] bread"}
+	if err := man.Save(note); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	task, err := man.ToggleTask(note.ID, "2")
+	if err != nil {
+		t.Fatalf("toggle: %v", err)
+	}
+	if !task.Done || task.N != 2 || task.Text != "bread" {
+		t.Errorf("expected bread to be done, got %+v", task)
+	}
+	if _, err := man.SetTask(note.ID, "1", true); err != nil {
+		t.Fatalf("set task: %v", err)
+	}
+	if _, err := man.ToggleTask(note.ID, task.ID); err != nil {
+		t.Fatalf("toggle: %v", err)
+	}
+	n, _ := man.Find(note.ID)
+	if expected := "- [x] milk\n- [ ] bread"; n.Content != expected {
+		t.Errorf("expected content %q, got %q", expected, n.Content)
+	}
+	if revs, _ := man.Revisions(note.ID); len(revs) != 4 {
+		t.Errorf("expected every toggle to be a revision, got %v", revs)
+	}
+
+	for _, task := range []string{"3", "0", "two", taskID("eggs", 0)} {
+		if _, err := man.ToggleTask(note.ID, task); err != ErrNoTask {
+			t.Errorf("toggle %q: expected ErrNoTask, got %v", task, err)
+		}
+	}
+	if _, err := man.ToggleTask("missing", "1"); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestToggleTaskByID(t *testing.T) {
+	man := NewNoteManager()
+	editOrFatal(t, man, "aaaa0001", "- [ ] milk\n- [ ] bread")
+	ts, _ := man.Tasks("aaaa0001")
+	editOrFatal(t, man, "aaaa0001", "- [ ] eggs\n- [ ] milk\n- [ ] bread")
+	task, err := man.ToggleTask("aaaa0001", ts[1].ID)
+	if err != nil {
+		t.Fatalf("toggle: %v", err)
+	}
+	if task.N != 3 || task.Text != "bread" || !task.Done {
+		t.Errorf("expected bread, now third, to be done, got %+v", task)
+	}
+	n, _ := man.Find("aaaa0001")
+	if expected := "- [ ] eggs\n- [ ] milk\n- [x] bread"; n.Content != expected {
+		t.Errorf("expected content %q, got %q", expected, n.Content)
+	}
+}
+
+func TestOpenTasks(t *testing.T) {
+	man := NewNoteManager()
+	for _, n := range []*Note{
+		{ID: "aaaa0001", Content: "#work\n- [ ] report\n- [x] meeting"},
+		{ID: "aaaa0002", Content: "#home\n- [ ] dishes"},
+		{ID: "aaaa0003", Content: "- [ ] call #work/clients"},
+	} {
+		if err := man.Save(n); err != nil {
+			t.Fatalf("save: %v", err)
+		}
+	}
+	for q, expected := range map[string][]Task{
+		"":          {{"aaaa0001", taskID("report", 0), 1, "report", false}, {"aaaa0002", taskID("dishes", 0), 1, "dishes", false}, {"aaaa0003", taskID("call #work/clients", 0), 1, "call #work/clients", false}},
+		"#work":     {{"aaaa0001", taskID("report", 0), 1, "report", false}},
+		"#work/*":   {{"aaaa0001", taskID("report", 0), 1, "report", false}, {"aaaa0003", taskID("call #work/clients", 0), 1, "call #work/clients", false}},
+		"NOT #work": {{"aaaa0002", taskID("dishes", 0), 1, "dishes", false}, {"aaaa0003", taskID("call #work/clients", 0), 1, "call #work/clients", false}},
+		"#nothing":  {},
+	} {
+		got, err := man.OpenTasks(q)
+		if err != nil {
+			t.Fatalf("open tasks %q: %v", q, err)
+		}
+		if !reflect.DeepEqual(got, expected) {
+			t.Errorf("open tasks %q: expected %v, got %v", q, expected, got)
+		}
+	}
+	if _, err := man.OpenTasks("#work AND"); err == nil {
+		t.Errorf("expected a malformed query to fail")
+	}
+}
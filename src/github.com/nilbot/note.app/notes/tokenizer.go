@@ -0,0 +1,188 @@
+package notes
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// CaseMode selects how a Tokenizer folds the case of tags.
+type CaseMode int
+
+const (
+	// KeepCase leaves tags as written, #Todo and #todo are distinct.
+	KeepCase CaseMode = iota
+	// LowerCase lower cases tags, #Todo and #todo are the same tag.
+	LowerCase
+)
+
+// Tokenizer finds the hashtags of a note.
+//
+// A hashtag is a # followed by letters, digits, marks, _ and -, with at
+// least one letter, that does not directly follow a letter or a digit.
+// Tags may be organised in hierarchies by separating levels with a /, as in
+// #project/api/auth.
+// Hashtags inside code spans, fenced code blocks and URLs are ignored, so
+// `#include` and http://example.com/#anchor are not tags.
+type Tokenizer struct {
+	Case CaseMode
+}
+
+// DefaultTokenizer is used by a NoteManager until SetTokenizer is called.
+var DefaultTokenizer = &Tokenizer{Case: KeepCase}
+
+// tagSpan is a hashtag found in a note. Start is the offset of the # and
+// End the offset just after the tag in the content.
+type tagSpan struct {
+	Tag        string
+	Start, End int
+}
+
+// Tags returns the hashtags of content, without the #, in order of
+// appearance. A tag appearing several times is returned several times.
+func (t *Tokenizer) Tags(content string) []string {
+	var a []string
+	for _, s := range t.spans(content) {
+		a = append(a, s.Tag)
+	}
+	return a
+}
+
+// spans scans content for hashtags.
+func (t *Tokenizer) spans(content string) []tagSpan {
+	if !strings.ContainsRune(content, '#') {
+		return nil
+	}
+	var res []tagSpan
+	prev := ' '
+	lineStart := true
+	fenced := false
+	for i := 0; i < len(content); {
+		if lineStart {
+			lineStart = false
+			if isFence(content[i:]) {
+				fenced = !fenced
+				// the fence line itself holds no tags, and its backticks
+				// don't start a code span.
+				end := strings.IndexByte(content[i:], '\n')
+				if end < 0 {
+					return res
+				}
+				i, prev = i+end, ' '
+				continue
+			}
+		}
+		r, size := utf8.DecodeRuneInString(content[i:])
+		switch {
+		case r == '\n':
+			lineStart = true
+		case fenced:
+		case r == '`':
+			if end := codeSpanEnd(content, i); end > i {
+				i, prev = end, '`'
+				continue
+			}
+		case isWordRune(r) && !isWordRune(prev) && isURL(content[i:]):
+			end := strings.IndexFunc(content[i:], unicode.IsSpace)
+			if end < 0 {
+				return res
+			}
+			i, prev = i+end, ' '
+			continue
+		case r == '#' && !isWordRune(prev):
+			end := i + 1
+			for end < len(content) {
+				c, n := utf8.DecodeRuneInString(content[end:])
+				if c == '/' {
+					// a / separates the levels of a hierarchical tag.
+					if next, _ := utf8.DecodeRuneInString(content[end+n:]); !isWordRune(next) || end == i+1 {
+						break
+					}
+				} else if !isTagRune(c) {
+					break
+				}
+				end += n
+			}
+			raw := strings.TrimRight(content[i+1:end], "-_")
+			end = i + 1 + len(raw)
+			if strings.IndexFunc(raw, unicode.IsLetter) >= 0 {
+				res = append(res, tagSpan{t.fold(raw), i, end})
+			}
+			// a # right after a tag starts another one.
+			i, prev = end, ' '
+			continue
+		}
+		prev = r
+		i += size
+	}
+	return res
+}
+
+// fold applies the case mode of t to tag.
+func (t *Tokenizer) fold(tag string) string {
+	if t.Case == LowerCase {
+		return strings.ToLower(tag)
+	}
+	return tag
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsMark(r)
+}
+
+func isTagRune(r rune) bool {
+	return isWordRune(r) || r == '_' || r == '-'
+}
+
+// isFence reports whether line opens or closes a fenced code block.
+func isFence(line string) bool {
+	line = strings.TrimLeft(line, " ")
+	return strings.HasPrefix(line, "```") || strings.HasPrefix(line, "~~~")
+}
+
+// codeSpanEnd returns the offset just after the code span opened by the
+// backticks at i, or i if they don't open one.
+func codeSpanEnd(content string, i int) int {
+	n := 0
+	for i+n < len(content) && content[i+n] == '`' {
+		n++
+	}
+	fence := content[i : i+n]
+	for at := i + n; at < len(content); {
+		j := strings.Index(content[at:], fence)
+		if j < 0 {
+			return i
+		}
+		j += at
+		// the closing run must be exactly as long as the opening one.
+		k := j + n
+		if k < len(content) && content[k] == '`' {
+			for k < len(content) && content[k] == '`' {
+				k++
+			}
+			at = k
+			continue
+		}
+		return k
+	}
+	return i
+}
+
+// isURL reports whether s starts with a URL, that is a scheme followed by
+// :// or www.
+func isURL(s string) bool {
+	if strings.HasPrefix(s, "www.") {
+		return true
+	}
+	for i, r := range s {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z':
+		case i > 0 && (r >= '0' && r <= '9' || r == '+' || r == '-' || r == '.'):
+		case i > 0 && r == ':':
+			return strings.HasPrefix(s[i:], "://")
+		default:
+			return false
+		}
+	}
+	return false
+}
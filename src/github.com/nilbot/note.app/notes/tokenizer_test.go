@@ -0,0 +1,104 @@
+package notes
+
+import (
+	"strings"
+	"testing"
+	"unicode"
+	"unicode/utf8"
+)
+
+func TestTokenizerTags(t *testing.T) {
+	tests := []struct {
+		content string
+		want    []string
+	}{
+		{"no tags at all", nil},
+		{"test a new note with a single hashtag #test, without linebreaks etc.", []string{"test"}},
+		{"#todo", []string{"todo"}},
+		{"#todo!", []string{"todo"}},
+		{"(#work)", []string{"work"}},
+		{"a #tag, #tag2. #tag3: #tag4; \"#tag5\"", []string{"tag", "tag2", "tag3", "tag4", "tag5"}},
+		{"tabs\t#one\tand\nnewlines\n#two\r\n#three", []string{"one", "two", "three"}},
+		{"#café au lait #über #日本語", []string{"café", "über", "日本語"}},
+		{"#cafe\u0301 decomposed", []string{"cafe\u0301"}},
+		{"#tag#tag2", []string{"tag", "tag2"}},
+		{"#snake_case #kebab-case #trailing- #trailing_", []string{"snake_case", "kebab-case", "trailing", "trailing"}},
+		{"#Todo keeps its case", []string{"Todo"}},
+		{"issue #42 is not a tag, #v2 is", []string{"v2"}},
+		{"mail me at me#home or a#b", nil},
+		{"# heading and ## subheading", nil},
+		{"#", nil},
+		{"inline `#include <stdio.h>` code #c", []string{"c"}},
+		{"double ``code with ` and #not`` #yes", []string{"yes"}},
+		{"unclosed ` #tag", []string{"tag"}},
+		{"```\n#not a tag\n```\n#tag", []string{"tag"}},
+		{"~~~go\n// #nope\n~~~\nafter #fence", []string{"fence"}},
+		{"```\na\n```\n#tag\n```\nb\n```", []string{"tag"}},
+		{"see https://example.com/page#anchor and #real", []string{"real"}},
+		{"http://example.com/#frag", nil},
+		{"www.example.com/#frag #real", []string{"real"}},
+		{"ftp+ssh://host/#x", nil},
+		{"not:a://url #ok", []string{"ok"}},
+		{"#dup and #dup", []string{"dup", "dup"}},
+	}
+	for _, tt := range tests {
+		got := DefaultTokenizer.Tags(tt.content)
+		if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+			t.Errorf("%q: expected %q, got %q", tt.content, tt.want, got)
+		}
+	}
+}
+
+func TestTokenizerLowerCase(t *testing.T) {
+	tok := &Tokenizer{Case: LowerCase}
+	got := tok.Tags("#Todo #TODO #Éclair")
+	want := []string{"todo", "todo", "éclair"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
+
+func TestSetTokenizer(t *testing.T) {
+	man := NewNoteManager()
+	man.Save(&Note{ID: "a", Content: "#Todo"})
+	man.Save(&Note{ID: "b", Content: "#todo"})
+	if ids, _ := man.NotesWith("todo"); len(ids) != 1 {
+		t.Errorf("expected 1 note tagged %q, got %v", "todo", ids)
+	}
+	man.SetTokenizer(&Tokenizer{Case: LowerCase})
+	if ids, _ := man.NotesWith("TODO"); len(ids) != 2 {
+		t.Errorf("expected 2 notes tagged %q, got %v", "TODO", ids)
+	}
+	if tags := man.AllTags(); len(tags) != 1 {
+		t.Errorf("expected 1 tag, got %v", tags)
+	}
+}
+
+func FuzzTokenizer(f *testing.F) {
+	for _, s := range []string{
+		"#todo!", "(#work)", "#café", "#tag#tag2", "`#x` #y",
+		"```\n#a\n```", "http://x/#y", "#a-_-", "#\u0301",
+	} {
+		f.Add(s)
+	}
+	tok := &Tokenizer{}
+	f.Fuzz(func(t *testing.T, content string) {
+		for _, s := range tok.spans(content) {
+			if s.Start < 0 || s.End > len(content) || s.Start >= s.End {
+				t.Fatalf("%q: bad span %v", content, s)
+			}
+			if content[s.Start] != '#' || content[s.Start+1:s.End] != s.Tag {
+				t.Fatalf("%q: span %v doesn't match the content", content, s)
+			}
+			if !utf8.ValidString(s.Tag) && utf8.ValidString(content) {
+				t.Fatalf("%q: invalid tag %q", content, s.Tag)
+			}
+			if strings.IndexFunc(s.Tag, unicode.IsLetter) < 0 {
+				t.Fatalf("%q: tag %q without letters", content, s.Tag)
+			}
+			if strings.ContainsAny(s.Tag, "# \t\n") {
+				t.Fatalf("%q: tag %q with separators", content, s.Tag)
+			}
+		}
+	})
+}
@@ -0,0 +1,417 @@
+package notes
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// SyncPolicy controls when the write-ahead log is flushed to stable storage.
+type SyncPolicy int
+
+const (
+	// SyncAlways flushes the log after every record.
+	SyncAlways SyncPolicy = iota
+	// SyncInterval flushes the log at most once per WALOptions.SyncInterval.
+	SyncInterval
+	// SyncNever leaves flushing to the operating system.
+	SyncNever
+)
+
+// WALOptions configures a write-ahead log store.
+type WALOptions struct {
+	Sync         SyncPolicy
+	SyncInterval time.Duration
+	// CompactEvery is the number of records after which the log is
+	// compacted into a snapshot. Zero disables compaction.
+	CompactEvery int
+}
+
+const (
+	walFile      = "wal.log"
+	snapshotFile = "snapshot"
+	// frameHeader is the size of the length and checksum preceding a record.
+	frameHeader = 8
+)
+
+// ErrCorrupt is returned when a record before the end of the log is damaged.
+var ErrCorrupt = errors.New("corrupt write-ahead log")
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// walStore logs every change as a checksummed record before applying it.
+// Records are framed as a little-endian uint32 payload length, a CRC-32C of
+// the payload and the JSON encoded payload.
+type walStore struct {
+	dir     string
+	opt     WALOptions
+	f       *os.File
+	size    int64
+	notes   map[string]*Note
+	history map[string][]Revision
+	// tombstones holds the versions of the deleted notes.
+	tombstones map[string][]string
+	records    int
+	lastSync   time.Time
+}
+
+// OpenWALStore opens, or creates, a write-ahead log store in dir.
+// The latest snapshot and the log are replayed; a torn final record, as left
+// by a crash in the middle of a write, is discarded.
+func OpenWALStore(dir string, opt WALOptions) (Store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	s := &walStore{
+		dir:        dir,
+		opt:        opt,
+		notes:      make(map[string]*Note),
+		history:    make(map[string][]Revision),
+		tombstones: make(map[string][]string),
+	}
+	if err := s.replaySnapshot(); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(filepath.Join(dir, walFile), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	n, good, err := replay(f, s.apply)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := f.Truncate(good); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err := f.Seek(good, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	s.f = f
+	s.size = good
+	s.records = n
+	s.lastSync = time.Now()
+	return s, nil
+}
+
+func (s *walStore) replaySnapshot() error {
+	f, err := os.Open(filepath.Join(s.dir, snapshotFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	// snapshots are renamed into place once complete, so any damage is fatal.
+	_, good, err := replay(f, s.apply)
+	if err == nil && good != st.Size() {
+		err = ErrCorrupt
+	}
+	return err
+}
+
+func (s *walStore) apply(rec record) error {
+	switch rec.Op {
+	case "put":
+		if rec.Note == nil {
+			return fmt.Errorf("put without note")
+		}
+		s.notes[rec.Note.ID] = rec.Note
+		delete(s.tombstones, rec.Note.ID)
+		// a revision already held was replayed on top of the snapshot
+		// holding it, after a crash during compaction.
+		if h := s.history[rec.Note.ID]; rec.Rev != nil && (rec.Rev.Rev < 1 || rec.Rev.Rev > len(h)) {
+			s.history[rec.Note.ID] = append(h, *rec.Rev)
+		}
+	case "delete":
+		delete(s.notes, rec.ID)
+		delete(s.history, rec.ID)
+		if rec.Versions != nil {
+			s.tombstones[rec.ID] = rec.Versions
+		}
+	case "history":
+		s.history[rec.ID] = rec.History
+	case "batch":
+		for _, r := range rec.Batch {
+			if err := s.apply(r); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("unknown op %q", rec.Op)
+	}
+	return nil
+}
+
+// replay reads records from r and applies them in order. It returns the
+// number of records and the offset of the end of the last intact record.
+// A damaged record is only tolerated if it is the last one in r.
+func replay(r io.Reader, apply func(record) error) (int, int64, error) {
+	br := bufio.NewReader(r)
+	var n int
+	var good int64
+	for {
+		payload, err := readFrame(br)
+		if err == io.EOF {
+			return n, good, nil
+		}
+		if err == io.ErrUnexpectedEOF {
+			// a short record only means a torn write if no intact record
+			// follows it, otherwise its length is damaged.
+			if !holdsFrame(payload) {
+				return n, good, nil
+			}
+			return n, good, fmt.Errorf("%v at offset %d: bad record length", ErrCorrupt, good)
+		}
+		if err == ErrCorrupt {
+			// a bad checksum only means a torn write if nothing follows it.
+			if _, err := br.Peek(1); err == io.EOF {
+				return n, good, nil
+			}
+			return n, good, fmt.Errorf("%v at offset %d", ErrCorrupt, good)
+		}
+		if err != nil {
+			return n, good, err
+		}
+		var rec record
+		if err := json.Unmarshal(payload, &rec); err != nil {
+			return n, good, fmt.Errorf("%v at offset %d: %v", ErrCorrupt, good, err)
+		}
+		if err := apply(rec); err != nil {
+			return n, good, fmt.Errorf("offset %d: %v", good, err)
+		}
+		n++
+		good += int64(frameHeader + len(payload))
+	}
+}
+
+func readFrame(r io.Reader) ([]byte, error) {
+	var hdr [frameHeader]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, err
+	}
+	size := binary.LittleEndian.Uint32(hdr[0:4])
+	sum := binary.LittleEndian.Uint32(hdr[4:8])
+	// the buffer grows with the data actually read, so a damaged length
+	// cannot make us allocate more than what is left in the log.
+	var buf bytes.Buffer
+	if n, err := io.CopyN(&buf, r, int64(size)); err != nil {
+		if err == io.EOF && n < int64(size) {
+			// what is left of r is returned, to tell a torn write from
+			// a damaged length.
+			return buf.Bytes(), io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	payload := buf.Bytes()
+	if crc32.Checksum(payload, crcTable) != sum {
+		return nil, ErrCorrupt
+	}
+	return payload, nil
+}
+
+// holdsFrame reports whether an intact frame starts anywhere in b.
+// Payloads are JSON objects, which narrows down where frames may start.
+func holdsFrame(b []byte) bool {
+	for i := 0; i+frameHeader < len(b); i++ {
+		if b[i+frameHeader] != '{' {
+			continue
+		}
+		size := int64(binary.LittleEndian.Uint32(b[i : i+4]))
+		if size > int64(len(b)-i-frameHeader) {
+			continue
+		}
+		payload := b[i+frameHeader : i+frameHeader+int(size)]
+		if crc32.Checksum(payload, crcTable) == binary.LittleEndian.Uint32(b[i+4:i+8]) {
+			return true
+		}
+	}
+	return false
+}
+
+// writeFrame writes rec to w and returns the number of bytes written.
+func writeFrame(w io.Writer, rec record) (int, error) {
+	payload, err := json.Marshal(rec)
+	if err != nil {
+		return 0, err
+	}
+	buf := make([]byte, frameHeader+len(payload))
+	binary.LittleEndian.PutUint32(buf[0:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(buf[4:8], crc32.Checksum(payload, crcTable))
+	copy(buf[frameHeader:], payload)
+	return w.Write(buf)
+}
+
+func (s *walStore) Load() ([]*Note, error) {
+	v := make([]*Note, 0, len(s.notes))
+	for _, n := range s.notes {
+		v = append(v, n)
+	}
+	return v, nil
+}
+
+func (s *walStore) Put(n *Note, r *Revision) error {
+	return s.append(record{Op: "put", Note: n, Rev: r})
+}
+
+func (s *walStore) PutAll(ns []*Note, rs []*Revision) error {
+	return s.append(batch(ns, rs))
+}
+
+func (s *walStore) ResetAll(ns []*Note, rs []*Revision) error {
+	return s.append(reset(ns, rs))
+}
+
+func (s *walStore) Delete(id string, versions []string) error {
+	return s.append(record{Op: "delete", ID: id, Versions: versions})
+}
+
+func (s *walStore) LoadHistory() (map[string][]Revision, error) {
+	h := make(map[string][]Revision, len(s.history))
+	for id, revs := range s.history {
+		h[id] = append([]Revision(nil), revs...)
+	}
+	return h, nil
+}
+
+func (s *walStore) LoadTombstones() (map[string][]string, error) {
+	t := make(map[string][]string, len(s.tombstones))
+	for id, vs := range s.tombstones {
+		t[id] = vs
+	}
+	return t, nil
+}
+
+// append writes rec to the log, applies it and compacts the log if it grew
+// past the configured number of records.
+func (s *walStore) append(rec record) error {
+	n, err := writeFrame(s.f, rec)
+	if err != nil {
+		// drop whatever part of the record made it, so that later records
+		// do not follow a damaged one.
+		s.f.Truncate(s.size)
+		s.f.Seek(s.size, io.SeekStart)
+		return err
+	}
+	s.size += int64(n)
+	if err := s.sync(false); err != nil {
+		return err
+	}
+	if err := s.apply(rec); err != nil {
+		return err
+	}
+	s.records++
+	if s.opt.CompactEvery > 0 && s.records >= s.opt.CompactEvery {
+		return s.compact()
+	}
+	return nil
+}
+
+// sync flushes the log according to the sync policy, or unconditionally if
+// force is set.
+func (s *walStore) sync(force bool) error {
+	switch {
+	case force, s.opt.Sync == SyncAlways:
+	case s.opt.Sync == SyncInterval && time.Since(s.lastSync) >= s.opt.SyncInterval:
+	default:
+		return nil
+	}
+	s.lastSync = time.Now()
+	return s.f.Sync()
+}
+
+// compact writes every note into a new snapshot and empties the log.
+// The snapshot is written to a temporary file and renamed into place, so a
+// crash leaves either the old or the new snapshot, followed by a log whose
+// records can safely be replayed again: notes are replaced and revisions
+// the snapshot already holds are skipped by apply.
+func (s *walStore) compact() error {
+	tmp := filepath.Join(s.dir, snapshotFile+".tmp")
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	err = s.writeSnapshot(w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(s.dir, snapshotFile)); err != nil {
+		return err
+	}
+	if err := syncDir(s.dir); err != nil {
+		return err
+	}
+	if err := s.f.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := s.f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	s.size = 0
+	s.records = 0
+	return s.sync(true)
+}
+
+// writeSnapshot writes a put record for every note followed by a record
+// holding its revisions, and a delete record for every tombstone.
+func (s *walStore) writeSnapshot(w io.Writer) error {
+	for id, n := range s.notes {
+		if _, err := writeFrame(w, record{Op: "put", Note: n}); err != nil {
+			return err
+		}
+		rec := record{Op: "history", ID: id, History: s.history[id]}
+		if _, err := writeFrame(w, rec); err != nil {
+			return err
+		}
+	}
+	for id, vs := range s.tombstones {
+		if _, err := writeFrame(w, record{Op: "delete", ID: id, Versions: vs}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// syncDir flushes the directory entry changes of dir.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+func (s *walStore) Close() error {
+	if err := s.sync(true); err != nil {
+		s.f.Close()
+		return err
+	}
+	return s.f.Close()
+}
@@ -0,0 +1,270 @@
+package notes
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func openWALManagerOrFatal(t *testing.T, dir string, opt WALOptions) *NoteManager {
+	s, err := OpenWALStore(dir, opt)
+	if err != nil {
+		t.Fatalf("open wal store: %v", err)
+	}
+	man, err := OpenNoteManager(s)
+	if err != nil {
+		t.Fatalf("open note manager: %v", err)
+	}
+	return man
+}
+
+// saveAllOrFatal saves a note for every content and returns the notes.
+func saveAllOrFatal(t *testing.T, man *NoteManager, contents ...string) []*Note {
+	var ns []*Note
+	for _, c := range contents {
+		n := newNoteOrFatal(t, c)
+		if err := man.Save(n); err != nil {
+			t.Fatalf("save: %v", err)
+		}
+		ns = append(ns, n)
+	}
+	return ns
+}
+
+// truncateOrFatal cuts the last n bytes off the file at path.
+func truncateOrFatal(t *testing.T, path string, n int64) {
+	st, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("stat: %v", err)
+	}
+	if err := os.Truncate(path, st.Size()-n); err != nil {
+		t.Fatalf("truncate: %v", err)
+	}
+}
+
+func TestWALReload(t *testing.T) {
+	dir := tempDirOrFatal(t)
+	defer os.RemoveAll(dir)
+
+	man := openWALManagerOrFatal(t, dir, WALOptions{})
+	ns := saveAllOrFatal(t, man, "buy bread #todo", "call mum #todo #family")
+	man.Close()
+
+	man = openWALManagerOrFatal(t, dir, WALOptions{})
+	defer man.Close()
+	for _, n := range ns {
+		if got, ok := man.Find(n.ID); !ok || *got != *n {
+			t.Errorf("expected %v, got %v", n, got)
+		}
+	}
+	if ids, ok := man.NotesWith("todo"); !ok || len(ids) != 2 {
+		t.Errorf("expected 2 notes tagged %q, got %v", "todo", ids)
+	}
+}
+
+func TestWALTornRecord(t *testing.T) {
+	for _, cut := range []int64{1, 5, frameHeader + 3} {
+		dir := tempDirOrFatal(t)
+		defer os.RemoveAll(dir)
+
+		man := openWALManagerOrFatal(t, dir, WALOptions{})
+		ns := saveAllOrFatal(t, man, "first #a", "second #b", "third, a longer note #c")
+		man.Close()
+		truncateOrFatal(t, filepath.Join(dir, walFile), cut)
+
+		man = openWALManagerOrFatal(t, dir, WALOptions{})
+		if all := man.AllNotes(); len(all) != 2 {
+			t.Errorf("cut %d: expected 2 notes, got %v", cut, len(all))
+		}
+		if _, ok := man.Find(ns[2].ID); ok {
+			t.Errorf("cut %d: expected torn note to be dropped", cut)
+		}
+		// the torn record must be gone so new records are readable.
+		last := saveAllOrFatal(t, man, "fourth #d")[0]
+		man.Close()
+
+		man = openWALManagerOrFatal(t, dir, WALOptions{})
+		if _, ok := man.Find(last.ID); !ok {
+			t.Errorf("cut %d: expected note written after recovery", cut)
+		}
+		man.Close()
+	}
+}
+
+func TestWALTornChecksum(t *testing.T) {
+	dir := tempDirOrFatal(t)
+	defer os.RemoveAll(dir)
+
+	man := openWALManagerOrFatal(t, dir, WALOptions{})
+	saveAllOrFatal(t, man, "first #a", "second #b")
+	man.Close()
+
+	// flip the last byte of the final record.
+	path := filepath.Join(dir, walFile)
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	st, _ := f.Stat()
+	f.WriteAt([]byte{'x'}, st.Size()-1)
+	f.Close()
+
+	man = openWALManagerOrFatal(t, dir, WALOptions{})
+	defer man.Close()
+	if all := man.AllNotes(); len(all) != 1 {
+		t.Errorf("expected 1 note, got %v", len(all))
+	}
+}
+
+func TestWALCorruptMiddle(t *testing.T) {
+	dir := tempDirOrFatal(t)
+	defer os.RemoveAll(dir)
+
+	man := openWALManagerOrFatal(t, dir, WALOptions{})
+	saveAllOrFatal(t, man, "first #a", "second #b")
+	man.Close()
+
+	path := filepath.Join(dir, walFile)
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	f.WriteAt([]byte{'x'}, frameHeader+2)
+	f.Close()
+
+	_, err = OpenWALStore(dir, WALOptions{})
+	if err == nil || !strings.Contains(err.Error(), ErrCorrupt.Error()) {
+		t.Errorf("expected %v, got %v", ErrCorrupt, err)
+	}
+}
+
+func TestWALCorruptLength(t *testing.T) {
+	dir := tempDirOrFatal(t)
+	defer os.RemoveAll(dir)
+
+	man := openWALManagerOrFatal(t, dir, WALOptions{})
+	saveAllOrFatal(t, man, "first #a", "second #b", "third #c")
+	man.Close()
+
+	path := filepath.Join(dir, walFile)
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	f.WriteAt([]byte{0xff, 0xff, 0xff, 0x7f}, 0)
+	f.Close()
+
+	_, err = OpenWALStore(dir, WALOptions{})
+	if err == nil || !strings.Contains(err.Error(), ErrCorrupt.Error()) {
+		t.Errorf("expected %v, got %v", ErrCorrupt, err)
+	}
+}
+
+func TestWALCompaction(t *testing.T) {
+	dir := tempDirOrFatal(t)
+	defer os.RemoveAll(dir)
+
+	opt := WALOptions{Sync: SyncNever, CompactEvery: 3}
+	man := openWALManagerOrFatal(t, dir, opt)
+	ns := saveAllOrFatal(t, man, "one #a", "two #a", "three #b", "four #c")
+	man.Close()
+
+	if _, err := os.Stat(filepath.Join(dir, snapshotFile)); err != nil {
+		t.Fatalf("expected a snapshot, got %v", err)
+	}
+	s, err := OpenWALStore(dir, opt)
+	if err != nil {
+		t.Fatalf("open wal store: %v", err)
+	}
+	if records := s.(*walStore).records; records != 1 {
+		t.Errorf("expected 1 record left in the log, got %v", records)
+	}
+	man, err = OpenNoteManager(s)
+	if err != nil {
+		t.Fatalf("open note manager: %v", err)
+	}
+	defer man.Close()
+	for _, n := range ns {
+		if _, ok := man.Find(n.ID); !ok {
+			t.Errorf("expected to find %q after compaction", n.Content)
+		}
+	}
+	if ids, _ := man.NotesWith("a"); len(ids) != 2 {
+		t.Errorf("expected 2 notes tagged %q, got %v", "a", ids)
+	}
+}
+
+func TestWALCrashDuringCompaction(t *testing.T) {
+	dir := tempDirOrFatal(t)
+	defer os.RemoveAll(dir)
+
+	man := openWALManagerOrFatal(t, dir, WALOptions{})
+	n := saveAllOrFatal(t, man, "one #a")[0]
+	editOrFatal(t, man, n.ID, "two #a")
+	man.Close()
+	path := filepath.Join(dir, walFile)
+	log, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read log: %v", err)
+	}
+
+	// the snapshot is in place but the log wasn't emptied yet.
+	s, err := OpenWALStore(dir, WALOptions{})
+	if err != nil {
+		t.Fatalf("open wal store: %v", err)
+	}
+	if err := s.(*walStore).compact(); err != nil {
+		t.Fatalf("compact: %v", err)
+	}
+	s.Close()
+	if err := ioutil.WriteFile(path, log, 0644); err != nil {
+		t.Fatalf("write log: %v", err)
+	}
+
+	man = openWALManagerOrFatal(t, dir, WALOptions{})
+	defer man.Close()
+	if revs, _ := man.Revisions(n.ID); len(revs) != 2 {
+		t.Errorf("expected 2 revisions, got %+v", revs)
+	}
+}
+
+func TestWALSyncInterval(t *testing.T) {
+	dir := tempDirOrFatal(t)
+	defer os.RemoveAll(dir)
+
+	man := openWALManagerOrFatal(t, dir, WALOptions{Sync: SyncInterval, SyncInterval: 1})
+	n := saveAllOrFatal(t, man, "synced #a")[0]
+	man.Close()
+
+	man = openWALManagerOrFatal(t, dir, WALOptions{})
+	defer man.Close()
+	if _, ok := man.Find(n.ID); !ok {
+		t.Errorf("expected to find %q", n.Content)
+	}
+}
+
+func TestWALDelete(t *testing.T) {
+	dir := tempDirOrFatal(t)
+	defer os.RemoveAll(dir)
+
+	opt := WALOptions{CompactEvery: 4}
+	man := openWALManagerOrFatal(t, dir, opt)
+	ns := saveAllOrFatal(t, man, "keep #a", "gone #b", "also gone #c")
+	man.Delete(ns[1].ID)
+	// the second delete lands after compaction.
+	man.Delete(ns[2].ID)
+	man.Close()
+
+	man = openWALManagerOrFatal(t, dir, opt)
+	defer man.Close()
+	if all := man.AllNotes(); len(all) != 1 || all[0].ID != ns[0].ID {
+		t.Errorf("expected only %v, got %v", ns[0], all)
+	}
+	for _, n := range ns[1:] {
+		if vs := man.sync.tombstones[n.ID]; len(vs) != 1 || vs[0] != n.Digest {
+			t.Errorf("expected the tombstone of %q to be kept, got %v", n.Content, vs)
+		}
+	}
+}
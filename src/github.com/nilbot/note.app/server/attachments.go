@@ -0,0 +1,167 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/nilbot/note.app/notes"
+)
+
+// maxUploadSize limits the size of request bodies uploading attachments.
+const maxUploadSize = 256 << 20
+
+var attachments *notes.Attachments
+
+// UseBlobStore makes the handlers keep attachments in b instead of in
+// memory, and starts removing the blobs no note references. The notebooks
+// share b, so a blob is kept while any note of any notebook references
+// it. It must be called after UseStore and UseLibrary and before
+// RegisterHandlers. Stop the returned attachments to stop removing blobs.
+func UseBlobStore(b notes.BlobStore) *notes.Attachments {
+	attachments = notes.NewAttachments(man, b, notes.SystemClock)
+	if library != nil {
+		attachments.ReferencedBy(library.Managers)
+	}
+	attachments.Start()
+	return attachments
+}
+
+// attachmentError translates failures of the attachments of the note
+// manager into errors handled by errorHandler.
+func attachmentError(w http.ResponseWriter, err error) error {
+	var tooLarge *http.MaxBytesError
+	switch {
+	case err == notes.ErrNotFound || err == notes.ErrNoBlob:
+		return notFound{err}
+	case err == notes.ErrEncrypted:
+		return badRequest{err}
+	case errors.As(err, &tooLarge):
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return nil
+	}
+	return err
+}
+
+// UploadAttachments handles POST requests to /note/{ID}/attachments.
+// The request body must be multipart/form-data with one or more files,
+// which are attached to the note: a reference to every file is added to
+// the end of its content, as an image for images and as a link otherwise.
+// Files are stored by the sha512 of their content, so uploading a file
+// twice stores it once. Encrypted notes can't have attachments.
+//
+// Examples:
+//
+//   req: POST /note/abcdefg123/attachments (multipart/form-data; file screenshot.png)
+//   res: 200 [{"Note": "abcdefg123", "Hash": "9b71d2...", "Name": "screenshot.png",
+//          "Size": 48213, "Time": "2026-10-17T10:00:00Z"}]
+//
+//   req: POST /note/abcdefg123/attachments {"Content": "not a file"}
+//   res: 400 request Content-Type isn't multipart/form-data
+func UploadAttachments(w http.ResponseWriter, r *http.Request) error {
+	man := noteManager(r)
+	id, err := parseID(r)
+	if err != nil {
+		return badRequest{err}
+	}
+	n, err := resolve(man, id)
+	if err != nil {
+		return err
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return badRequest{err}
+	}
+	atts := []notes.Attachment{}
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				return attachmentError(w, err)
+			}
+			return badRequest{err}
+		}
+		if p.FileName() == "" {
+			continue
+		}
+		att, err := notebookAttachments(man).Attach(n.ID, p.FileName(), p)
+		if err != nil {
+			return attachmentError(w, err)
+		}
+		atts = append(atts, att)
+	}
+	if len(atts) == 0 {
+		return badRequest{fmt.Errorf("no file to attach")}
+	}
+	return json.NewEncoder(w).Encode(atts)
+}
+
+// ListAttachments handles GET requests to /note/{ID}/attachments.
+// It returns the attachments the note references, in order of appearance.
+//
+// Example:
+//
+//   req: GET /note/abcdefg123/attachments
+//   res: 200 [{"Note": "abcdefg123", "Hash": "9b71d2...", "Name": "screenshot.png",
+//          "Size": 48213, "Time": "2026-10-17T10:00:00Z"}]
+func ListAttachments(w http.ResponseWriter, r *http.Request) error {
+	man := noteManager(r)
+	id, err := parseID(r)
+	if err != nil {
+		return badRequest{err}
+	}
+	n, err := resolve(man, id)
+	if err != nil {
+		return err
+	}
+	atts, err := notebookAttachments(man).List(n.ID)
+	if err != nil {
+		return attachmentError(w, err)
+	}
+	return json.NewEncoder(w).Encode(atts)
+}
+
+// GetAttachment handles GET requests to /note/{ID}/attachments/{Hash}.
+// It returns the attachment, which the note must reference. Range requests
+// are supported, as are conditional requests with the hash as ETag. The
+// attachment can't run scripts, even if it is an HTML or SVG document.
+//
+// Examples:
+//
+//   req: GET /note/abcdefg123/attachments/9b71d2... (Range: bytes=0-1023)
+//   res: 206 (the first KiB of the attachment)
+//
+//   req: GET /note/abcdefg124/attachments/9b71d2...
+//   res: 404 note not found
+func GetAttachment(w http.ResponseWriter, r *http.Request) error {
+	man := noteManager(r)
+	id, err := parseID(r)
+	if err != nil {
+		return badRequest{err}
+	}
+	n, err := resolve(man, id)
+	if err != nil {
+		return err
+	}
+	rc, att, err := notebookAttachments(man).Open(n.ID, mux.Vars(r)["hash"])
+	if err != nil {
+		return attachmentError(w, err)
+	}
+	defer rc.Close()
+	w.Header().Set("ETag", `"`+att.Hash+`"`)
+	w.Header().Set("Content-Security-Policy", "sandbox")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if att.Name != "" {
+		w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", att.Name))
+	}
+	http.ServeContent(w, r, att.Name, att.Time, rc)
+	return nil
+}
@@ -0,0 +1,124 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/nilbot/note.app/notes"
+)
+
+// EncryptionPath is where the passphrase of encrypted notes is rotated.
+const EncryptionPath = "/encryption"
+
+// encryptionError translates failures of the encryption API of the note
+// manager into errors handled by errorHandler. A wrong passphrase is
+// replied to directly, as forbidden.
+func encryptionError(w http.ResponseWriter, err error) error {
+	switch err {
+	case notes.ErrNotFound:
+		return notFound{err}
+	case notes.ErrWrongPassphrase:
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return nil
+	case notes.ErrEmptyPassphrase, notes.ErrEncrypted, notes.ErrNotEncrypted, notes.ErrInvalidTag, notes.ErrUnsupportedKDF:
+		return badRequest{err}
+	}
+	return err
+}
+
+// EncryptNote handles POST requests to /note/{ID}/encrypt.
+// The request body must contain a JSON object with a Passphrase, the key of
+// the note is derived from. The content of the note, or the Content given
+// in the request if any, is encrypted and only the given public Tags are
+// kept in clear and indexed. An encrypted note is edited by giving its new
+// Content. The passphrase isn't kept. Revisions saved before the note was
+// encrypted are dropped, so its content isn't left in clear in its history.
+//
+// Examples:
+//
+//   req: POST /note/abcdefg123/encrypt {"Passphrase": "open sesame", "Tags": ["ops"]}
+//   res: 200 {"ID": "abcdefg123", "Content": "#ops\n-----BEGIN ENCRYPTED NOTE-----\n...", ...}
+//
+//   req: POST /note/abcdefg123/encrypt {"Passphrase": "open sesame"}
+//   res: 400 note is encrypted
+func EncryptNote(w http.ResponseWriter, r *http.Request) error {
+	man := noteManager(r)
+	id, err := parseID(r)
+	if err != nil {
+		return badRequest{err}
+	}
+	req := struct {
+		Passphrase string
+		Content    *string
+		Tags       []string
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return badRequest{err}
+	}
+	n, err := resolve(man, id)
+	if err != nil {
+		return err
+	}
+	if req.Content != nil {
+		n.Content = *req.Content
+	}
+	if err := man.SaveEncrypted(n, req.Passphrase, req.Tags); err != nil {
+		return encryptionError(w, err)
+	}
+	return json.NewEncoder(w).Encode(n)
+}
+
+// DecryptNote handles POST requests to /note/{ID}/decrypt.
+// The request body must contain a JSON object with the Passphrase of the
+// note, which is returned with its content decrypted. The note stays
+// encrypted; saving the decrypted note with PUT stores it unencrypted.
+//
+// Examples:
+//
+//   req: POST /note/abcdefg123/decrypt {"Passphrase": "open sesame"}
+//   res: 200 {"ID": "abcdefg123", "Content": "vpn password hunter2", ...}
+//
+//   req: POST /note/abcdefg123/decrypt {"Passphrase": "open barley"}
+//   res: 403 wrong passphrase
+func DecryptNote(w http.ResponseWriter, r *http.Request) error {
+	man := noteManager(r)
+	id, err := parseID(r)
+	if err != nil {
+		return badRequest{err}
+	}
+	req := struct{ Passphrase string }{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return badRequest{err}
+	}
+	n, err := resolve(man, id)
+	if err != nil {
+		return err
+	}
+	n, err = man.Decrypt(n.ID, req.Passphrase)
+	if err != nil {
+		return encryptionError(w, err)
+	}
+	return json.NewEncoder(w).Encode(n)
+}
+
+// RotateKey handles POST requests to /encryption/rotate.
+// The request body must contain a JSON object with the Old and the New
+// passphrase. Every note encrypted with the old passphrase is encrypted
+// again with the new one and the current key derivation parameters.
+//
+// Example:
+//
+//   req: POST /encryption/rotate {"Old": "open sesame", "New": "correct horse"}
+//   res: 200 {"Rotated": ["abcdefg123", "abcdefg124"]}
+func RotateKey(w http.ResponseWriter, r *http.Request) error {
+	man := noteManager(r)
+	req := struct{ Old, New string }{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return badRequest{err}
+	}
+	ids, err := man.RotateKey(req.Old, req.New)
+	if err != nil {
+		return encryptionError(w, err)
+	}
+	return json.NewEncoder(w).Encode(struct{ Rotated []string }{ids})
+}
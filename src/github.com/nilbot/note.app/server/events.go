@@ -0,0 +1,60 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/nilbot/note.app/notes"
+)
+
+// EventsPath is where changes of the notes are streamed.
+const EventsPath = "/events"
+
+// eventBuffer is the number of events buffered for every client of
+// StreamEvents, which is disconnected if it falls further behind.
+const eventBuffer = 64
+
+// StreamEvents handles GET requests to /events.
+// It streams the changes of the notes as server-sent events until the client
+// goes away, or falls too far behind.
+//
+// Example:
+//
+//   req: GET /events
+//   res: 200 event: created
+//          data: {"Kind": "created", "Before": null, "After": {"ID": "abcdefg123", "Content": "Buy milk #todo", ...}, "Tags": ["todo"]}
+//
+//          event: deleted
+//          data: {"Kind": "deleted", "Before": {"ID": "abcdefg123", ...}, "After": null, "Tags": ["todo"]}
+func StreamEvents(w http.ResponseWriter, r *http.Request) error {
+	man := noteManager(r)
+	f, ok := w.(http.Flusher)
+	if !ok {
+		return fmt.Errorf("streaming not supported")
+	}
+	sub := man.Subscribe(eventBuffer, notes.Disconnect)
+	defer sub.Close()
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	f.Flush()
+	for {
+		select {
+		case e, ok := <-sub.Events():
+			if !ok {
+				return nil
+			}
+			data, err := json.Marshal(e)
+			if err != nil {
+				return err
+			}
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Kind, data); err != nil {
+				return nil
+			}
+			f.Flush()
+		case <-r.Context().Done():
+			return nil
+		}
+	}
+}
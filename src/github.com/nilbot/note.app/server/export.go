@@ -0,0 +1,106 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/nilbot/note.app/notes"
+)
+
+// ExportPath and ImportPath are where notes are exported and imported.
+const (
+	ExportPath = "/export"
+	ImportPath = "/import"
+)
+
+// formats maps the format query parameter to the content type and file name
+// of the format.
+var formats = map[notes.Format]struct{ contentType, file string }{
+	notes.Markdown:  {"application/zip", "notes.zip"},
+	notes.JSONLines: {"application/x-ndjson", "notes.jsonl"},
+	notes.ENEX:      {"application/enex+xml", "notes.enex"},
+}
+
+// parseFormat returns the format given in the format query parameter of r,
+// JSON Lines if there is none.
+func parseFormat(r *http.Request) (notes.Format, error) {
+	f := notes.Format(r.FormValue("format"))
+	if f == "" {
+		return notes.JSONLines, nil
+	}
+	if _, ok := formats[f]; !ok {
+		return "", fmt.Errorf("unknown format %q, want markdown, jsonl or enex", f)
+	}
+	return f, nil
+}
+
+// ExportNotes handles GET requests to /export.
+// It returns every note as a download in the format given by the format
+// parameter: markdown for a zip of Markdown files with front matter, jsonl
+// for JSON Lines, which is the default, or enex for Evernote.
+//
+// Examples:
+//
+//   req: GET /export?format=jsonl
+//   res: 200 {"ID":"abcdefg123","Content":"buy milk #todo",...}
+//            {"ID":"abcdefg124","Content":"call mum",...}
+//
+//   req: GET /export?format=csv
+//   res: 400 unknown format "csv", want markdown, jsonl or enex
+func ExportNotes(w http.ResponseWriter, r *http.Request) error {
+	man := noteManager(r)
+	f, err := parseFormat(r)
+	if err != nil {
+		return badRequest{err}
+	}
+	w.Header().Set("Content-Type", formats[f].contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", formats[f].file))
+	if err := man.Export(w, f); err != nil {
+		// the response has started, so it can only be cut short.
+		log.Printf("export: %v", err)
+	}
+	return nil
+}
+
+// ImportNotes handles POST requests to /import.
+// The request body must contain notes in the format given by the format
+// parameter, as for ExportNotes. Notes replace the ones with the same ID,
+// notes without an ID, as from Evernote, are created. Nothing is imported if
+// the body can't be read.
+//
+// Examples:
+//
+//   req: POST /import?format=enex <en-export><note>...</note></en-export>
+//   res: 200 {"Imported": ["5e0c2f..."]}
+//
+//   req: POST /import?format=jsonl {"ID": "abcdefg123", "Content": ...
+//   res: 400 import jsonl: line 1: unexpected end of JSON input
+//
+//   req: POST /import?format=jsonl {"ID": "a/b", "Content": "x"}
+//   res: 400 import jsonl: invalid note ID "a/b"
+//
+//   req: POST /import?format=markdown (a zip archive of over 256 MiB of notes)
+//   res: 413 import markdown: bomb.md: notes larger than the import limit
+func ImportNotes(w http.ResponseWriter, r *http.Request) error {
+	man := noteManager(r)
+	f, err := parseFormat(r)
+	if err != nil {
+		return badRequest{err}
+	}
+	res, err := man.Import(http.MaxBytesReader(w, r.Body, notes.MaxImportSize), f)
+	var tooLarge *http.MaxBytesError
+	if errors.Is(err, notes.ErrImportTooLarge) || errors.As(err, &tooLarge) {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return nil
+	}
+	if _, ok := err.(*notes.ImportError); ok {
+		return badRequest{err}
+	}
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(w).Encode(res)
+}
@@ -0,0 +1,164 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/nilbot/note.app/notes"
+)
+
+// parseRev obtains the rev variable from the given request url,
+// parses the obtained text and returns the result.
+func parseRev(r *http.Request) (int, error) {
+	txt, ok := mux.Vars(r)["rev"]
+	if !ok {
+		return 0, fmt.Errorf("revision not found")
+	}
+	return strconv.Atoi(txt)
+}
+
+// revisionError translates failures of the revision API of the note manager
+// into errors handled by errorHandler.
+func revisionError(err error) error {
+	if err == notes.ErrNotFound || err == notes.ErrNoRevision {
+		return notFound{err}
+	}
+	return err
+}
+
+// ListRevisions handles GET requests to /note/{ID}/revisions.
+// It returns the revisions of the note, oldest first.
+//
+// Example:
+//
+//   req: GET /note/abcdefg123/revisions
+//   res: 200 [
+//          {"Rev": 1, "Time": "2015-03-01T10:00:00Z", "Content": "Buy milk"},
+//          {"Rev": 2, "Time": "2015-03-01T11:00:00Z", "Content": "Buy milk #todo"}
+//          ]
+func ListRevisions(w http.ResponseWriter, r *http.Request) error {
+	man := noteManager(r)
+	id, err := parseID(r)
+	if err != nil {
+		return badRequest{err}
+	}
+	n, err := resolve(man, id)
+	if err != nil {
+		return err
+	}
+	revs, err := man.Revisions(n.ID)
+	if err != nil {
+		return revisionError(err)
+	}
+	return json.NewEncoder(w).Encode(revs)
+}
+
+// GetRevision handles GET requests to /note/{ID}/revisions/{Rev}.
+//
+// Examples:
+//
+//   req: GET /note/abcdefg123/revisions/1
+//   res: 200 {"Rev": 1, "Time": "2015-03-01T10:00:00Z", "Content": "Buy milk"}
+//
+//   req: GET /note/abcdefg123/revisions/42
+//   res: 404 note not found
+func GetRevision(w http.ResponseWriter, r *http.Request) error {
+	man := noteManager(r)
+	id, err := parseID(r)
+	if err != nil {
+		return badRequest{err}
+	}
+	rev, err := parseRev(r)
+	if err != nil {
+		return badRequest{err}
+	}
+	n, err := resolve(man, id)
+	if err != nil {
+		return err
+	}
+	res, err := man.Revision(n.ID, rev)
+	if err != nil {
+		return revisionError(err)
+	}
+	return json.NewEncoder(w).Encode(res)
+}
+
+// DiffRevisions handles GET requests to /note/{ID}/diff.
+// The from and to parameters select the revisions to compare; to defaults
+// to the latest revision and from to the one before to.
+//
+// Example:
+//
+//   req: GET /note/abcdefg123/diff?from=1&to=2
+//   res: 200 [{"Op": "-", "Text": "Buy milk"}, {"Op": "+", "Text": "Buy milk #todo"}]
+//
+//   req: GET /note/abcdefg124/diff (revisions of over 20000 lines together)
+//   res: 413 revisions too large to diff
+func DiffRevisions(w http.ResponseWriter, r *http.Request) error {
+	man := noteManager(r)
+	id, err := parseID(r)
+	if err != nil {
+		return badRequest{err}
+	}
+	n, err := resolve(man, id)
+	if err != nil {
+		return err
+	}
+	revs, err := man.Revisions(n.ID)
+	if err != nil {
+		return revisionError(err)
+	}
+	to := len(revs)
+	if v := r.FormValue("to"); v != "" {
+		if to, err = strconv.Atoi(v); err != nil {
+			return badRequest{err}
+		}
+	}
+	from := to - 1
+	if v := r.FormValue("from"); v != "" {
+		if from, err = strconv.Atoi(v); err != nil {
+			return badRequest{err}
+		}
+	}
+	d, err := man.Diff(n.ID, from, to)
+	if err == notes.ErrDiffTooLarge {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return nil
+	}
+	if err != nil {
+		return revisionError(err)
+	}
+	return json.NewEncoder(w).Encode(d)
+}
+
+// RevertNote handles POST requests to /note/{ID}/revisions/{Rev}/revert.
+// The content of the revision becomes the latest revision of the note,
+// which is returned.
+//
+// Example:
+//
+//   req: POST /note/abcdefg123/revisions/1/revert
+//   res: 200 {"ID": abcdefg123, "Content": "Buy milk"}
+func RevertNote(w http.ResponseWriter, r *http.Request) error {
+	man := noteManager(r)
+	id, err := parseID(r)
+	if err != nil {
+		return badRequest{err}
+	}
+	rev, err := parseRev(r)
+	if err != nil {
+		return badRequest{err}
+	}
+	n, err := resolve(man, id)
+	if err != nil {
+		return err
+	}
+	n, err = man.Revert(n.ID, rev)
+	if err != nil {
+		return revisionError(err)
+	}
+	return json.NewEncoder(w).Encode(n)
+}
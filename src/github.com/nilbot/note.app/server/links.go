@@ -0,0 +1,75 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/nilbot/note.app/notes"
+)
+
+// ListLinks handles GET requests to /note/{ID}/links.
+// It returns the [[links]] of the note with the ID of the note they resolve
+// to, which is empty for broken links.
+//
+// Example:
+//
+//   req: GET /note/abcdefg123/links
+//   res: 200 [{"Target": "Shopping list", "ID": "bcdefgh234"}, {"Target": "gone", "ID": ""}]
+func ListLinks(w http.ResponseWriter, r *http.Request) error {
+	man := noteManager(r)
+	id, err := parseID(r)
+	if err != nil {
+		return badRequest{err}
+	}
+	n, err := resolve(man, id)
+	if err != nil {
+		return err
+	}
+	links, err := man.Links(n.ID)
+	if err == notes.ErrNotFound {
+		return notFound{}
+	}
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(w).Encode(links)
+}
+
+// ListBacklinks handles GET requests to /note/{ID}/backlinks.
+// It returns the IDs of the notes linking to the note, by ID or by title.
+//
+// Example:
+//
+//   req: GET /note/bcdefgh234/backlinks
+//   res: 200 ["abcdefg123"]
+func ListBacklinks(w http.ResponseWriter, r *http.Request) error {
+	man := noteManager(r)
+	id, err := parseID(r)
+	if err != nil {
+		return badRequest{err}
+	}
+	n, err := resolve(man, id)
+	if err != nil {
+		return err
+	}
+	ids, err := man.Backlinks(n.ID)
+	if err == notes.ErrNotFound {
+		return notFound{}
+	}
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(w).Encode(ids)
+}
+
+// BrokenLinks handles GET requests to /links/broken.
+// It returns the links matching no note, or several notes by title.
+//
+// Example:
+//
+//   req: GET /links/broken
+//   res: 200 [{"Source": "abcdefg123", "Target": "gone"}]
+func BrokenLinks(w http.ResponseWriter, r *http.Request) error {
+	man := noteManager(r)
+	return json.NewEncoder(w).Encode(man.BrokenLinks())
+}
@@ -0,0 +1,313 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/nilbot/note.app/notes"
+)
+
+// NotebookPrefix is where notebooks are served, every route of the notes
+// of a notebook under NotebookPrefix+"{notebook}", such as
+// /nb/{notebook}/note/{id}.
+const NotebookPrefix = "/nb/"
+
+// UsersPath is where users are added.
+const UsersPath = "/users"
+
+// library holds the users and notebooks, nil until UseLibrary is called:
+// until then the notes are served to anyone, under PathPrefix only.
+var library *notes.Library
+
+// admin is the user who may add users, the owner of the default notebook.
+var admin string
+
+// UseLibrary makes the handlers serve the notebooks of l to its users,
+// who authenticate with HTTP basic authentication. The notes kept so far,
+// in the store given to UseStore, are migrated into the default notebook,
+// owned by owner, the first time. The routes under PathPrefix keep serving
+// the default notebook. It must be called after UseStore and before
+// UseBlobStore, StartReminders and RegisterHandlers.
+func UseLibrary(l *notes.Library, owner string) error {
+	from := store
+	if from == nil {
+		from = notes.NewMemoryStore()
+	}
+	if _, err := l.Migrate(from, owner); err != nil {
+		return fmt.Errorf("migrate notes: %v", err)
+	}
+	m, err := l.Manager(notes.DefaultNotebook)
+	if err != nil {
+		return err
+	}
+	if store != nil {
+		man.Close()
+	}
+	library, admin, man = l, owner, m
+	return nil
+}
+
+type contextKey int
+
+const (
+	userKey contextKey = iota
+	managerKey
+)
+
+// noteManager returns the manager of the notebook of the request, the
+// default one outside notebooks.
+func noteManager(r *http.Request) *notes.NoteManager {
+	if m, ok := r.Context().Value(managerKey).(*notes.NoteManager); ok {
+		return m
+	}
+	return man
+}
+
+// notebookAttachments returns the attachments of the notes of m, all of
+// them held in the same blob store.
+func notebookAttachments(m *notes.NoteManager) *notes.Attachments {
+	if m == man {
+		return attachments
+	}
+	return attachments.For(m)
+}
+
+// authenticate returns the user authenticated by the request. Otherwise
+// it replies with StatusUnauthorized and returns false.
+func authenticate(w http.ResponseWriter, r *http.Request) (string, bool) {
+	if user, ok := r.Context().Value(userKey).(string); ok {
+		return user, true
+	}
+	user, password, ok := r.BasicAuth()
+	if ok && library.Authenticate(user, password) == nil {
+		return user, true
+	}
+	w.Header().Set("WWW-Authenticate", `Basic realm="notes", charset="UTF-8"`)
+	http.Error(w, notes.ErrBadCredentials.Error(), http.StatusUnauthorized)
+	return "", false
+}
+
+// notebook returns the notebook of the request, the default one outside
+// NotebookPrefix, if the user has at least the given access to it.
+// Otherwise it replies with StatusNotFound, if the user can't read the
+// notebook, or StatusForbidden and returns false.
+func notebook(w http.ResponseWriter, r *http.Request, user string, need notes.Access) (notes.Notebook, bool) {
+	name, ok := mux.Vars(r)["notebook"]
+	if !ok {
+		name = notes.DefaultNotebook
+	}
+	nb, ok := library.Notebook(name)
+	switch a := nb.Access(user); {
+	case !ok || a == notes.NoAccess:
+		http.Error(w, notes.ErrNoNotebook.Error(), http.StatusNotFound)
+		return nb, false
+	case a < need:
+		http.Error(w, fmt.Sprintf("notebook %s is read only", name), http.StatusForbidden)
+		return nb, false
+	}
+	return nb, true
+}
+
+// inNotebook wraps a handler of notes to serve the notes of the notebook
+// of the request, to users with at least the given access to it.
+func inNotebook(need notes.Access, f func(w http.ResponseWriter, r *http.Request) error) func(w http.ResponseWriter, r *http.Request) error {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		if library == nil {
+			return f(w, r)
+		}
+		user, ok := authenticate(w, r)
+		if !ok {
+			return nil
+		}
+		nb, ok := notebook(w, r, user, need)
+		if !ok {
+			return nil
+		}
+		m, err := library.Manager(nb.Name)
+		if err != nil {
+			return err
+		}
+		ctx := context.WithValue(r.Context(), userKey, user)
+		ctx = context.WithValue(ctx, managerKey, m)
+		return f(w, r.WithContext(ctx))
+	}
+}
+
+// read and write wrap handlers reading and changing the notes of a
+// notebook.
+func read(f func(w http.ResponseWriter, r *http.Request) error) http.HandlerFunc {
+	return errorHandler(inNotebook(notes.ReadAccess, f))
+}
+
+func write(f func(w http.ResponseWriter, r *http.Request) error) http.HandlerFunc {
+	return errorHandler(inNotebook(notes.WriteAccess, f))
+}
+
+// notebookInfo is a notebook as served, with the access of the user.
+type notebookInfo struct {
+	notes.Notebook
+	Access notes.Access
+}
+
+// ListNotebooks handles GET requests to /nb/.
+// It returns the notebooks the user owns or which are shared with them,
+// ordered by name.
+//
+// Example:
+//
+//   req: GET /nb/
+//   res: 200 [{"Name": "default", "Owner": "alice", "Access": "read"},
+//          {"Name": "ops", "Owner": "bob", "Shares": {"alice": "write"}, "Access": "write"}]
+func ListNotebooks(w http.ResponseWriter, r *http.Request) error {
+	user, ok := authenticate(w, r)
+	if !ok {
+		return nil
+	}
+	res := []notebookInfo{}
+	for _, nb := range library.Notebooks(user) {
+		res = append(res, notebookInfo{nb, nb.Access(user)})
+	}
+	return json.NewEncoder(w).Encode(res)
+}
+
+// CreateNotebook handles POST requests to /nb/.
+// The request body must contain a JSON object with the Name of the new
+// notebook, owned by the user.
+//
+// Examples:
+//
+//   req: POST /nb/ {"Name": "ops"}
+//   res: 200 {"Name": "ops", "Owner": "bob", "Access": "write"}
+//
+//   req: POST /nb/ {"Name": "Ops Team"}
+//   res: 400 invalid name
+func CreateNotebook(w http.ResponseWriter, r *http.Request) error {
+	user, ok := authenticate(w, r)
+	if !ok {
+		return nil
+	}
+	req := struct{ Name string }{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return badRequest{err}
+	}
+	nb, err := library.CreateNotebook(req.Name, user)
+	switch err {
+	case nil:
+	case notes.ErrInvalidName:
+		return badRequest{err}
+	case notes.ErrNotebookExists:
+		http.Error(w, err.Error(), http.StatusConflict)
+		return nil
+	default:
+		return err
+	}
+	return json.NewEncoder(w).Encode(notebookInfo{nb, nb.Access(user)})
+}
+
+// GetNotebook handles GET requests to /nb/{notebook}.
+// It returns the notebook, if the user may read it.
+//
+// Examples:
+//
+//   req: GET /nb/ops
+//   res: 200 {"Name": "ops", "Owner": "bob", "Shares": {"alice": "write"}, "Access": "write"}
+//
+//   req: GET /nb/secret
+//   res: 404 notebook not found
+func GetNotebook(w http.ResponseWriter, r *http.Request) error {
+	user, ok := authenticate(w, r)
+	if !ok {
+		return nil
+	}
+	nb, ok := notebook(w, r, user, notes.ReadAccess)
+	if !ok {
+		return nil
+	}
+	return json.NewEncoder(w).Encode(notebookInfo{nb, nb.Access(user)})
+}
+
+// ShareNotebook handles PUT and DELETE requests to
+// /nb/{notebook}/shares/{user}. Only the owner of the notebook may share
+// it. The body of PUT requests must contain a JSON object with the Access
+// given to the user, read or write. DELETE requests stop sharing the
+// notebook with the user.
+//
+// Examples:
+//
+//   req: PUT /nb/ops/shares/alice {"Access": "read"}
+//   res: 200 {"Name": "ops", "Owner": "bob", "Shares": {"alice": "read"}, "Access": "write"}
+//
+//   req: DELETE /nb/ops/shares/alice
+//   res: 200 {"Name": "ops", "Owner": "bob", "Access": "write"}
+//
+//   req: PUT /nb/ops/shares/alice {"Access": "read"} (as alice)
+//   res: 403 only the owner of notebook ops may share it
+func ShareNotebook(w http.ResponseWriter, r *http.Request) error {
+	user, ok := authenticate(w, r)
+	if !ok {
+		return nil
+	}
+	nb, ok := notebook(w, r, user, notes.ReadAccess)
+	if !ok {
+		return nil
+	}
+	if nb.Owner != user {
+		http.Error(w, fmt.Sprintf("only the owner of notebook %s may share it", nb.Name), http.StatusForbidden)
+		return nil
+	}
+	req := struct{ Access notes.Access }{}
+	if r.Method == "PUT" {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return badRequest{err}
+		}
+	}
+	switch err := library.Share(nb.Name, mux.Vars(r)["user"], req.Access); err {
+	case nil:
+	case notes.ErrNoUser, notes.ErrShareOwner:
+		return badRequest{err}
+	default:
+		return err
+	}
+	nb, _ = library.Notebook(nb.Name)
+	return json.NewEncoder(w).Encode(notebookInfo{nb, nb.Access(user)})
+}
+
+// AddUser handles POST requests to /users.
+// The request body must contain a JSON object with the Name and Password
+// of the new user. Only the owner of the default notebook may add users.
+//
+// Examples:
+//
+//   req: POST /users {"Name": "carol", "Password": "correct horse"}
+//   res: 200 {"Name": "carol"}
+//
+//   req: POST /users {"Name": "carol", "Password": "again"}
+//   res: 409 user already exists
+func AddUser(w http.ResponseWriter, r *http.Request) error {
+	user, ok := authenticate(w, r)
+	if !ok {
+		return nil
+	}
+	if user != admin {
+		http.Error(w, "only "+admin+" may add users", http.StatusForbidden)
+		return nil
+	}
+	req := struct{ Name, Password string }{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return badRequest{err}
+	}
+	switch err := library.AddUser(req.Name, req.Password); err {
+	case nil:
+	case notes.ErrInvalidName, notes.ErrEmptyPassphrase:
+		return badRequest{err}
+	case notes.ErrUserExists:
+		http.Error(w, err.Error(), http.StatusConflict)
+		return nil
+	default:
+		return err
+	}
+	return json.NewEncoder(w).Encode(struct{ Name string }{req.Name})
+}
@@ -0,0 +1,163 @@
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/nilbot/note.app/notes"
+)
+
+var (
+	setup sync.Once
+	srv   *httptest.Server
+)
+
+// testServer serves, once for every test as handlers are registered on
+// http.DefaultServeMux, a library migrated from notes kept before
+// notebooks, with the default notebook owned by admin, and a notebook work
+// owned by alice, shared with bob for reading, and holding the note
+// aaaa0001.
+func testServer(t *testing.T) string {
+	setup.Do(func() {
+		kdf := notes.DefaultKDF
+		notes.DefaultKDF.Iterations = 1
+		defer func() { notes.DefaultKDF = kdf }()
+
+		legacy := notes.NewMemoryStore()
+		old, _ := notes.OpenNoteManager(legacy)
+		if err := old.Save(&notes.Note{ID: "legacy01", Content: "old note #legacy"}); err != nil {
+			t.Fatalf("save: %v", err)
+		}
+		if err := UseStore(legacy); err != nil {
+			t.Fatalf("use store: %v", err)
+		}
+		l := notes.NewLibrary()
+		for _, u := range []string{"admin", "alice", "bob", "carol"} {
+			if err := l.AddUser(u, u+"pw"); err != nil {
+				t.Fatalf("add user: %v", err)
+			}
+		}
+		if _, err := l.CreateNotebook("work", "alice"); err != nil {
+			t.Fatalf("create notebook: %v", err)
+		}
+		if err := l.Share("work", "bob", notes.ReadAccess); err != nil {
+			t.Fatalf("share: %v", err)
+		}
+		work, _ := l.Manager("work")
+		if err := work.Save(&notes.Note{ID: "aaaa0001", Content: "plan #work"}); err != nil {
+			t.Fatalf("save: %v", err)
+		}
+		if err := UseLibrary(l, "admin"); err != nil {
+			t.Fatalf("use library: %v", err)
+		}
+		RegisterHandlers()
+		srv = httptest.NewServer(http.DefaultServeMux)
+	})
+	if srv == nil {
+		t.Fatalf("expected the server to be set up")
+	}
+	return srv.URL
+}
+
+// do sends a request as user, anonymous if empty, with password
+// user+"pw", and returns the status and body of the response.
+func do(t *testing.T, user, method, path, body string) (int, string) {
+	req, err := http.NewRequest(method, testServer(t)+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	if user != "" {
+		req.SetBasicAuth(user, user+"pw")
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer res.Body.Close()
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	return res.StatusCode, string(b)
+}
+
+func TestUnauthenticated(t *testing.T) {
+	testServer(t)
+	for _, path := range []string{"/note/", "/note/legacy01", "/nb/", "/nb/work/note/aaaa0001", "/tags"} {
+		if status, _ := do(t, "", "GET", path, ""); status != http.StatusUnauthorized {
+			t.Errorf("GET %s: expected %d, got %d", path, http.StatusUnauthorized, status)
+		}
+	}
+	req, _ := http.NewRequest("GET", srv.URL+"/nb/work/note/aaaa0001", nil)
+	req.SetBasicAuth("alice", "wrong")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusUnauthorized || res.Header.Get("WWW-Authenticate") == "" {
+		t.Errorf("expected a wrong password to be challenged, got %d", res.StatusCode)
+	}
+}
+
+func TestReaderForbidden(t *testing.T) {
+	testServer(t)
+	for _, req := range []struct{ method, path, body string }{
+		{"PUT", "/nb/work/note/aaaa0001", `{"Content": "scrapped"}`},
+		{"DELETE", "/nb/work/note/aaaa0001", ""},
+		{"POST", "/nb/work/note/", `{"Content": "more"}`},
+		{"POST", "/nb/work/import?format=jsonl", `{"ID": "aaaa0001", "Content": "scrapped"}`},
+		{"POST", "/nb/work/tags/rename", `{"From": "work", "To": "play"}`},
+	} {
+		if status, _ := do(t, "bob", req.method, req.path, req.body); status != http.StatusForbidden {
+			t.Errorf("%s %s: expected %d, got %d", req.method, req.path, http.StatusForbidden, status)
+		}
+	}
+	status, body := do(t, "bob", "GET", "/nb/work/note/aaaa0001", "")
+	var n notes.Note
+	if status != http.StatusOK || json.Unmarshal([]byte(body), &n) != nil || n.Content != "plan #work" {
+		t.Errorf("expected the note to be read unchanged, got %d %s", status, body)
+	}
+}
+
+func TestNonMemberNotFound(t *testing.T) {
+	testServer(t)
+	for _, req := range []struct{ user, method, path string }{
+		{"carol", "GET", "/nb/work/note/aaaa0001"},
+		{"carol", "PUT", "/nb/work/note/aaaa0001"},
+		{"carol", "DELETE", "/nb/work/note/aaaa0001"},
+		{"carol", "GET", "/nb/missing/note/"},
+		{"bob", "GET", "/note/legacy01"},
+		{"alice", "DELETE", "/note/legacy01"},
+	} {
+		status, body := do(t, req.user, req.method, req.path, `{"Content": "scrapped"}`)
+		if status != http.StatusNotFound || strings.TrimSpace(body) != notes.ErrNoNotebook.Error() {
+			t.Errorf("%s %s as %s: expected %d, got %d %s", req.method, req.path, req.user, http.StatusNotFound, status, body)
+		}
+	}
+}
+
+func TestLegacyRoutes(t *testing.T) {
+	testServer(t)
+	status, body := do(t, "admin", "GET", "/note/legacy01", "")
+	if status != http.StatusOK || !strings.Contains(body, "old note #legacy") {
+		t.Errorf("expected the migrated note under %s, got %d %s", PathPrefix, status, body)
+	}
+	status, body = do(t, "admin", "POST", "/note/", `{"Content": "new note #legacy"}`)
+	var n notes.Note
+	if status != http.StatusOK || json.Unmarshal([]byte(body), &n) != nil {
+		t.Fatalf("post: got %d %s", status, body)
+	}
+	path := NotebookPrefix + notes.DefaultNotebook + PathPrefix + n.ID
+	if status, body = do(t, "admin", "GET", path, ""); status != http.StatusOK || !strings.Contains(body, "new note #legacy") {
+		t.Errorf("expected the note posted under %s in the default notebook, got %d %s", PathPrefix, status, body)
+	}
+	if status, body = do(t, "admin", "GET", "/note/%23legacy", ""); status != http.StatusOK || !strings.Contains(body, "legacy01") {
+		t.Errorf("expected the tags of the default notebook under %s, got %d %s", PathPrefix, status, body)
+	}
+}
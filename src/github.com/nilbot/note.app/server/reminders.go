@@ -0,0 +1,100 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nilbot/note.app/notes"
+)
+
+// RemindersPath is where upcoming reminders are served.
+const RemindersPath = "/reminders"
+
+// ParseNotifier returns the notifier described by spec, one of
+//
+//   log
+//   webhook:https://example.com/hook
+//   command:/usr/local/bin/notify-send --urgency=low
+func ParseNotifier(spec string) (notes.Notifier, error) {
+	switch {
+	case spec == "log":
+		return notes.LogNotifier{}, nil
+	case strings.HasPrefix(spec, "webhook:"):
+		return notes.WebhookNotifier{URL: strings.TrimPrefix(spec, "webhook:")}, nil
+	case strings.HasPrefix(spec, "command:"):
+		args := strings.Fields(strings.TrimPrefix(spec, "command:"))
+		if len(args) == 0 {
+			return nil, fmt.Errorf("notifier %q: missing command", spec)
+		}
+		return notes.CommandNotifier{Path: args[0], Args: args[1:]}, nil
+	}
+	return nil, fmt.Errorf("unknown notifier %q", spec)
+}
+
+// Reminders fires the reminders of the notes of every notebook.
+type Reminders struct {
+	mu         sync.Mutex
+	notifier   notes.Notifier
+	schedulers []*notes.Scheduler
+	stopped    bool
+}
+
+// schedule starts firing the reminders of the notes of m.
+func (r *Reminders) schedule(m *notes.NoteManager) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stopped {
+		return
+	}
+	s := notes.NewScheduler(m, r.notifier, notes.SystemClock)
+	s.Start()
+	r.schedulers = append(r.schedulers, s)
+}
+
+// Stop stops firing reminders.
+func (r *Reminders) Stop() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stopped = true
+	for _, s := range r.schedulers {
+		s.Stop()
+	}
+}
+
+// StartReminders starts firing the reminders of the notes to n. Once
+// UseLibrary is called, the notebooks are opened to fire the reminders of
+// all of them, including the notebooks created later. It must be called
+// after UseStore and UseLibrary. Stop the returned reminders to stop.
+func StartReminders(n notes.Notifier) (*Reminders, error) {
+	r := &Reminders{notifier: n}
+	if library == nil {
+		r.schedule(man)
+		return r, nil
+	}
+	library.Watch(func(name string, m *notes.NoteManager) {
+		r.schedule(m)
+	})
+	if _, err := library.Managers(); err != nil {
+		r.Stop()
+		return nil, err
+	}
+	return r, nil
+}
+
+// ListReminders handles GET requests to /reminders.
+// It returns the reminders still to fire, written as due: and remind: in
+// the notes, soonest first.
+//
+// Example:
+//
+//   req: GET /reminders
+//   res: 200 [{"Note": "abcdefg123", "Title": "Taxes #todo due:2026-11-01 remind:09:00",
+//          "Due": "2026-11-01T00:00:00+01:00", "At": "2026-11-01T09:00:00+01:00"}]
+func ListReminders(w http.ResponseWriter, r *http.Request) error {
+	man := noteManager(r)
+	return json.NewEncoder(w).Encode(man.Reminders(time.Now(), time.Local))
+}
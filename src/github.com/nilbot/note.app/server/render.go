@@ -0,0 +1,105 @@
+package server
+
+import (
+	"html/template"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/nilbot/note.app/notes"
+)
+
+// renderOptions links hashtags to the tag filter, notes to their HTML
+// rendering and attachments to their download, in the notebook of r.
+func renderOptions(r *http.Request) notes.RenderOptions {
+	prefix := PathPrefix
+	if nb, ok := mux.Vars(r)["notebook"]; ok {
+		prefix = NotebookPrefix + nb + PathPrefix
+	}
+	return notes.RenderOptions{
+		TagURL:  func(tag string) string { return prefix + "filter?q=" + url.QueryEscape("#"+tag) },
+		NoteURL: func(id string) string { return prefix + id + ".html" },
+		AttachmentURL: func(id, hash string) string {
+			return prefix + id + "/attachments/" + hash
+		},
+	}
+}
+
+var notePage = template.Must(template.New("note").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>{{.Title}}</title></head>
+<body>
+<article>
+{{.Body}}</article>
+</body>
+</html>
+`))
+
+// GetNoteHTML handles GET requests to /note/{ID}.html.
+// It returns the Markdown content of the note rendered as an HTML page,
+// with hashtags linking to the tag filter and [[links]] to the notes they
+// refer to. Raw HTML in the content is shown as text.
+//
+// Examples:
+//
+//   req: GET /note/abcdefg123.html
+//   res: 200 <!DOCTYPE html>...<h1>Groceries</h1>
+//          <p>Buy milk <a class="tag" href="/note/filter?q=%23todo">#todo</a></p>...
+//
+//   req: GET /note/4242424242.html
+//   res: 404 note not found
+func GetNoteHTML(w http.ResponseWriter, r *http.Request) error {
+	man := noteManager(r)
+	id, err := parseID(r)
+	if err != nil {
+		return badRequest{err}
+	}
+	n, err := resolve(man, id)
+	if err != nil {
+		return err
+	}
+	return renderNote(w, r, n)
+}
+
+func renderNote(w http.ResponseWriter, r *http.Request, n *notes.Note) error {
+	body, err := noteManager(r).Render(n.ID, renderOptions(r))
+	if err == notes.ErrNotFound {
+		return notFound{}
+	}
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	return notePage.Execute(w, struct {
+		Title string
+		Body  template.HTML
+	}{n.DisplayTitle(), template.HTML(body)})
+}
+
+// prefersHTML reports whether the Accept header of r ranks text/html above
+// application/json.
+func prefersHTML(r *http.Request) bool {
+	var html, json float64
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		typ, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		switch typ {
+		case "text/html":
+			html = q
+		case "application/json":
+			json = q
+		}
+	}
+	return html > json
+}
@@ -6,6 +6,8 @@ import (
 	"github.com/nilbot/note.app/notes"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/gorilla/mux"
 )
@@ -14,14 +16,93 @@ var man = notes.NewNoteManager()
 
 const PathPrefix = "/note/"
 
+// TagsPath is where the tag hierarchy is served.
+const TagsPath = "/tags"
+
+// LinksPath is where links between notes are reported.
+const LinksPath = "/links/"
+
+// store is the store given to UseStore, if any.
+var store notes.Store
+
+// UseStore makes the handlers persist notes in s instead of keeping them in
+// memory only. It must be called before RegisterHandlers.
+func UseStore(s notes.Store) error {
+	m, err := notes.OpenNoteManager(s)
+	if err != nil {
+		return err
+	}
+	man, store = m, s
+	return nil
+}
+
 func RegisterHandlers() {
+	if attachments == nil {
+		UseBlobStore(notes.NewMemoryBlobStore())
+	}
 	r := mux.NewRouter()
-	r.HandleFunc(PathPrefix, errorHandler(ListNotes)).Methods("GET")
-	r.HandleFunc(PathPrefix, errorHandler(NewNote)).Methods("POST")
-	r.HandleFunc(PathPrefix+"{id}", errorHandler(GetNote)).Methods("GET")
-	r.HandleFunc(PathPrefix+"{id}", errorHandler(UpdateNote)).Methods("PUT")
-	r.HandleFunc(PathPrefix+"#{tag}", errorHandler(Filter)).Methods("GET")
+	if library != nil {
+		r.HandleFunc(NotebookPrefix, errorHandler(ListNotebooks)).Methods("GET")
+		r.HandleFunc(NotebookPrefix, errorHandler(CreateNotebook)).Methods("POST")
+		r.HandleFunc(NotebookPrefix+"{notebook}", errorHandler(GetNotebook)).Methods("GET")
+		r.HandleFunc(NotebookPrefix+"{notebook}/shares/{user}", errorHandler(ShareNotebook)).Methods("PUT", "DELETE")
+		r.HandleFunc(UsersPath, errorHandler(AddUser)).Methods("POST")
+		addRoutes(r.PathPrefix(NotebookPrefix + "{notebook}").Subrouter())
+		http.Handle(NotebookPrefix, r)
+		http.Handle(UsersPath, r)
+	}
+	addRoutes(r)
 	http.Handle(PathPrefix, r)
+	http.Handle(TagsPath, r)
+	http.Handle(TagsPath+"/", r)
+	http.Handle(LinksPath, r)
+	http.Handle(TasksPath, r)
+	http.Handle(RemindersPath, r)
+	http.Handle(EventsPath, r)
+	http.Handle(SyncPath+"/peer", r)
+	http.Handle(SyncPath+"/changes", r)
+	http.Handle(EncryptionPath+"/rotate", r)
+	http.Handle(ExportPath, r)
+	http.Handle(ImportPath, r)
+}
+
+// addRoutes adds the routes of the notes of a notebook to r.
+func addRoutes(r *mux.Router) {
+	r.HandleFunc(PathPrefix, read(ListNotes)).Methods("GET")
+	r.HandleFunc(PathPrefix, write(NewNote)).Methods("POST")
+	r.HandleFunc(PathPrefix+"search", read(SearchNotes)).Methods("GET")
+	r.HandleFunc(PathPrefix+"filter", read(Filter)).Methods("GET")
+	r.HandleFunc(PathPrefix+"#{tag}", read(Filter)).Methods("GET")
+	r.HandleFunc(PathPrefix+"{id}.html", read(GetNoteHTML)).Methods("GET")
+	r.HandleFunc(PathPrefix+"{id}", read(GetNote)).Methods("GET")
+	r.HandleFunc(PathPrefix+"{id}", write(UpdateNote)).Methods("PUT")
+	r.HandleFunc(PathPrefix+"{id}", write(DeleteNote)).Methods("DELETE")
+	r.HandleFunc(PathPrefix+"{id}/revisions", read(ListRevisions)).Methods("GET")
+	r.HandleFunc(PathPrefix+"{id}/revisions/{rev}", read(GetRevision)).Methods("GET")
+	r.HandleFunc(PathPrefix+"{id}/revisions/{rev}/revert", write(RevertNote)).Methods("POST")
+	r.HandleFunc(PathPrefix+"{id}/diff", read(DiffRevisions)).Methods("GET")
+	r.HandleFunc(PathPrefix+"{id}/tasks", read(ListTasks)).Methods("GET")
+	r.HandleFunc(PathPrefix+"{id}/tasks/{n}", write(UpdateTask)).Methods("PATCH")
+	r.HandleFunc(PathPrefix+"{id}/encrypt", write(EncryptNote)).Methods("POST")
+	r.HandleFunc(PathPrefix+"{id}/decrypt", read(DecryptNote)).Methods("POST")
+	r.HandleFunc(PathPrefix+"{id}/attachments", write(UploadAttachments)).Methods("POST")
+	r.HandleFunc(PathPrefix+"{id}/attachments", read(ListAttachments)).Methods("GET")
+	r.HandleFunc(PathPrefix+"{id}/attachments/{hash}", read(GetAttachment)).Methods("GET", "HEAD")
+	r.HandleFunc(PathPrefix+"{id}/links", read(ListLinks)).Methods("GET")
+	r.HandleFunc(PathPrefix+"{id}/backlinks", read(ListBacklinks)).Methods("GET")
+	r.HandleFunc(LinksPath+"broken", read(BrokenLinks)).Methods("GET")
+	r.HandleFunc(TasksPath, read(OpenTasks)).Methods("GET")
+	r.HandleFunc(RemindersPath, read(ListReminders)).Methods("GET")
+	r.HandleFunc(EventsPath, read(StreamEvents)).Methods("GET")
+	r.HandleFunc(SyncPath+"/changes", read(SyncChanges)).Methods("GET")
+	r.HandleFunc(SyncPath+"/changes", write(SyncChanges)).Methods("POST")
+	r.HandleFunc(SyncPath+"/peer", write(SyncWithPeer)).Methods("POST")
+	r.HandleFunc(EncryptionPath+"/rotate", write(RotateKey)).Methods("POST")
+	r.HandleFunc(ExportPath, read(ExportNotes)).Methods("GET")
+	r.HandleFunc(ImportPath, write(ImportNotes)).Methods("POST")
+	r.HandleFunc(TagsPath, read(TagTree)).Methods("GET")
+	r.HandleFunc(TagsPath+"/rename", write(RenameTag)).Methods("POST")
+	r.HandleFunc(TagsPath+"/merge", write(MergeTags)).Methods("POST")
 }
 
 // badRequest is handled by setting the status code in the reply to StatusBadRequest.
@@ -52,41 +133,142 @@ func errorHandler(f func(w http.ResponseWriter, r *http.Request) error) http.Han
 }
 
 // ListNotes handles GET requests on /note.
-// There's no parameters and it returns an object with a Notes field containing a list of tasks.
+// It returns an object with a Notes field containing a page of notes, pinned
+// notes first, and a Next field with the cursor of the next page, if any.
+// Parameters:
+//   limit:    the maximum number of notes per page, all of them by default.
+//   cursor:   the Next field of the previous page.
+//   sort:     created, updated (default) or title.
+//   order:    asc or desc, desc by default except for titles.
+//   fields:   comma separated fields of the notes to return, all by default.
+//   archived: true to include archived notes.
 //
-// Example:
+// Examples:
 //
 //   req: GET /note/
 //   res: 200 {"Notes": [
-//          {"ID": ff5dsasd2, "Content": "Write a Note with #tag"},
-//          {"ID": abcdedfg1, "Content": "Buy bread #todo"}
+//          {"ID": ff5dsasd2, "Title": "Todo", "Content": "Write a Note with #tag", "Digest": "5e0c...",
+//           "CreatedAt": "2015-03-01T10:00:00Z", "UpdatedAt": "2015-03-02T09:30:00Z", "Pinned": true},
+//          {"ID": abcdedfg1, "Content": "Buy bread #todo", "Digest": "a41f...",
+//           "CreatedAt": "2015-03-01T11:00:00Z", "UpdatedAt": "2015-03-01T11:00:00Z"}
 //          ],
 //          "Tags": ["tag"]
 //          }
+//
+//   req: GET /note/?sort=title&limit=1&fields=ID,Title
+//   res: 200 {"Notes": [{"ID": ff5dsasd2, "Title": "Todo"}], "Tags": ["tag"], "Next": "eyJTb3J0..."}
+//
+//   req: GET /note/?sort=title&limit=1&fields=ID,Title&cursor=eyJTb3J0...
+//   res: 200 {"Notes": [{"ID": abcdedfg1, "Title": "Buy bread #todo"}], "Tags": ["tag"]}
+//
+//   req: GET /note/?sort=size
+//   res: 400 unknown sort key "size"
 func ListNotes(w http.ResponseWriter, r *http.Request) error {
+	man := noteManager(r)
+	opt := notes.ListOptions{
+		Sort:     notes.SortKey(r.FormValue("sort")),
+		Cursor:   r.FormValue("cursor"),
+		Archived: r.FormValue("archived") == "true",
+	}
+	if opt.Sort == "" {
+		opt.Sort = notes.SortUpdated
+	}
+	switch r.FormValue("order") {
+	case "asc":
+	case "desc":
+		opt.Desc = true
+	case "":
+		opt.Desc = opt.Sort != notes.SortTitle
+	default:
+		return badRequest{fmt.Errorf("unknown order %q", r.FormValue("order"))}
+	}
+	if l := r.FormValue("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil {
+			return badRequest{fmt.Errorf("bad limit %q", l)}
+		}
+		opt.Limit = n
+	}
+	var fields []string
+	if f := r.FormValue("fields"); f != "" {
+		fields = strings.Split(f, ",")
+		for _, f := range fields {
+			if _, ok := noteFields[f]; !ok {
+				return badRequest{fmt.Errorf("unknown field %q", f)}
+			}
+		}
+	}
+	ns, next, err := man.List(opt)
+	if err != nil {
+		return badRequest{err}
+	}
+	page := make([]interface{}, len(ns))
+	for i, n := range ns {
+		page[i] = n
+		if fields != nil {
+			page[i] = selectFields(n, fields)
+		}
+	}
 	res := struct {
-		Notes []*notes.Note
+		Notes []interface{}
 		Tags  []string
+		Next  string `json:",omitempty"`
 	}{
-		man.AllNotes(),
+		page,
 		man.AllTags(),
+		next,
 	}
 	return json.NewEncoder(w).Encode(res)
 }
 
+// noteFields gives the value of every field of a note by name.
+var noteFields = map[string]func(n *notes.Note) interface{}{
+	"ID":        func(n *notes.Note) interface{} { return n.ID },
+	"Title":     func(n *notes.Note) interface{} { return n.DisplayTitle() },
+	"Content":   func(n *notes.Note) interface{} { return n.Content },
+	"Digest":    func(n *notes.Note) interface{} { return n.Digest },
+	"CreatedAt": func(n *notes.Note) interface{} { return n.CreatedAt },
+	"UpdatedAt": func(n *notes.Note) interface{} { return n.UpdatedAt },
+	"Pinned":    func(n *notes.Note) interface{} { return n.Pinned },
+	"Archived":  func(n *notes.Note) interface{} { return n.Archived },
+}
+
+// selectFields returns the given fields of n, the title defaulting to the
+// first line of the content.
+func selectFields(n *notes.Note, fields []string) map[string]interface{} {
+	res := make(map[string]interface{}, len(fields))
+	for _, f := range fields {
+		res[f] = noteFields[f](n)
+	}
+	return res
+}
+
 // NewNote handles POST requests on /note.
-// The request body must contain a JSON object with a Content field.
-// The status code of the response is used to indicate any error.
+// The request body must contain a JSON object with a Content field and may
+// contain Title, Pinned and Archived fields. With a Passphrase the note is
+// encrypted from the start, with its public Tags, as by EncryptNote.
+// The status code of the response is used to indicate any error, on success
+// the new note is returned with its generated ID and timestamps.
 //
 // Examples:
 //
 //   req: POST /note/ {"Content": ""}
 //   res: 400 empty content
 //
-//   req: POST /note/ {"Content": "Buy milk"}
-//   res: 200
+//   req: POST /note/ {"Content": "Buy milk", "Pinned": true}
+//   res: 200 {"ID": "014c2f0a9e6b3d5f1a2b3c4d", "Content": "Buy milk", "Digest": "f5a1...",
+//          "CreatedAt": "2015-03-01T10:00:00Z", "UpdatedAt": "2015-03-01T10:00:00Z", "Pinned": true}
+//
+//   req: POST /note/ {"Content": "vpn password hunter2", "Passphrase": "open sesame", "Tags": ["ops"]}
+//   res: 200 {"ID": "014c2f0a9e6b3d5f1a2b3c4e", "Content": "#ops\n-----BEGIN ENCRYPTED NOTE-----\n...", ...}
 func NewNote(w http.ResponseWriter, r *http.Request) error {
-	req := struct{ Content string }{}
+	man := noteManager(r)
+	req := struct {
+		Title, Content   string
+		Pinned, Archived bool
+		Passphrase       string
+		Tags             []string
+	}{}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		return badRequest{err}
 	}
@@ -94,7 +276,17 @@ func NewNote(w http.ResponseWriter, r *http.Request) error {
 	if err != nil {
 		return badRequest{err}
 	}
-	return man.Save(t)
+	t.Title, t.Pinned, t.Archived = req.Title, req.Pinned, req.Archived
+	if req.Passphrase != "" {
+		if err := man.SaveEncrypted(t, req.Passphrase, req.Tags); err != nil {
+			return encryptionError(w, err)
+		}
+		return json.NewEncoder(w).Encode(t)
+	}
+	if err := man.Save(t); err != nil {
+		return err
+	}
+	return json.NewEncoder(w).Encode(t)
 }
 
 // parseID obtains the id variable from the given request url,
@@ -118,68 +310,269 @@ func parseHashtag(r *http.Request) (string, error) {
 }
 
 // GetNote handles GET requsts to /note/{ID}.
-// There's no parameters and it returns a JSON encoded note.
+// There's no parameters and it returns a JSON encoded note, or the note
+// rendered as GetNoteHTML does if the Accept header prefers text/html.
+// The ID may be abbreviated to any unambiguous prefix.
 //
 // Examples:
 //
 //   req: GET /note/abcdefg123
 //   res: 200 {"ID": abcdefg123, "Content": "Buy milk"}
 //
+//   req: GET /note/abcd
+//   res: 200 {"ID": abcdefg123, "Content": "Buy milk"}
+//
 //   req: GET /note/4242424242
 //   res: 404 note not found
+//
+//   req: GET /note/42ab
+//   res: 400 ambiguous ID "42ab", candidates: 42ab0001, 42ab0002
+//
+//   req: GET /note/abcd Accept: text/html
+//   res: 200 <!DOCTYPE html>...<p>Buy milk</p>...
 func GetNote(w http.ResponseWriter, r *http.Request) error {
+	man := noteManager(r)
 	id, err := parseID(r)
 	log.Println("Note is ", id)
 	if err != nil {
 		return badRequest{err}
 	}
-	n, ok := man.Find(id)
-	log.Println("Found", ok)
-
-	if !ok {
-		return notFound{}
+	n, err := resolve(man, id)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Vary", "Accept")
+	if prefersHTML(r) {
+		return renderNote(w, r, n)
 	}
 	return json.NewEncoder(w).Encode(n)
 }
 
+// resolve finds the note with the given, possibly abbreviated, ID and
+// translates lookup failures into errors handled by errorHandler.
+func resolve(man *notes.NoteManager, id string) (*notes.Note, error) {
+	n, err := man.Resolve(id)
+	if err == notes.ErrNotFound {
+		return nil, notFound{}
+	}
+	if _, ok := err.(*notes.AmbiguousIDError); ok {
+		return nil, badRequest{err}
+	}
+	return n, err
+}
+
 // UpdateNote handles PUT requests to /note/{ID}.
-// The request body must contain a JSON encoded note.
+// The ID may be abbreviated to any unambiguous prefix. The request body
+// must contain a JSON object with any of the ID, Title, Content, Pinned and
+// Archived fields of a note; fields left out keep their value, even if they
+// are changed by a concurrent request. The ID may be left out, the digest
+// and timestamps are maintained by the server and ignored.
 //
 // Example:
 //
 //   req: PUT /note/1234abcd {"ID": 1234abcd, "Content": "rewrite note"}
 //   res: 200
 //
+//   req: PUT /note/1234abcd {"Title": "Draft", "Archived": true}
+//   res: 200
+//
 //   req: PUT /note/42 {"ID": 42, "Content": "Write anything"}
 //   res: 400 inconsistent note IDs
 func UpdateNote(w http.ResponseWriter, r *http.Request) error {
+	man := noteManager(r)
 	id, err := parseID(r)
 	if err != nil {
 		return badRequest{err}
 	}
-	var n notes.Note
-	if err := json.NewDecoder(r.Body).Decode(&n); err != nil {
+	req := struct {
+		ID               string
+		Title, Content   *string
+		Pinned, Archived *bool
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return badRequest{err}
+	}
+	for {
+		old, err := resolve(man, id)
+		if err != nil {
+			return err
+		}
+		if req.ID != "" && req.ID != old.ID {
+			return badRequest{fmt.Errorf("inconsistent note IDs")}
+		}
+		n := *old
+		if req.Title != nil {
+			n.Title = *req.Title
+		}
+		if req.Content != nil {
+			n.Content = *req.Content
+		}
+		if req.Pinned != nil {
+			n.Pinned = *req.Pinned
+		}
+		if req.Archived != nil {
+			n.Archived = *req.Archived
+		}
+		// the fields left out are read again if the note changed since.
+		switch err := man.SaveIf(&n, old); err {
+		case notes.ErrNoteChanged:
+		case notes.ErrNotFound:
+			return notFound{}
+		default:
+			return err
+		}
+	}
+}
+
+// DeleteNote handles DELETE requests to /note/{ID}.
+// The ID may be abbreviated to any unambiguous prefix.
+//
+// Examples:
+//
+//   req: DELETE /note/abcdefg123
+//   res: 200
+//
+//   req: DELETE /note/4242424242
+//   res: 404 note not found
+func DeleteNote(w http.ResponseWriter, r *http.Request) error {
+	man := noteManager(r)
+	id, err := parseID(r)
+	if err != nil {
 		return badRequest{err}
 	}
-	if n.ID != id {
-		return badRequest{fmt.Errorf("inconsistent note IDs")}
+	n, err := resolve(man, id)
+	if err != nil {
+		return err
 	}
-	if _, ok := man.Find(id); !ok {
+	err = man.Delete(n.ID)
+	if err == notes.ErrNotFound {
 		return notFound{}
 	}
-	return man.Save(&n)
+	return err
 }
 
-// Filter with tag handles GET requests to /note/#{Tag}.
+// SearchNotes handles GET requests to /note/search.
+// The q parameter holds the query: words, "quoted phrases" and prefix*
+// words, all of which must match. It returns the matching notes, best
+// first, with their score and a snippet highlighting the matches.
+//
+// Examples:
+//
+//   req: GET /note/search?q=milk
+//   res: 200 [{"Note": {"ID": abcdefg123, "Content": "Buy milk"},
+//          "Score": 0.28, "Snippet": "Buy <mark>milk</mark>"}]
+//
+//   req: GET /note/search?q="buy
+//   res: 400 unterminated phrase in query
+func SearchNotes(w http.ResponseWriter, r *http.Request) error {
+	man := noteManager(r)
+	res, err := man.Search(r.FormValue("q"))
+	if err != nil {
+		return badRequest{err}
+	}
+	return json.NewEncoder(w).Encode(res)
+}
+
+// TagTree handles GET requests to /tags.
+// It returns the hierarchy of /-separated tags with, for every level, the
+// number of notes tagged with it and with it or any descendant.
+//
+// Example:
+//
+//   req: GET /tags
+//   res: 200 [{"Name": "project", "Path": "project", "Count": 1, "Total": 2,
+//          "Children": [{"Name": "api", "Path": "project/api", "Count": 1, "Total": 1}]
+//          }]
+func TagTree(w http.ResponseWriter, r *http.Request) error {
+	man := noteManager(r)
+	return json.NewEncoder(w).Encode(man.TagTree())
+}
+
+// RenameTag handles POST requests to /tags/rename.
+// The request body must contain a JSON object with From and To fields, with
+// DryRun set nothing is changed. Descendants of From are renamed too. It
+// returns the notes whose content changes, or would change.
+//
+// Examples:
+//
+//   req: POST /tags/rename {"From": "bugs", "To": "bug", "DryRun": true}
+//   res: 200 [{"ID": abcdefg123, "Before": "Fix it #bugs", "After": "Fix it #bug"}]
+//
+//   req: POST /tags/rename {"From": "bugs", "To": "not a tag"}
+//   res: 400 invalid tag
+func RenameTag(w http.ResponseWriter, r *http.Request) error {
+	man := noteManager(r)
+	req := struct {
+		From, To string
+		DryRun   bool
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return badRequest{err}
+	}
+	return mergeTags(w, man, []string{req.From}, req.To, req.DryRun)
+}
+
+// MergeTags handles POST requests to /tags/merge.
+// It works as RenameTag, with a list of tags to merge in From.
+//
+// Example:
+//
+//   req: POST /tags/merge {"From": ["bugs", "bugz"], "To": "bug"}
+//   res: 200 [{"ID": abcdefg123, "Before": "Fix it #bugz", "After": "Fix it #bug"}]
+func MergeTags(w http.ResponseWriter, r *http.Request) error {
+	man := noteManager(r)
+	req := struct {
+		From   []string
+		To     string
+		DryRun bool
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return badRequest{err}
+	}
+	return mergeTags(w, man, req.From, req.To, req.DryRun)
+}
+
+func mergeTags(w http.ResponseWriter, man *notes.NoteManager, from []string, to string, dryRun bool) error {
+	changes, err := man.MergeTags(from, to, dryRun)
+	if err == notes.ErrInvalidTag {
+		return badRequest{err}
+	}
+	if err != nil {
+		return err
+	}
+	if changes == nil {
+		changes = []notes.TagChange{}
+	}
+	return json.NewEncoder(w).Encode(changes)
+}
+
+// Filter handles GET requests to /note/filter and /note/#{Tag}.
+// The q parameter of /note/filter is a boolean query over tags, combining
+// them with AND, OR, NOT and parentheses; #tag/* also matches the
+// descendants of a hierarchical tag. It returns the matching notes.
+//
+// Examples:
+//
+//   req: GET /note/filter?q=(#bug OR #incident) AND #prod AND NOT #done
+//   res: 200 [{"ID": abcdefg123, "Content": "Fix login #bug #prod"}]
+//
+//   req: GET /note/filter?q=#bug AND
+//   res: 400 tag query "#bug AND": unexpected end of query at offset 8
+//
+//   req: GET /note/%23todo
+//   res: 200 [{"ID": abcdefg123, "Content": "Buy milk #todo"}]
 func Filter(w http.ResponseWriter, r *http.Request) error {
+	man := noteManager(r)
+	q := r.FormValue("q")
 	tag, err := parseHashtag(r)
-	log.Println("Tag is", tag)
+	if err == nil {
+		q = "#" + tag
+	}
+	ns, err := man.Query(q)
 	if err != nil {
 		return badRequest{err}
 	}
-	ns, ok := man.NotesWith(tag)
-	log.Println("Found", ok)
-	if !ok {
+	if tag != "" && len(ns) == 0 {
 		return notFound{}
 	}
 	return json.NewEncoder(w).Encode(ns)
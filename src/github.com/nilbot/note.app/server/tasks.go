@@ -0,0 +1,126 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/nilbot/note.app/notes"
+)
+
+// TasksPath is where the open tasks of every note are served.
+const TasksPath = "/tasks"
+
+// parseTask obtains the n variable, the ID or number of a task, from the
+// given request url.
+func parseTask(r *http.Request) (string, error) {
+	task, ok := mux.Vars(r)["n"]
+	if !ok {
+		return "", fmt.Errorf("task not found")
+	}
+	return task, nil
+}
+
+// ListTasks handles GET requests to /note/{ID}/tasks.
+// It returns the - [ ] and - [x] items of the note, numbered from 1, with
+// their IDs, which unlike their numbers are kept as other tasks are added
+// or removed.
+//
+// Example:
+//
+//   req: GET /note/abcdefg123/tasks
+//   res: 200 [
+//          {"Note": "abcdefg123", "ID": "520743e3", "N": 1, "Text": "Buy milk", "Done": true},
+//          {"Note": "abcdefg123", "ID": "5d133608", "N": 2, "Text": "Buy bread", "Done": false}
+//          ]
+func ListTasks(w http.ResponseWriter, r *http.Request) error {
+	man := noteManager(r)
+	id, err := parseID(r)
+	if err != nil {
+		return badRequest{err}
+	}
+	n, err := resolve(man, id)
+	if err != nil {
+		return err
+	}
+	ts, err := man.Tasks(n.ID)
+	if err == notes.ErrNotFound {
+		return notFound{err}
+	}
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(w).Encode(ts)
+}
+
+// UpdateTask handles PATCH requests to /note/{ID}/tasks/{N}, where N is the
+// ID of the task or its number. Numbers refer to other tasks once tasks are
+// added above, so clients should prefer IDs. The request body may contain a
+// JSON object with a Done field to check or uncheck the task, without it
+// the task is toggled. The content of the note is rewritten and the task
+// returned.
+//
+// Examples:
+//
+//   req: PATCH /note/abcdefg123/tasks/5d133608
+//   res: 200 {"Note": "abcdefg123", "ID": "5d133608", "N": 2, "Text": "Buy bread", "Done": true}
+//
+//   req: PATCH /note/abcdefg123/tasks/1 {"Done": false}
+//   res: 200 {"Note": "abcdefg123", "ID": "520743e3", "N": 1, "Text": "Buy milk", "Done": false}
+//
+//   req: PATCH /note/abcdefg123/tasks/42
+//   res: 404 note not found
+func UpdateTask(w http.ResponseWriter, r *http.Request) error {
+	man := noteManager(r)
+	id, err := parseID(r)
+	if err != nil {
+		return badRequest{err}
+	}
+	task, err := parseTask(r)
+	if err != nil {
+		return badRequest{err}
+	}
+	req := struct{ Done *bool }{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		return badRequest{err}
+	}
+	n, err := resolve(man, id)
+	if err != nil {
+		return err
+	}
+	var t notes.Task
+	if req.Done != nil {
+		t, err = man.SetTask(n.ID, task, *req.Done)
+	} else {
+		t, err = man.ToggleTask(n.ID, task)
+	}
+	if err == notes.ErrNotFound || err == notes.ErrNoTask {
+		return notFound{err}
+	}
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(w).Encode(t)
+}
+
+// OpenTasks handles GET requests to /tasks.
+// It returns the tasks left to do in every note or, given a q parameter, in
+// the notes matching the tag query q, as for /note/filter.
+//
+// Examples:
+//
+//   req: GET /tasks?q=#work
+//   res: 200 [{"Note": "abcdefg123", "ID": "ae14085b", "N": 1, "Text": "Write report", "Done": false}]
+//
+//   req: GET /tasks?q=#work AND
+//   res: 400 tag query "#work AND": unexpected end of query at offset 9
+func OpenTasks(w http.ResponseWriter, r *http.Request) error {
+	man := noteManager(r)
+	ts, err := man.OpenTasks(r.FormValue("q"))
+	if err != nil {
+		return badRequest{err}
+	}
+	return json.NewEncoder(w).Encode(ts)
+}